@@ -1,72 +1,230 @@
 package api
 
 import (
+	"context"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/jmoiron/sqlx"
 	"github.com/kermesse-backend/api/handler"
+	"github.com/kermesse-backend/api/middleware"
+	"github.com/kermesse-backend/internal/admin"
+	"github.com/kermesse-backend/internal/approvals"
+	"github.com/kermesse-backend/internal/audit"
+	"github.com/kermesse-backend/internal/guardians"
+	"github.com/kermesse-backend/internal/idempotency"
 	"github.com/kermesse-backend/internal/kermesses"
+	"github.com/kermesse-backend/internal/limits"
+	"github.com/kermesse-backend/internal/mail"
+	"github.com/kermesse-backend/internal/notifications"
 	"github.com/kermesse-backend/internal/participations"
+	"github.com/kermesse-backend/internal/policy"
+	"github.com/kermesse-backend/internal/push"
+	"github.com/kermesse-backend/internal/ratelimit"
 	"github.com/kermesse-backend/internal/stands"
 	"github.com/kermesse-backend/internal/tickets"
 	"github.com/kermesse-backend/internal/tombolas"
+	"github.com/kermesse-backend/internal/types"
 	"github.com/kermesse-backend/internal/users"
+	"github.com/kermesse-backend/internal/webhooks"
 	httpSwagger "github.com/swaggo/http-swagger"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 )
 
 type APIServer struct {
-	address string
-	db      *sqlx.DB
+	address        string
+	db             *sqlx.DB
+	broker         notifications.Broker
+	rateLimitStore ratelimit.Store
 }
 
-func NewAPIServer(address string, db *sqlx.DB) *APIServer {
+func NewAPIServer(address string, db *sqlx.DB, broker notifications.Broker, rateLimitStore ratelimit.Store) *APIServer {
 	return &APIServer{
-		address: address,
-		db:      db,
+		address:        address,
+		db:             db,
+		broker:         broker,
+		rateLimitStore: rateLimitStore,
 	}
 }
 
+// shutdownTimeout is the time left to the in-flight requests to complete once
+// the server is asked to stop.
+const shutdownTimeout = 10 * time.Second
+
+// defaultRateLimit applies to the routes missing from routeRateLimits.
+var defaultRateLimit = ratelimit.Limit{Requests: 300, Period: time.Minute}
+
+// routeRateLimits are tighter on the routes a script would hammer: guessing
+// passwords, creating accounts and spending jetons.
+var routeRateLimits = map[string]ratelimit.Limit{
+	"POST /login":          {Requests: 10, Period: time.Minute},
+	"POST /register":       {Requests: 5, Period: time.Hour},
+	"POST /participations": {Requests: 30, Period: time.Minute},
+}
+
+// Start serves the API until the process receives SIGINT or SIGTERM, then
+// closes the real-time connections and shuts the server down gracefully.
 func (s *APIServer) Start() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	trustedProxies, err := middleware.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return err
+	}
+
 	router := mux.NewRouter()
+	router.Use(middleware.RequestContext(trustedProxies))
+
+	rateLimiter := middleware.NewRateLimiter(s.rateLimitStore, defaultRateLimit, routeRateLimits)
+	router.Use(rateLimiter.Middleware)
+
+	userRepository := users.NewUsersRepository(s.db)
+
+	idempotencyRepository := idempotency.NewIdempotencyRepository(s.db)
+	router.Use(middleware.Idempotency(idempotencyRepository, userRepository))
+	idempotencyWorker := idempotency.NewWorker(idempotencyRepository, time.Hour)
+	go idempotencyWorker.Start(ctx)
 
 	router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
 	}).Methods(http.MethodGet)
 
-	userRepository := users.NewUsersRepository(s.db)
-	userService := users.NewUsersService(userRepository)
+	notificationRepository := notifications.NewNotificationsRepository(s.db)
+	hub := notifications.NewHub(notificationRepository, s.broker)
+
+	var pushProvider push.Provider
+	if os.Getenv("PUSH_PROVIDER") == "fcm" {
+		fcmProvider, err := push.NewFCMProvider(os.Getenv("FCM_ENDPOINT"), os.Getenv("FCM_CREDENTIALS_FILE"))
+		if err != nil {
+			return err
+		}
+		pushProvider = fcmProvider
+	} else {
+		pushProvider = push.NewFakeProvider()
+	}
+	deviceTokenRepository := push.NewDeviceTokensRepository(s.db)
+	pushService := push.NewPushService(deviceTokenRepository, pushProvider)
+
+	policyRepository := policy.NewPolicyRepository(s.db)
+	policyService := policy.NewPolicyService(policyRepository)
+
+	auditRepository := audit.NewAuditRepository(s.db)
+	auditService := audit.NewAuditService(auditRepository, policyService)
+
+	userService := users.NewUsersService(userRepository, notificationRepository, hub, pushService, policyService)
 	userHandler := handler.NewUserHandler(userService, userRepository)
 	userHandler.RegisterRoutes(router)
 
+	guardianRepository := guardians.NewGuardiansRepository(s.db)
+	var mailSender mail.Sender
+	if os.Getenv("MAIL_PROVIDER") == "smtp" {
+		smtpSender, err := mail.NewSMTPSender(os.Getenv("SMTP_HOST"), os.Getenv("SMTP_PORT"), os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), os.Getenv("MAIL_FROM"))
+		if err != nil {
+			return err
+		}
+		mailSender = smtpSender
+	} else {
+		mailSender = mail.NewFakeSender()
+	}
+	invitationTimeout, err := strconv.Atoi(os.Getenv("GUARDIAN_INVITATION_TIMEOUT"))
+	if err != nil || invitationTimeout <= 0 {
+		invitationTimeout = 604800
+	}
+	guardianService := guardians.NewGuardiansService(guardianRepository, userRepository, hub, policyService, mailSender, os.Getenv("APP_URL"), time.Duration(invitationTimeout)*time.Second)
+	guardianHandler := handler.NewGuardiansHandler(guardianService, userRepository)
+	guardianHandler.RegisterRoutes(router)
+
+	notificationService := notifications.NewNotificationsService(notificationRepository)
+	notificationHandler := handler.NewNotificationsHandler(notificationService, userRepository)
+	notificationHandler.RegisterRoutes(router)
+
+	deviceHandler := handler.NewDevicesHandler(pushService, userRepository)
+	deviceHandler.RegisterRoutes(router)
+
 	standRepository := stands.NewStandsRepository(s.db)
-	standService := stands.NewStandsService(standRepository)
+	standService := stands.NewStandsService(standRepository, auditService)
 	standHandler := handler.NewStandsHandler(standService, userRepository)
 	standHandler.RegisterRoutes(router)
 
 	kermesseRepository := kermesses.NewkermessesRepository(s.db)
-	kermesseService := kermesses.NewKermessesService(kermesseRepository, userRepository)
+	kermesseService := kermesses.NewKermessesService(kermesseRepository, userRepository, policyService, auditService)
 	kermesseHandler := handler.NewKermessesHandler(kermesseService, userRepository)
 	kermesseHandler.RegisterRoutes(router)
 
+	limitRepository := limits.NewLimitsRepository(s.db)
+	limitService := limits.NewLimitsService(limitRepository, userRepository, policyService)
+	limitHandler := handler.NewLimitsHandler(limitService, userRepository)
+	limitHandler.RegisterRoutes(router)
+
+	approvalTimeout, err := strconv.Atoi(os.Getenv("PURCHASE_APPROVAL_TIMEOUT"))
+	if err != nil || approvalTimeout <= 0 {
+		approvalTimeout = 900
+	}
+	approvalRepository := approvals.NewApprovalsRepository(s.db)
+	approvalService := approvals.NewApprovalsService(approvalRepository, userRepository, hub, pushService, time.Duration(approvalTimeout)*time.Second, policyService)
+	purchaseRequestHandler := handler.NewPurchaseRequestsHandler(approvalService, userRepository)
+	purchaseRequestHandler.RegisterRoutes(router)
+
+	approvalWorker := approvals.NewWorker(approvalService, 30*time.Second)
+	go approvalWorker.Start(ctx)
+
 	participationRepository := participations.NewParticipationsRepository(s.db)
-	participationService := participations.NewParticipationsService(userRepository, kermesseRepository, participationRepository, standRepository)
+	participationService := participations.NewParticipationsService(userRepository, kermesseRepository, participationRepository, standRepository, hub, pushService, limitService, approvalService, policyService, auditService)
+	approvalService.RegisterPurchaseHandler(types.PurchaseKindParticipation, participationService)
 	participationHandler := handler.NewParticipationsHandler(participationService, userRepository)
 	participationHandler.RegisterRoutes(router)
 
 	tombolaRepository := tombolas.NewTombolasRepository(s.db)
-	tombolaService := tombolas.NewTombolasService(tombolaRepository, kermesseRepository)
+	tombolaService := tombolas.NewTombolasService(tombolaRepository, kermesseRepository, hub, pushService, policyService, auditService)
 	tombolaHandler := handler.NewTombolasHandler(tombolaService, userRepository)
 	tombolaHandler.RegisterRoutes(router)
 
+	drawInterval, err := strconv.Atoi(os.Getenv("TOMBOLA_DRAW_INTERVAL"))
+	if err != nil || drawInterval <= 0 {
+		drawInterval = 60
+	}
+	tombolaScheduler := tombolas.NewScheduler(tombolaService, tombolaRepository, time.Duration(drawInterval)*time.Second)
+	go tombolaScheduler.Start(ctx)
+
+	webhookRepository := webhooks.NewWebhooksRepository(s.db)
+	webhookService := webhooks.NewWebhooksService(webhookRepository, kermesseRepository, policyService, auditService)
+	webhookEndpointHandler := handler.NewWebhookEndpointsHandler(webhookService, userRepository)
+	webhookEndpointHandler.RegisterRoutes(router)
+
+	webhookInterval, err := strconv.Atoi(os.Getenv("WEBHOOK_DELIVERY_INTERVAL"))
+	if err != nil || webhookInterval <= 0 {
+		webhookInterval = 10
+	}
+	webhookWorker := webhooks.NewWorker(webhookRepository, time.Duration(webhookInterval)*time.Second)
+	go webhookWorker.Start(ctx)
+
 	ticketRepository := tickets.NewTicketsRepository(s.db)
-	ticketService := tickets.NewTicketsService(ticketRepository, tombolaRepository, userRepository, kermesseRepository)
+	ticketService := tickets.NewTicketsService(ticketRepository, tombolaRepository, userRepository, kermesseRepository, hub, limitService, approvalService, policyService, auditService)
+	approvalService.RegisterPurchaseHandler(types.PurchaseKindTicket, ticketService)
 	ticketHandler := handler.NewTicketsHandler(ticketService, userRepository)
 	ticketHandler.RegisterRoutes(router)
 
+	adminRepository := admin.NewAdminRepository(s.db)
+	adminService := admin.NewAdminService(adminRepository, userRepository, kermesseRepository, tombolaService, hub)
+	adminHandler := handler.NewAdminHandler(adminService, userRepository)
+	adminHandler.RegisterRoutes(router)
+
+	auditEventHandler := handler.NewAuditEventsHandler(auditService, userRepository)
+	auditEventHandler.RegisterRoutes(router)
+
+	if email := os.Getenv("ADMIN_EMAIL"); email != "" {
+		if err := adminService.EnsureAdmin(email); err != nil {
+			log.Printf("Unable to make %s an administrator: %v", email, err)
+		}
+	}
+
 	router.PathPrefix("/docs/swagger.json").Handler(http.StripPrefix("/docs", http.FileServer(http.Dir("./docs"))))
 	router.PathPrefix("/swagger/").Handler(httpSwagger.Handler(
 		httpSwagger.URL(os.Getenv("SWAGGER_URL")),
@@ -74,21 +232,55 @@ func (s *APIServer) Start() error {
 
 	router.HandleFunc("/webhook", handler.HandleWebhook(userService)).Methods(http.MethodPost)
 
-	websocketHandler := handler.NewWebSocketHandler()
+	websocketHandler := handler.NewWebSocketHandler(hub, userRepository, policyService, standRepository, tombolaRepository)
 	router.HandleFunc("/ws", websocketHandler.HandleWebSocket).Methods(http.MethodGet)
 
+	eventStreamHandler := handler.NewEventStreamHandler(hub, notificationService, userRepository, policyService, standRepository, tombolaRepository)
+	eventStreamHandler.RegisterRoutes(router)
+
 	cors := handlers.CORS(
 		handlers.AllowedOrigins([]string{"*"}),
 		handlers.AllowedMethods([]string{
 			http.MethodGet,
 			http.MethodPost,
 			http.MethodPut,
+			http.MethodPatch,
 			http.MethodDelete,
 			http.MethodOptions,
 		}),
-		handlers.AllowedHeaders([]string{"Content-Type", "Authorization"}),
+		handlers.AllowedHeaders([]string{"Content-Type", "Authorization", "Last-Event-ID", "If-Match", middleware.IdempotencyKeyHeader, middleware.RequestIDHeader}),
+		handlers.ExposedHeaders([]string{
+			"X-Error-Code",
+			"ETag",
+			"Retry-After",
+			middleware.IdempotentReplayedHeader,
+			middleware.RateLimitLimitHeader,
+			middleware.RateLimitRemainingHeader,
+			middleware.RateLimitResetHeader,
+			middleware.RequestIDHeader,
+		}),
 	)
 
-	log.Printf("🚀 Starting server on %s", s.address)
-	return http.ListenAndServe(s.address, cors(router))
+	server := &http.Server{
+		Addr:    s.address,
+		Handler: cors(router),
+	}
+	serverErrors := make(chan error, 1)
+	go func() {
+		log.Printf("🚀 Starting server on %s", s.address)
+		serverErrors <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErrors:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down server")
+	// hijacked WebSocket connections are not tracked by the server
+	hub.Shutdown()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	return server.Shutdown(shutdownCtx)
 }
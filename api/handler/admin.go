@@ -0,0 +1,223 @@
+package handler
+
+import (
+	goErrors "errors"
+	"github.com/gorilla/mux"
+	"github.com/kermesse-backend/api/middleware"
+	"github.com/kermesse-backend/internal/admin"
+	"github.com/kermesse-backend/internal/policy"
+	"github.com/kermesse-backend/internal/users"
+	"github.com/kermesse-backend/pkg/errors"
+	"github.com/kermesse-backend/pkg/json"
+	"github.com/kermesse-backend/pkg/utils"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+type AdminHandler struct {
+	adminService    admin.AdminService
+	usersRepository users.UsersRepository
+}
+
+func NewAdminHandler(adminService admin.AdminService, usersRepository users.UsersRepository) *AdminHandler {
+	return &AdminHandler{
+		adminService:    adminService,
+		usersRepository: usersRepository,
+	}
+}
+
+func (h *AdminHandler) RegisterRoutes(mux *mux.Router) {
+	mux.Handle("/admin/users", errors.ErrorHandler(middleware.IsAuth(h.SearchUsers, h.usersRepository, policy.Roles(policy.AdminAccess)...))).Methods(http.MethodGet)
+	mux.Handle("/admin/users/{id}", errors.ErrorHandler(middleware.IsAuth(h.DeleteUser, h.usersRepository, policy.Roles(policy.AdminAccess)...))).Methods(http.MethodDelete)
+	mux.Handle("/admin/users/{id}/role", errors.ErrorHandler(middleware.IsAuth(h.ChangeRole, h.usersRepository, policy.Roles(policy.AdminAccess)...))).Methods(http.MethodPatch)
+	mux.Handle("/admin/users/{id}/balance", errors.ErrorHandler(middleware.IsAuth(h.AdjustBalance, h.usersRepository, policy.Roles(policy.AdminAccess)...))).Methods(http.MethodPatch)
+	mux.Handle("/admin/kermesses/{id}/complete", errors.ErrorHandler(middleware.IsAuth(h.CompleteKermesse, h.usersRepository, policy.Roles(policy.AdminAccess)...))).Methods(http.MethodPatch)
+	mux.Handle("/admin/kermesses/{id}/reopen", errors.ErrorHandler(middleware.IsAuth(h.ReopenKermesse, h.usersRepository, policy.Roles(policy.AdminAccess)...))).Methods(http.MethodPatch)
+	mux.Handle("/admin/tombolas/{id}/redraw", errors.ErrorHandler(middleware.IsAuth(h.RedrawTombola, h.usersRepository, policy.Roles(policy.AdminAccess)...))).Methods(http.MethodPost)
+}
+
+func (h *AdminHandler) SearchUsers(w http.ResponseWriter, r *http.Request) error {
+	users, err := h.adminService.SearchUsers(utils.GetParams(r))
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusOK, users); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *AdminHandler) ChangeRole(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	var input map[string]interface{}
+	if err := json.Parse(r, &input); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	user, err := h.adminService.ChangeRole(r.Context(), id, input)
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusOK, user); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *AdminHandler) AdjustBalance(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	var input map[string]interface{}
+	if err := json.Parse(r, &input); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	user, err := h.adminService.AdjustBalance(r.Context(), id, input)
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusOK, user); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *AdminHandler) DeleteUser(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	input, err := parseOptionalBody(r)
+	if err != nil {
+		return err
+	}
+	if err := h.adminService.DeleteUser(r.Context(), id, input); err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusAccepted, nil); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *AdminHandler) CompleteKermesse(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	input, err := parseOptionalBody(r)
+	if err != nil {
+		return err
+	}
+	if err := h.adminService.CompleteKermesse(r.Context(), id, input); err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusAccepted, nil); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *AdminHandler) ReopenKermesse(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	input, err := parseOptionalBody(r)
+	if err != nil {
+		return err
+	}
+	if err := h.adminService.ReopenKermesse(r.Context(), id, input); err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusAccepted, nil); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *AdminHandler) RedrawTombola(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	input, err := parseOptionalBody(r)
+	if err != nil {
+		return err
+	}
+	if err := h.adminService.RedrawTombola(r.Context(), id, input); err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusAccepted, nil); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+// parseOptionalBody reads the body of the routes where it only carries an
+// optional reason, an empty body is an empty input.
+func parseOptionalBody(r *http.Request) (map[string]interface{}, error) {
+	input := make(map[string]interface{})
+	if err := json.Parse(r, &input); err != nil && !goErrors.Is(err, io.EOF) {
+		return nil, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: err,
+		}
+	}
+	return input, nil
+}
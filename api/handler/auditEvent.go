@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"bytes"
+	"github.com/gorilla/mux"
+	"github.com/kermesse-backend/api/middleware"
+	"github.com/kermesse-backend/internal/audit"
+	"github.com/kermesse-backend/internal/policy"
+	"github.com/kermesse-backend/internal/users"
+	"github.com/kermesse-backend/pkg/errors"
+	"github.com/kermesse-backend/pkg/json"
+	"github.com/kermesse-backend/pkg/utils"
+	"net/http"
+)
+
+type AuditEventHandler struct {
+	auditService    audit.AuditService
+	usersRepository users.UsersRepository
+}
+
+func NewAuditEventsHandler(auditService audit.AuditService, usersRepository users.UsersRepository) *AuditEventHandler {
+	return &AuditEventHandler{
+		auditService:    auditService,
+		usersRepository: usersRepository,
+	}
+}
+
+func (h *AuditEventHandler) RegisterRoutes(mux *mux.Router) {
+	mux.Handle("/audit-events", errors.ErrorHandler(middleware.IsAuth(h.GetAuditEvents, h.usersRepository, policy.Roles(policy.AuditView)...))).Methods(http.MethodGet)
+	mux.Handle("/audit-events/export", errors.ErrorHandler(middleware.IsAuth(h.ExportAuditEvents, h.usersRepository, policy.Roles(policy.AuditView)...))).Methods(http.MethodGet)
+}
+
+func (h *AuditEventHandler) GetAuditEvents(w http.ResponseWriter, r *http.Request) error {
+	events, err := h.auditService.GetEvents(r.Context(), utils.GetParams(r))
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusOK, events); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+// ExportAuditEvents builds the whole CSV before answering, so that an error
+// is still returned as JSON.
+func (h *AuditEventHandler) ExportAuditEvents(w http.ResponseWriter, r *http.Request) error {
+	var buffer bytes.Buffer
+	if err := h.auditService.ExportEvents(r.Context(), utils.GetParams(r), &buffer); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit-events.csv"`)
+	w.WriteHeader(http.StatusOK)
+	if _, err := buffer.WriteTo(w); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
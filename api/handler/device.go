@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/kermesse-backend/api/middleware"
+	"github.com/kermesse-backend/internal/push"
+	"github.com/kermesse-backend/internal/users"
+	"github.com/kermesse-backend/pkg/errors"
+	"github.com/kermesse-backend/pkg/json"
+	"net/http"
+)
+
+type DeviceHandler struct {
+	pushService     push.PushService
+	usersRepository users.UsersRepository
+}
+
+func NewDevicesHandler(pushService push.PushService, usersRepository users.UsersRepository) *DeviceHandler {
+	return &DeviceHandler{
+		pushService:     pushService,
+		usersRepository: usersRepository,
+	}
+}
+
+func (h *DeviceHandler) RegisterRoutes(mux *mux.Router) {
+	mux.Handle("/devices", errors.ErrorHandler(middleware.IsAuth(h.RegisterDevice, h.usersRepository))).Methods(http.MethodPost)
+	mux.Handle("/devices/{token}", errors.ErrorHandler(middleware.IsAuth(h.UnregisterDevice, h.usersRepository))).Methods(http.MethodDelete)
+}
+
+func (h *DeviceHandler) RegisterDevice(w http.ResponseWriter, r *http.Request) error {
+	var input map[string]interface{}
+	if err := json.Parse(r, &input); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	if err := h.pushService.RegisterDevice(r.Context(), input); err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusCreated, nil); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *DeviceHandler) UnregisterDevice(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	if err := h.pushService.UnregisterDevice(r.Context(), vars["token"]); err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusAccepted, nil); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
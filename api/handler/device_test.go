@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"context"
+	goErrors "errors"
+	"github.com/gorilla/mux"
+	"github.com/kermesse-backend/internal/push"
+	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/pkg/errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeDeviceTokensRepository keeps the tokens in memory, keyed by token like
+// the device_tokens table.
+type fakeDeviceTokensRepository struct {
+	tokens map[string]types.DeviceToken
+	err    error
+}
+
+func newFakeDeviceTokensRepository() *fakeDeviceTokensRepository {
+	return &fakeDeviceTokensRepository{tokens: make(map[string]types.DeviceToken)}
+}
+
+func (repository *fakeDeviceTokensRepository) GetDeviceTokensByUserId(userId int) ([]types.DeviceToken, error) {
+	var tokens []types.DeviceToken
+	for _, token := range repository.tokens {
+		if token.UserId == userId {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens, repository.err
+}
+
+func (repository *fakeDeviceTokensRepository) AddDeviceToken(input map[string]interface{}) error {
+	if repository.err != nil {
+		return repository.err
+	}
+	token := input["token"].(string)
+	repository.tokens[token] = types.DeviceToken{
+		UserId:   input["user_id"].(int),
+		Token:    token,
+		Platform: input["platform"].(string),
+	}
+	return nil
+}
+
+func (repository *fakeDeviceTokensRepository) DeleteDeviceToken(userId int, token string) error {
+	if repository.err != nil {
+		return repository.err
+	}
+	if repository.tokens[token].UserId == userId {
+		delete(repository.tokens, token)
+	}
+	return nil
+}
+
+func (repository *fakeDeviceTokensRepository) DeleteToken(token string) error {
+	delete(repository.tokens, token)
+	return repository.err
+}
+
+const deviceUserId = 7
+
+// serveDevices routes the request to the device endpoints as the user, or
+// anonymously when userId is 0. The authentication middleware is left out,
+// it only sets the session keys.
+func serveDevices(repository *fakeDeviceTokensRepository, userId int, method string, target string, body string) *httptest.ResponseRecorder {
+	h := NewDevicesHandler(push.NewPushService(repository, push.NewFakeProvider()), nil)
+	router := mux.NewRouter()
+	router.Handle("/devices", errors.ErrorHandler(h.RegisterDevice)).Methods(http.MethodPost)
+	router.Handle("/devices/{token}", errors.ErrorHandler(h.UnregisterDevice)).Methods(http.MethodDelete)
+
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	if userId != 0 {
+		request = request.WithContext(context.WithValue(request.Context(), types.UserIDSessionKey, userId))
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestRegisterDevice(t *testing.T) {
+	tests := []struct {
+		name       string
+		userId     int
+		body       string
+		err        error
+		wantStatus int
+	}{
+		{"android", deviceUserId, `{"token": "abc", "platform": "ANDROID"}`, nil, http.StatusCreated},
+		{"ios", deviceUserId, `{"token": "abc", "platform": "IOS"}`, nil, http.StatusCreated},
+		{"web", deviceUserId, `{"token": "abc", "platform": "WEB"}`, nil, http.StatusCreated},
+		{"missing token", deviceUserId, `{"platform": "ANDROID"}`, nil, http.StatusBadRequest},
+		{"empty token", deviceUserId, `{"token": "", "platform": "ANDROID"}`, nil, http.StatusBadRequest},
+		{"unknown platform", deviceUserId, `{"token": "abc", "platform": "SYMBIAN"}`, nil, http.StatusBadRequest},
+		{"no session", 0, `{"token": "abc", "platform": "ANDROID"}`, nil, http.StatusUnauthorized},
+		{"repository error", deviceUserId, `{"token": "abc", "platform": "ANDROID"}`, goErrors.New("connection lost"), http.StatusInternalServerError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repository := newFakeDeviceTokensRepository()
+			repository.err = test.err
+
+			recorder := serveDevices(repository, test.userId, http.MethodPost, "/devices", test.body)
+			if recorder.Code != test.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, test.wantStatus, recorder.Body)
+			}
+			_, registered := repository.tokens["abc"]
+			if want := test.wantStatus == http.StatusCreated; registered != want {
+				t.Errorf("token registered = %v, want %v", registered, want)
+			}
+		})
+	}
+}
+
+func TestRegisterDeviceMovesSharedToken(t *testing.T) {
+	repository := newFakeDeviceTokensRepository()
+	repository.tokens["abc"] = types.DeviceToken{UserId: deviceUserId + 1, Token: "abc", Platform: types.DevicePlatformAndroid}
+
+	recorder := serveDevices(repository, deviceUserId, http.MethodPost, "/devices", `{"token": "abc", "platform": "IOS"}`)
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want %d", recorder.Code, http.StatusCreated)
+	}
+	if token := repository.tokens["abc"]; token.UserId != deviceUserId || token.Platform != types.DevicePlatformIos {
+		t.Errorf("token = %+v, want it moved to user %d", token, deviceUserId)
+	}
+}
+
+func TestUnregisterDevice(t *testing.T) {
+	tests := []struct {
+		name        string
+		userId      int
+		owner       int
+		err         error
+		wantStatus  int
+		wantDeleted bool
+	}{
+		{"own token", deviceUserId, deviceUserId, nil, http.StatusAccepted, true},
+		{"token of another user", deviceUserId, deviceUserId + 1, nil, http.StatusAccepted, false},
+		{"no session", 0, deviceUserId, nil, http.StatusUnauthorized, false},
+		{"repository error", deviceUserId, deviceUserId, goErrors.New("connection lost"), http.StatusInternalServerError, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repository := newFakeDeviceTokensRepository()
+			repository.tokens["abc"] = types.DeviceToken{UserId: test.owner, Token: "abc", Platform: types.DevicePlatformWeb}
+			repository.err = test.err
+
+			recorder := serveDevices(repository, test.userId, http.MethodDelete, "/devices/abc", "")
+			if recorder.Code != test.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, test.wantStatus, recorder.Body)
+			}
+			if _, exists := repository.tokens["abc"]; exists == test.wantDeleted {
+				t.Errorf("token deleted = %v, want %v", !exists, test.wantDeleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package handler
+
+import (
+	encodingJson "encoding/json"
+	goErrors "errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/kermesse-backend/api/middleware"
+	"github.com/kermesse-backend/internal/notifications"
+	"github.com/kermesse-backend/internal/policy"
+	"github.com/kermesse-backend/internal/stands"
+	"github.com/kermesse-backend/internal/tombolas"
+	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/internal/users"
+	"github.com/kermesse-backend/pkg/errors"
+	"github.com/kermesse-backend/pkg/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// heartbeatInterval keeps proxies from closing an idle stream.
+const heartbeatInterval = 25 * time.Second
+
+// EventStreamHandler streams the notification events as Server-Sent Events,
+// for the clients whose network does not let WebSocket upgrades through.
+type EventStreamHandler struct {
+	topicAuthorizer
+	hub                  *notifications.Hub
+	notificationsService notifications.NotificationsService
+	usersRepository      users.UsersRepository
+}
+
+func NewEventStreamHandler(hub *notifications.Hub, notificationsService notifications.NotificationsService, usersRepository users.UsersRepository, policyService policy.PolicyService, standsRepository stands.StandsRepository, tombolasRepository tombolas.TombolaRepository) *EventStreamHandler {
+	return &EventStreamHandler{
+		hub:                  hub,
+		notificationsService: notificationsService,
+		usersRepository:      usersRepository,
+		topicAuthorizer: topicAuthorizer{
+			policyService:      policyService,
+			standsRepository:   standsRepository,
+			tombolasRepository: tombolasRepository,
+		},
+	}
+}
+
+func (h *EventStreamHandler) RegisterRoutes(mux *mux.Router) {
+	mux.Handle("/events", errors.ErrorHandler(h.HandleEvents)).Methods(http.MethodGet)
+	mux.Handle("/events/tickets", errors.ErrorHandler(middleware.IsAuth(h.IssueTicket, h.usersRepository))).Methods(http.MethodPost)
+}
+
+// IssueTicket returns a single-use ticket opening the stream, for the browsers
+// whose EventSource cannot send the Authorization header.
+func (h *EventStreamHandler) IssueTicket(w http.ResponseWriter, r *http.Request) error {
+	ticket, err := h.notificationsService.IssueStreamTicket(r.Context())
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusCreated, ticket); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+// HandleEvents streams the events of the user and of the topics listed in the
+// comma separated topics query parameter. The events missed since the
+// Last-Event-ID header, or the last_event_id query parameter, are sent first.
+func (h *EventStreamHandler) HandleEvents(w http.ResponseWriter, r *http.Request) error {
+	user, expiresAt, err := h.authenticate(r)
+	if err != nil {
+		return err
+	}
+
+	var topics []string
+	if param := r.URL.Query().Get("topics"); param != "" {
+		topics = strings.Split(param, ",")
+	}
+	for _, topic := range topics {
+		if !h.canSubscribe(user.Id, topic) {
+			return errors.CustomError{
+				Key: errors.Forbidden,
+				Err: fmt.Errorf("forbidden topic %s", topic),
+			}
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: goErrors.New("streaming is not supported"),
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	client := h.hub.RegisterStream(user.Id)
+	defer h.hub.Unregister(client)
+	for _, topic := range topics {
+		h.hub.Subscribe(client, topic)
+	}
+
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = r.URL.Query().Get("last_event_id")
+	}
+	if id, err := strconv.Atoi(lastEventId); err == nil {
+		h.hub.Replay(client, id)
+	}
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	// the stream cannot outlive the token it was opened with
+	expiration := time.NewTimer(time.Until(expiresAt))
+	defer expiration.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case <-client.Done():
+			return nil
+		case <-expiration.C:
+			fmt.Fprint(w, "event: expired\ndata: {}\n\n")
+			flusher.Flush()
+			return nil
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case message, ok := <-client.Messages():
+			if !ok {
+				return nil
+			}
+			writeServerSentEvent(w, message)
+			flusher.Flush()
+		}
+	}
+}
+
+// authenticate accepts the Authorization header or a stream ticket in the
+// ticket query parameter. Either way the stream ends when the token expires.
+func (h *EventStreamHandler) authenticate(r *http.Request) (types.User, time.Time, error) {
+	if ticket := r.URL.Query().Get("ticket"); ticket != "" {
+		userId, expiresAt, err := h.notificationsService.RedeemStreamTicket(ticket)
+		if err != nil {
+			return types.User{}, time.Time{}, err
+		}
+		if !expiresAt.After(time.Now()) {
+			return types.User{}, time.Time{}, errors.CustomError{
+				Key: errors.Unauthorized,
+				Err: goErrors.New("token expired"),
+			}
+		}
+		user, err := h.usersRepository.GetUserById(userId)
+		if err != nil {
+			return types.User{}, time.Time{}, err
+		}
+		return user, expiresAt, nil
+	}
+
+	tokenParts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
+		return types.User{}, time.Time{}, errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("token or ticket is required"),
+		}
+	}
+	return middleware.Authenticate(tokenParts[1], h.usersRepository)
+}
+
+// writeServerSentEvent writes the message as an SSE frame, using the id of the
+// inbox notification, if any, as the event id so that the browser sends it
+// back in Last-Event-ID when it reconnects.
+func writeServerSentEvent(w http.ResponseWriter, message []byte) {
+	var event struct {
+		Id int `json:"id"`
+	}
+	if err := encodingJson.Unmarshal(message, &event); err == nil && event.Id > 0 {
+		fmt.Fprintf(w, "id: %d\n", event.Id)
+	}
+	fmt.Fprintf(w, "data: %s\n\n", message)
+}
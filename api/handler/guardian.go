@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/kermesse-backend/api/middleware"
+	"github.com/kermesse-backend/internal/guardians"
+	"github.com/kermesse-backend/internal/policy"
+	"github.com/kermesse-backend/internal/users"
+	"github.com/kermesse-backend/pkg/errors"
+	"github.com/kermesse-backend/pkg/json"
+	"net/http"
+	"strconv"
+)
+
+type GuardianHandler struct {
+	guardiansService guardians.GuardiansService
+	usersRepository  users.UsersRepository
+}
+
+func NewGuardiansHandler(guardiansService guardians.GuardiansService, usersRepository users.UsersRepository) *GuardianHandler {
+	return &GuardianHandler{
+		guardiansService: guardiansService,
+		usersRepository:  usersRepository,
+	}
+}
+
+func (h *GuardianHandler) RegisterRoutes(mux *mux.Router) {
+	mux.Handle("/users/{id}/guardians", errors.ErrorHandler(middleware.IsAuth(h.GetGuardians, h.usersRepository, policy.Roles(policy.StudentView)...))).Methods(http.MethodGet)
+	mux.Handle("/users/{id}/guardians/invitations", errors.ErrorHandler(middleware.IsAuth(h.InviteGuardian, h.usersRepository, policy.Roles(policy.StudentManage)...))).Methods(http.MethodPost)
+	mux.Handle("/users/{id}/guardians/{guardianId}", errors.ErrorHandler(middleware.IsAuth(h.RemoveGuardian, h.usersRepository, policy.Roles(policy.StudentManage)...))).Methods(http.MethodDelete)
+	mux.Handle("/guardian-invitations", errors.ErrorHandler(middleware.IsAuth(h.GetInvitations, h.usersRepository, policy.Roles(policy.InvitationRespond)...))).Methods(http.MethodGet)
+	mux.Handle("/guardian-invitations/{id}/accept", errors.ErrorHandler(middleware.IsAuth(h.AcceptInvitation, h.usersRepository, policy.Roles(policy.InvitationRespond)...))).Methods(http.MethodPost)
+	mux.Handle("/guardian-invitations/{id}/decline", errors.ErrorHandler(middleware.IsAuth(h.DeclineInvitation, h.usersRepository, policy.Roles(policy.InvitationRespond)...))).Methods(http.MethodPost)
+}
+
+func (h *GuardianHandler) GetGuardians(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	guardians, err := h.guardiansService.GetGuardians(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusOK, guardians); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *GuardianHandler) InviteGuardian(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	var input map[string]interface{}
+	if err := json.Parse(r, &input); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	invitation, err := h.guardiansService.InviteGuardian(r.Context(), id, input)
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusCreated, invitation); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *GuardianHandler) RemoveGuardian(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	guardianId, err := strconv.Atoi(vars["guardianId"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	if err := h.guardiansService.RemoveGuardian(r.Context(), id, guardianId); err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusAccepted, nil); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *GuardianHandler) GetInvitations(w http.ResponseWriter, r *http.Request) error {
+	invitations, err := h.guardiansService.GetInvitations(r.Context())
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusOK, invitations); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *GuardianHandler) AcceptInvitation(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	var input map[string]interface{}
+	if err := json.Parse(r, &input); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	if err := h.guardiansService.AcceptInvitation(r.Context(), id, input); err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusAccepted, nil); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *GuardianHandler) DeclineInvitation(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	var input map[string]interface{}
+	if err := json.Parse(r, &input); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	if err := h.guardiansService.DeclineInvitation(r.Context(), id, input); err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusAccepted, nil); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
@@ -4,10 +4,11 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/kermesse-backend/api/middleware"
 	"github.com/kermesse-backend/internal/kermesses"
-	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/internal/policy"
 	"github.com/kermesse-backend/internal/users"
 	"github.com/kermesse-backend/pkg/errors"
 	"github.com/kermesse-backend/pkg/json"
+	"github.com/kermesse-backend/pkg/utils"
 	"net/http"
 	"strconv"
 )
@@ -26,13 +27,14 @@ func NewKermessesHandler(kermessesService kermesses.KermessesService, usersRepos
 
 func (handler *KermessesHandler) RegisterRoutes(router *mux.Router) {
 	router.Handle("/kermesses", errors.ErrorHandler(middleware.IsAuth(handler.GetAllKermesses, handler.usersRepository))).Methods(http.MethodGet)
-	router.Handle("/kermesses", errors.ErrorHandler(middleware.IsAuth(handler.CreateKermesse, handler.usersRepository, types.UserRoleOrganizer))).Methods(http.MethodPost)
+	router.Handle("/kermesses", errors.ErrorHandler(middleware.IsAuth(handler.CreateKermesse, handler.usersRepository, policy.Roles(policy.KermesseCreate)...))).Methods(http.MethodPost)
 	router.Handle("/kermesses/{id}", errors.ErrorHandler(middleware.IsAuth(handler.GetKermesseById, handler.usersRepository))).Methods(http.MethodGet)
-	router.Handle("/kermesses/{id}", errors.ErrorHandler(middleware.IsAuth(handler.ModifyKermesse, handler.usersRepository, types.UserRoleOrganizer))).Methods(http.MethodPatch)
-	router.Handle("/kermesses/{id}/complete", errors.ErrorHandler(middleware.IsAuth(handler.CompleteKermesse, handler.usersRepository, types.UserRoleOrganizer))).Methods(http.MethodPatch)
-	router.Handle("/kermesses/{id}/add-user", errors.ErrorHandler(middleware.IsAuth(handler.AssignUserToKermesse, handler.usersRepository, types.UserRoleOrganizer))).Methods(http.MethodPatch)
+	router.Handle("/kermesses/{id}", errors.ErrorHandler(middleware.IsAuth(handler.ModifyKermesse, handler.usersRepository, policy.Roles(policy.KermesseManage)...))).Methods(http.MethodPatch)
+	router.Handle("/kermesses/{id}", errors.ErrorHandler(middleware.IsAuth(handler.DeleteKermesse, handler.usersRepository, policy.Roles(policy.KermesseManage)...))).Methods(http.MethodDelete)
+	router.Handle("/kermesses/{id}/complete", errors.ErrorHandler(middleware.IsAuth(handler.CompleteKermesse, handler.usersRepository, policy.Roles(policy.KermesseManage)...))).Methods(http.MethodPatch)
+	router.Handle("/kermesses/{id}/add-user", errors.ErrorHandler(middleware.IsAuth(handler.AssignUserToKermesse, handler.usersRepository, policy.Roles(policy.KermesseManage)...))).Methods(http.MethodPatch)
 	router.Handle("/kermesses/{id}/users", errors.ErrorHandler(middleware.IsAuth(handler.GetUsersForInvitation, handler.usersRepository))).Methods(http.MethodGet)
-	router.Handle("/kermesses/{id}/add-stand", errors.ErrorHandler(middleware.IsAuth(handler.AssignStandToKermesse, handler.usersRepository, types.UserRoleOrganizer))).Methods(http.MethodPatch)
+	router.Handle("/kermesses/{id}/add-stand", errors.ErrorHandler(middleware.IsAuth(handler.AssignStandToKermesse, handler.usersRepository, policy.Roles(policy.KermesseManage)...))).Methods(http.MethodPatch)
 }
 
 func (handler *KermessesHandler) GetAllKermesses(w http.ResponseWriter, r *http.Request) error {
@@ -84,6 +86,7 @@ func (handler *KermessesHandler) GetKermesseById(w http.ResponseWriter, r *http.
 		return err
 	}
 
+	utils.SetETag(w, kermesse.Version)
 	if err := json.Write(w, http.StatusOK, kermesse); err != nil {
 		return errors.CustomError{
 			Key: errors.InternalServerError,
@@ -110,7 +113,14 @@ func (handler *KermessesHandler) ModifyKermesse(w http.ResponseWriter, r *http.R
 			Err: err,
 		}
 	}
-	if err := handler.kermessesService.UpdateKermesse(r.Context(), id, input); err != nil {
+	version, err := utils.GetIfMatch(r)
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.BadRequest,
+			Err: err,
+		}
+	}
+	if err := handler.kermessesService.UpdateKermesse(r.Context(), id, version, input); err != nil {
 		return err
 	}
 	if err := json.Write(w, http.StatusAccepted, nil); err != nil {
@@ -143,6 +153,27 @@ func (handler *KermessesHandler) CompleteKermesse(w http.ResponseWriter, r *http
 	return nil
 }
 
+func (handler *KermessesHandler) DeleteKermesse(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	if err := handler.kermessesService.DeleteKermesse(r.Context(), id); err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusAccepted, nil); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
 func (handler *KermessesHandler) AssignUserToKermesse(w http.ResponseWriter, r *http.Request) error {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["id"])
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/kermesse-backend/api/middleware"
+	"github.com/kermesse-backend/internal/limits"
+	"github.com/kermesse-backend/internal/policy"
+	"github.com/kermesse-backend/internal/users"
+	"github.com/kermesse-backend/pkg/errors"
+	"github.com/kermesse-backend/pkg/json"
+	"github.com/kermesse-backend/pkg/utils"
+	"net/http"
+	"strconv"
+)
+
+type LimitHandler struct {
+	limitsService   limits.LimitsService
+	usersRepository users.UsersRepository
+}
+
+func NewLimitsHandler(limitsService limits.LimitsService, usersRepository users.UsersRepository) *LimitHandler {
+	return &LimitHandler{
+		limitsService:   limitsService,
+		usersRepository: usersRepository,
+	}
+}
+
+func (h *LimitHandler) RegisterRoutes(mux *mux.Router) {
+	mux.Handle("/users/{id}/spending-limits", errors.ErrorHandler(middleware.IsAuth(h.GetLimits, h.usersRepository, policy.Roles(policy.StudentView)...))).Methods(http.MethodGet)
+	mux.Handle("/users/{id}/spending-limits", errors.ErrorHandler(middleware.IsAuth(h.SetLimits, h.usersRepository, policy.Roles(policy.StudentManage)...))).Methods(http.MethodPut)
+	mux.Handle("/users/{id}/allowance", errors.ErrorHandler(middleware.IsAuth(h.GetAllowance, h.usersRepository, policy.Roles(policy.StudentView)...))).Methods(http.MethodGet)
+}
+
+func (h *LimitHandler) GetLimits(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	limit, err := h.limitsService.GetLimits(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusOK, limit); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *LimitHandler) SetLimits(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	var input map[string]interface{}
+	if err := json.Parse(r, &input); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	limit, err := h.limitsService.SetLimits(r.Context(), id, input)
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusOK, limit); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *LimitHandler) GetAllowance(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	allowance, err := h.limitsService.GetAllowance(r.Context(), id, utils.GetParams(r))
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusOK, allowance); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/kermesse-backend/api/middleware"
+	"github.com/kermesse-backend/internal/notifications"
+	"github.com/kermesse-backend/internal/users"
+	"github.com/kermesse-backend/pkg/errors"
+	"github.com/kermesse-backend/pkg/json"
+	"github.com/kermesse-backend/pkg/utils"
+	"net/http"
+	"strconv"
+)
+
+type NotificationHandler struct {
+	notificationsService notifications.NotificationsService
+	usersRepository      users.UsersRepository
+}
+
+func NewNotificationsHandler(notificationsService notifications.NotificationsService, usersRepository users.UsersRepository) *NotificationHandler {
+	return &NotificationHandler{
+		notificationsService: notificationsService,
+		usersRepository:      usersRepository,
+	}
+}
+
+func (h *NotificationHandler) RegisterRoutes(mux *mux.Router) {
+	mux.Handle("/notifications", errors.ErrorHandler(middleware.IsAuth(h.GetNotifications, h.usersRepository))).Methods(http.MethodGet)
+	mux.Handle("/notifications/read-all", errors.ErrorHandler(middleware.IsAuth(h.MarkAllAsRead, h.usersRepository))).Methods(http.MethodPatch)
+	mux.Handle("/notifications/{id}/read", errors.ErrorHandler(middleware.IsAuth(h.MarkAsRead, h.usersRepository))).Methods(http.MethodPatch)
+}
+
+func (h *NotificationHandler) GetNotifications(w http.ResponseWriter, r *http.Request) error {
+	notifications, err := h.notificationsService.GetNotifications(r.Context(), utils.GetParams(r))
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusOK, notifications); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *NotificationHandler) MarkAsRead(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	if err := h.notificationsService.MarkAsRead(r.Context(), id); err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusAccepted, nil); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *NotificationHandler) MarkAllAsRead(w http.ResponseWriter, r *http.Request) error {
+	if err := h.notificationsService.MarkAllAsRead(r.Context()); err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusAccepted, nil); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
@@ -4,7 +4,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/kermesse-backend/api/middleware"
 	"github.com/kermesse-backend/internal/participations"
-	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/internal/policy"
 	"github.com/kermesse-backend/internal/users"
 	"github.com/kermesse-backend/pkg/errors"
 	"github.com/kermesse-backend/pkg/json"
@@ -27,9 +27,9 @@ func NewParticipationsHandler(participationService participations.Participations
 
 func (handler *ParticipationsHandler) RegisterRoutes(router *mux.Router) {
 	router.Handle("/participations", errors.ErrorHandler(middleware.IsAuth(handler.GetAllParticipations, handler.userRepository))).Methods(http.MethodGet)
-	router.Handle("/participations", errors.ErrorHandler(middleware.IsAuth(handler.AddParticipation, handler.userRepository, types.UserRoleParent, types.UserRoleStudent))).Methods(http.MethodPost)
+	router.Handle("/participations", errors.ErrorHandler(middleware.IsAuth(handler.AddParticipation, handler.userRepository, policy.Roles(policy.StudentAct)...))).Methods(http.MethodPost)
 	router.Handle("/participations/{id}", errors.ErrorHandler(middleware.IsAuth(handler.GetParticipationById, handler.userRepository))).Methods(http.MethodGet)
-	router.Handle("/participations/{id}", errors.ErrorHandler(middleware.IsAuth(handler.ModifyParticipation, handler.userRepository, types.UserRoleStandHolder))).Methods(http.MethodPatch)
+	router.Handle("/participations/{id}", errors.ErrorHandler(middleware.IsAuth(handler.ModifyParticipation, handler.userRepository, policy.Roles(policy.StandManage)...))).Methods(http.MethodPatch)
 }
 
 func (handler *ParticipationsHandler) GetAllParticipations(w http.ResponseWriter, r *http.Request) error {
@@ -59,6 +59,7 @@ func (handler *ParticipationsHandler) GetParticipationById(w http.ResponseWriter
 	if err != nil {
 		return err
 	}
+	utils.SetETag(w, participation.Version)
 	if err := json.Write(w, http.StatusOK, participation); err != nil {
 		return errors.CustomError{
 			Key: errors.InternalServerError,
@@ -77,9 +78,19 @@ func (handler *ParticipationsHandler) AddParticipation(w http.ResponseWriter, r
 			Err: err,
 		}
 	}
-	if err := handler.participationService.AddParticipation(r.Context(), input); err != nil {
+	request, err := handler.participationService.AddParticipation(r.Context(), input)
+	if err != nil {
 		return err
 	}
+	if request != nil {
+		if err := json.Write(w, http.StatusAccepted, request); err != nil {
+			return errors.CustomError{
+				Key: errors.InternalServerError,
+				Err: err,
+			}
+		}
+		return nil
+	}
 	if err := json.Write(w, http.StatusCreated, nil); err != nil {
 		return errors.CustomError{
 			Key: errors.InternalServerError,
@@ -105,7 +116,14 @@ func (handler *ParticipationsHandler) ModifyParticipation(w http.ResponseWriter,
 			Err: err,
 		}
 	}
-	if err := handler.participationService.ModifyParticipation(r.Context(), id, input); err != nil {
+	version, err := utils.GetIfMatch(r)
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.BadRequest,
+			Err: err,
+		}
+	}
+	if err := handler.participationService.ModifyParticipation(r.Context(), id, version, input); err != nil {
 		return err
 	}
 	if err := json.Write(w, http.StatusAccepted, nil); err != nil {
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/kermesse-backend/api/middleware"
+	"github.com/kermesse-backend/internal/approvals"
+	"github.com/kermesse-backend/internal/policy"
+	"github.com/kermesse-backend/internal/users"
+	"github.com/kermesse-backend/pkg/errors"
+	"github.com/kermesse-backend/pkg/json"
+	"github.com/kermesse-backend/pkg/utils"
+	"net/http"
+	"strconv"
+)
+
+type PurchaseRequestHandler struct {
+	approvalsService approvals.ApprovalsService
+	usersRepository  users.UsersRepository
+}
+
+func NewPurchaseRequestsHandler(approvalsService approvals.ApprovalsService, usersRepository users.UsersRepository) *PurchaseRequestHandler {
+	return &PurchaseRequestHandler{
+		approvalsService: approvalsService,
+		usersRepository:  usersRepository,
+	}
+}
+
+func (h *PurchaseRequestHandler) RegisterRoutes(mux *mux.Router) {
+	mux.Handle("/purchase-requests", errors.ErrorHandler(middleware.IsAuth(h.GetPurchaseRequests, h.usersRepository, policy.Roles(policy.StudentView)...))).Methods(http.MethodGet)
+	mux.Handle("/purchase-requests/{id}", errors.ErrorHandler(middleware.IsAuth(h.GetPurchaseRequestById, h.usersRepository, policy.Roles(policy.StudentView)...))).Methods(http.MethodGet)
+	mux.Handle("/purchase-requests/{id}/approve", errors.ErrorHandler(middleware.IsAuth(h.ApprovePurchaseRequest, h.usersRepository, policy.Roles(policy.StudentManage)...))).Methods(http.MethodPost)
+	mux.Handle("/purchase-requests/{id}/deny", errors.ErrorHandler(middleware.IsAuth(h.DenyPurchaseRequest, h.usersRepository, policy.Roles(policy.StudentManage)...))).Methods(http.MethodPost)
+}
+
+func (h *PurchaseRequestHandler) GetPurchaseRequests(w http.ResponseWriter, r *http.Request) error {
+	requests, err := h.approvalsService.GetPurchaseRequests(r.Context(), utils.GetParams(r))
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusOK, requests); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *PurchaseRequestHandler) GetPurchaseRequestById(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	request, err := h.approvalsService.GetPurchaseRequestById(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusOK, request); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *PurchaseRequestHandler) ApprovePurchaseRequest(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	request, err := h.approvalsService.ApprovePurchaseRequest(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusOK, request); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *PurchaseRequestHandler) DenyPurchaseRequest(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	request, err := h.approvalsService.DenyPurchaseRequest(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusOK, request); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
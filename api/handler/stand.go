@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"github.com/kermesse-backend/internal/policy"
 	"github.com/kermesse-backend/pkg/utils"
 	"net/http"
 	"strconv"
@@ -8,7 +9,6 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/kermesse-backend/api/middleware"
 	"github.com/kermesse-backend/internal/stands"
-	"github.com/kermesse-backend/internal/types"
 	"github.com/kermesse-backend/internal/users"
 	"github.com/kermesse-backend/pkg/errors"
 	"github.com/kermesse-backend/pkg/json"
@@ -27,11 +27,12 @@ func NewStandsHandler(standService stands.StandsService, usersRepository users.U
 }
 
 func (handler *StandsHandler) RegisterRoutes(router *mux.Router) {
-	router.Handle("/stands", errors.ErrorHandler(middleware.IsAuth(handler.AddStand, handler.usersRepository, types.UserRoleStandHolder))).Methods(http.MethodPost)
+	router.Handle("/stands", errors.ErrorHandler(middleware.IsAuth(handler.AddStand, handler.usersRepository, policy.Roles(policy.StandCreate)...))).Methods(http.MethodPost)
 	router.Handle("/stands", errors.ErrorHandler(middleware.IsAuth(handler.GetAllStands, handler.usersRepository))).Methods(http.MethodGet)
-	router.Handle("/stands/owner", errors.ErrorHandler(middleware.IsAuth(handler.GetOwnStand, handler.usersRepository, types.UserRoleStandHolder))).Methods(http.MethodGet)
+	router.Handle("/stands/owner", errors.ErrorHandler(middleware.IsAuth(handler.GetOwnStand, handler.usersRepository, policy.Roles(policy.StandManage)...))).Methods(http.MethodGet)
+	router.Handle("/stands/owner", errors.ErrorHandler(middleware.IsAuth(handler.DeleteOwnStand, handler.usersRepository, policy.Roles(policy.StandManage)...))).Methods(http.MethodDelete)
 	router.Handle("/stands/{id}", errors.ErrorHandler(middleware.IsAuth(handler.GetStandById, handler.usersRepository))).Methods(http.MethodGet)
-	router.Handle("/stands/modify", errors.ErrorHandler(middleware.IsAuth(handler.ModifyStand, handler.usersRepository, types.UserRoleStandHolder))).Methods(http.MethodPatch)
+	router.Handle("/stands/modify", errors.ErrorHandler(middleware.IsAuth(handler.ModifyStand, handler.usersRepository, policy.Roles(policy.StandManage)...))).Methods(http.MethodPatch)
 }
 
 func (handler *StandsHandler) AddStand(w http.ResponseWriter, r *http.Request) error {
@@ -81,6 +82,7 @@ func (handler *StandsHandler) GetStandById(w http.ResponseWriter, r *http.Reques
 	if err != nil {
 		return err
 	}
+	utils.SetETag(w, stand.Version)
 	if err := json.Write(w, http.StatusOK, stand); err != nil {
 		return errors.CustomError{
 			Key: errors.InternalServerError,
@@ -99,7 +101,14 @@ func (handler *StandsHandler) ModifyStand(w http.ResponseWriter, r *http.Request
 		}
 	}
 
-	if err := handler.standService.ModifyStand(r.Context(), input); err != nil {
+	version, err := utils.GetIfMatch(r)
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.BadRequest,
+			Err: err,
+		}
+	}
+	if err := handler.standService.ModifyStand(r.Context(), version, input); err != nil {
 		return err
 	}
 	if err := json.Write(w, http.StatusAccepted, nil); err != nil {
@@ -116,6 +125,7 @@ func (handler *StandsHandler) GetOwnStand(w http.ResponseWriter, r *http.Request
 	if err != nil {
 		return err
 	}
+	utils.SetETag(w, stand.Version)
 	if err := json.Write(w, http.StatusOK, stand); err != nil {
 		return errors.CustomError{
 			Key: errors.InternalServerError,
@@ -124,3 +134,16 @@ func (handler *StandsHandler) GetOwnStand(w http.ResponseWriter, r *http.Request
 	}
 	return nil
 }
+
+func (handler *StandsHandler) DeleteOwnStand(w http.ResponseWriter, r *http.Request) error {
+	if err := handler.standService.DeleteOwnStand(r.Context()); err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusAccepted, nil); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
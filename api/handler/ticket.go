@@ -3,8 +3,8 @@ package handler
 import (
 	"github.com/gorilla/mux"
 	"github.com/kermesse-backend/api/middleware"
+	"github.com/kermesse-backend/internal/policy"
 	"github.com/kermesse-backend/internal/tickets"
-	"github.com/kermesse-backend/internal/types"
 	"github.com/kermesse-backend/internal/users"
 	"github.com/kermesse-backend/pkg/errors"
 	"github.com/kermesse-backend/pkg/json"
@@ -26,8 +26,11 @@ func NewTicketsHandler(ticketsService tickets.TicketService, usersRepository use
 
 func (h *TicketHandler) RegisterRoutes(mux *mux.Router) {
 	mux.Handle("/tickets", errors.ErrorHandler(middleware.IsAuth(h.GetAllTickets, h.usersRepository))).Methods(http.MethodGet)
-	mux.Handle("/tickets", errors.ErrorHandler(middleware.IsAuth(h.CreateTicket, h.usersRepository, types.UserRoleStudent))).Methods(http.MethodPost)
+	mux.Handle("/tickets", errors.ErrorHandler(middleware.IsAuth(h.CreateTicket, h.usersRepository, policy.Roles(policy.StudentAct)...))).Methods(http.MethodPost)
 	mux.Handle("/tickets/{id}", errors.ErrorHandler(middleware.IsAuth(h.GetTicketById, h.usersRepository))).Methods(http.MethodGet)
+	mux.Handle("/tickets/{id}/claim", errors.ErrorHandler(middleware.IsAuth(h.ClaimPrize, h.usersRepository, policy.Roles(policy.StudentAct)...))).Methods(http.MethodPatch)
+	mux.Handle("/tickets/deliver", errors.ErrorHandler(middleware.IsAuth(h.DeliverPrize, h.usersRepository, policy.Roles(policy.PrizeDeliver)...))).Methods(http.MethodPatch)
+	mux.Handle("/kermesses/{id}/unclaimed-prizes", errors.ErrorHandler(middleware.IsAuth(h.GetUnclaimedPrizes, h.usersRepository, policy.Roles(policy.PrizeDeliver)...))).Methods(http.MethodGet)
 }
 
 func (h *TicketHandler) GetAllTickets(w http.ResponseWriter, r *http.Request) error {
@@ -53,7 +56,7 @@ func (h *TicketHandler) GetTicketById(w http.ResponseWriter, r *http.Request) er
 			Err: err,
 		}
 	}
-	ticket, err := h.ticketsService.GetTicketById(id)
+	ticket, err := h.ticketsService.GetTicketById(r.Context(), id)
 	if err != nil {
 		return err
 	}
@@ -74,10 +77,85 @@ func (h *TicketHandler) CreateTicket(w http.ResponseWriter, r *http.Request) err
 			Err: err,
 		}
 	}
-	if err := h.ticketsService.CreateTicket(r.Context(), input); err != nil {
+	purchase, request, err := h.ticketsService.CreateTicket(r.Context(), input)
+	if err != nil {
+		return err
+	}
+	if request != nil {
+		if err := json.Write(w, http.StatusAccepted, request); err != nil {
+			return errors.CustomError{
+				Key: errors.InternalServerError,
+				Err: err,
+			}
+		}
+		return nil
+	}
+	if err := json.Write(w, http.StatusCreated, purchase); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *TicketHandler) ClaimPrize(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	ticket, err := h.ticketsService.ClaimPrize(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusAccepted, ticket); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *TicketHandler) DeliverPrize(w http.ResponseWriter, r *http.Request) error {
+	var input map[string]interface{}
+	if err := json.Parse(r, &input); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	ticket, err := h.ticketsService.DeliverPrize(r.Context(), input)
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusAccepted, ticket); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *TicketHandler) GetUnclaimedPrizes(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	tickets, err := h.ticketsService.GetUnclaimedPrizes(r.Context(), id)
+	if err != nil {
 		return err
 	}
-	if err := json.Write(w, http.StatusCreated, nil); err != nil {
+	if err := json.Write(w, http.StatusOK, tickets); err != nil {
 		return errors.CustomError{
 			Key: errors.InternalServerError,
 			Err: err,
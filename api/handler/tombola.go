@@ -3,8 +3,8 @@ package handler
 import (
 	"github.com/gorilla/mux"
 	"github.com/kermesse-backend/api/middleware"
+	"github.com/kermesse-backend/internal/policy"
 	"github.com/kermesse-backend/internal/tombolas"
-	"github.com/kermesse-backend/internal/types"
 	"github.com/kermesse-backend/internal/users"
 	"github.com/kermesse-backend/pkg/errors"
 	"github.com/kermesse-backend/pkg/json"
@@ -27,10 +27,13 @@ func NewTombolasHandler(tombolasService tombolas.TombolaService, usersRepository
 
 func (handler *TombolasHandler) RegisterRoutes(router *mux.Router) {
 	router.Handle("/tombolas", errors.ErrorHandler(middleware.IsAuth(handler.GetAllTombolas, handler.usersRepository))).Methods(http.MethodGet)
-	router.Handle("/tombolas", errors.ErrorHandler(middleware.IsAuth(handler.AddTombola, handler.usersRepository, types.UserRoleOrganizer))).Methods(http.MethodPost)
+	router.Handle("/tombolas", errors.ErrorHandler(middleware.IsAuth(handler.AddTombola, handler.usersRepository, policy.Roles(policy.TombolaManage)...))).Methods(http.MethodPost)
 	router.Handle("/tombolas/{id}", errors.ErrorHandler(middleware.IsAuth(handler.GetTombolaById, handler.usersRepository))).Methods(http.MethodGet)
-	router.Handle("/tombolas/{id}", errors.ErrorHandler(middleware.IsAuth(handler.ModifyTombola, handler.usersRepository, types.UserRoleOrganizer))).Methods(http.MethodPatch)
-	router.Handle("/tombolas/{id}/finish-winner", errors.ErrorHandler(middleware.IsAuth(handler.FinishTombola, handler.usersRepository, types.UserRoleOrganizer))).Methods(http.MethodPatch)
+	router.Handle("/tombolas/{id}", errors.ErrorHandler(middleware.IsAuth(handler.ModifyTombola, handler.usersRepository, policy.Roles(policy.TombolaManage)...))).Methods(http.MethodPatch)
+	router.Handle("/tombolas/{id}", errors.ErrorHandler(middleware.IsAuth(handler.DeleteTombola, handler.usersRepository, policy.Roles(policy.TombolaManage)...))).Methods(http.MethodDelete)
+	router.Handle("/tombolas/{id}/prizes", errors.ErrorHandler(middleware.IsAuth(handler.GetPrizes, handler.usersRepository))).Methods(http.MethodGet)
+	router.Handle("/tombolas/{id}/prizes", errors.ErrorHandler(middleware.IsAuth(handler.ReplacePrizes, handler.usersRepository, policy.Roles(policy.TombolaManage)...))).Methods(http.MethodPut)
+	router.Handle("/tombolas/{id}/finish-winner", errors.ErrorHandler(middleware.IsAuth(handler.FinishTombola, handler.usersRepository, policy.Roles(policy.TombolaManage)...))).Methods(http.MethodPatch)
 }
 
 func (handler *TombolasHandler) GetAllTombolas(w http.ResponseWriter, r *http.Request) error {
@@ -60,6 +63,7 @@ func (handler *TombolasHandler) GetTombolaById(w http.ResponseWriter, r *http.Re
 	if err != nil {
 		return err
 	}
+	utils.SetETag(w, tombola.Version)
 	if err := json.Write(w, http.StatusOK, tombola); err != nil {
 		return errors.CustomError{
 			Key: errors.InternalServerError,
@@ -105,7 +109,14 @@ func (handler *TombolasHandler) ModifyTombola(w http.ResponseWriter, r *http.Req
 			Err: err,
 		}
 	}
-	if err := handler.tombolasService.ModifyTombola(r.Context(), id, input); err != nil {
+	version, err := utils.GetIfMatch(r)
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.BadRequest,
+			Err: err,
+		}
+	}
+	if err := handler.tombolasService.ModifyTombola(r.Context(), id, version, input); err != nil {
 		return err
 	}
 	if err := json.Write(w, http.StatusAccepted, nil); err != nil {
@@ -137,3 +148,74 @@ func (handler *TombolasHandler) FinishTombola(w http.ResponseWriter, r *http.Req
 	}
 	return nil
 }
+
+func (handler *TombolasHandler) DeleteTombola(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	if err := handler.tombolasService.DeleteTombola(r.Context(), id); err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusAccepted, nil); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (handler *TombolasHandler) GetPrizes(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	prizes, err := handler.tombolasService.GetPrizes(id)
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusOK, prizes); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (handler *TombolasHandler) ReplacePrizes(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	var input map[string]interface{}
+	if err := json.Parse(r, &input); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	if err := handler.tombolasService.ReplacePrizes(r.Context(), id, input); err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusAccepted, nil); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"github.com/kermesse-backend/internal/policy"
+	"github.com/kermesse-backend/internal/stands"
+	"github.com/kermesse-backend/internal/tombolas"
+	"github.com/kermesse-backend/internal/types"
+	"strconv"
+	"strings"
+)
+
+// topicAuthorizer decides which notification topics a user may follow, it is
+// shared by the WebSocket and the Server-Sent Events handlers.
+type topicAuthorizer struct {
+	policyService      policy.PolicyService
+	standsRepository   stands.StandsRepository
+	tombolasRepository tombolas.TombolaRepository
+}
+
+// canSubscribe checks that the topic exists and concerns the user: their own
+// user topic, a kermesse they take part in, one of its tombolas, or their stand.
+func (authorizer topicAuthorizer) canSubscribe(userId int, topic string) bool {
+	topicParts := strings.Split(topic, ":")
+	if len(topicParts) != 2 {
+		return false
+	}
+	id, err := strconv.Atoi(topicParts[1])
+	if err != nil {
+		return false
+	}
+
+	var action policy.Action
+	var resource policy.Resource
+	switch topicParts[0] {
+	case "user":
+		action = policy.UserFollow
+		resource = policy.Resource{OwnerId: id}
+	case "kermesse":
+		action = policy.KermesseFollow
+		resource = policy.Resource{KermesseId: id}
+	case "tombola":
+		tombola, err := authorizer.tombolasRepository.GetTombolaById(id)
+		if err != nil {
+			return false
+		}
+		action = policy.KermesseFollow
+		resource = policy.Resource{KermesseId: tombola.KermesseId}
+	case "stand":
+		stand, err := authorizer.standsRepository.GetStandById(id)
+		if err != nil {
+			return false
+		}
+		action = policy.StandFollow
+		resource = policy.Resource{OwnerId: stand.UserId}
+	default:
+		return false
+	}
+
+	// following a topic does not depend on the role of the user
+	allowed, err := authorizer.policyService.Can(types.User{Id: userId}, action, resource)
+	return err == nil && allowed
+}
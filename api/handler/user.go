@@ -3,7 +3,7 @@ package handler
 import (
 	"github.com/gorilla/mux"
 	"github.com/kermesse-backend/api/middleware"
-	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/internal/policy"
 	"github.com/kermesse-backend/internal/users"
 	"github.com/kermesse-backend/pkg/errors"
 	"github.com/kermesse-backend/pkg/json"
@@ -26,11 +26,15 @@ func NewUserHandler(userService users.UsersService, userRepository users.UsersRe
 
 func (handler *UsersHandler) RegisterRoutes(mux *mux.Router) {
 	mux.Handle("/users", errors.ErrorHandler(middleware.IsAuth(handler.GetAllUsers, handler.userRepository))).Methods(http.MethodGet)
-	mux.Handle("/users/students", errors.ErrorHandler(middleware.IsAuth(handler.GetAllStudentByParentId, handler.userRepository, types.UserRoleParent))).Methods(http.MethodGet)
+	mux.Handle("/users/students", errors.ErrorHandler(middleware.IsAuth(handler.GetAllStudentByParentId, handler.userRepository, policy.Roles(policy.FamilyView)...))).Methods(http.MethodGet)
+	mux.Handle("/users/family", errors.ErrorHandler(middleware.IsAuth(handler.GetFamily, handler.userRepository, policy.Roles(policy.FamilyView)...))).Methods(http.MethodGet)
+	mux.Handle("/users/transfers", errors.ErrorHandler(middleware.IsAuth(handler.GetTransfers, handler.userRepository, policy.Roles(policy.TransferView)...))).Methods(http.MethodGet)
 	mux.Handle("/users/{id}", errors.ErrorHandler(middleware.IsAuth(handler.GetUserById, handler.userRepository))).Methods(http.MethodGet)
 	mux.Handle("/users/invite-child", errors.ErrorHandler(middleware.IsAuth(handler.InviteStudent, handler.userRepository))).Methods(http.MethodPost)
 	mux.Handle("/users/password/{id}", errors.ErrorHandler(middleware.IsAuth(handler.UpdatePassword, handler.userRepository))).Methods(http.MethodPatch)
-	mux.Handle("/users/send-jeton", errors.ErrorHandler(middleware.IsAuth(handler.MakePayment, handler.userRepository, types.UserRoleParent))).Methods(http.MethodPatch)
+	mux.Handle("/users/send-jeton", errors.ErrorHandler(middleware.IsAuth(handler.MakePayment, handler.userRepository, policy.Roles(policy.StudentManage)...))).Methods(http.MethodPatch)
+	mux.Handle("/users/reclaim-jeton", errors.ErrorHandler(middleware.IsAuth(handler.ReclaimBalance, handler.userRepository, policy.Roles(policy.StudentManage)...))).Methods(http.MethodPatch)
+	mux.Handle("/users/transfer-jeton", errors.ErrorHandler(middleware.IsAuth(handler.TransferBetweenStudents, handler.userRepository, policy.Roles(policy.StudentManage)...))).Methods(http.MethodPatch)
 	mux.Handle("/register", errors.ErrorHandler(handler.Register)).Methods(http.MethodPost)
 	mux.Handle("/login", errors.ErrorHandler(handler.Login)).Methods(http.MethodPost)
 	mux.Handle("/me", errors.ErrorHandler(middleware.IsAuth(handler.GetLoggedInUser, handler.userRepository))).Methods(http.MethodGet)
@@ -183,6 +187,76 @@ func (handler *UsersHandler) MakePayment(w http.ResponseWriter, r *http.Request)
 	return nil
 }
 
+func (handler *UsersHandler) ReclaimBalance(w http.ResponseWriter, r *http.Request) error {
+	var input map[string]interface{}
+	if err := json.Parse(r, &input); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	transfer, err := handler.userService.ReclaimBalance(r.Context(), input)
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusAccepted, transfer); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (handler *UsersHandler) TransferBetweenStudents(w http.ResponseWriter, r *http.Request) error {
+	var input map[string]interface{}
+	if err := json.Parse(r, &input); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	transfer, err := handler.userService.TransferBetweenStudents(r.Context(), input)
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusAccepted, transfer); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (handler *UsersHandler) GetFamily(w http.ResponseWriter, r *http.Request) error {
+	family, err := handler.userService.GetFamily(r.Context())
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusOK, family); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (handler *UsersHandler) GetTransfers(w http.ResponseWriter, r *http.Request) error {
+	transfers, err := handler.userService.GetTransfers(r.Context(), utils.GetParams(r))
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusOK, transfers); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
 func (handler *UsersHandler) GetAllStudentByParentId(w http.ResponseWriter, r *http.Request) error {
 	users, err := handler.userService.GetAllStudentByParentId(r.Context(), utils.GetParams(r))
 	if err != nil {
@@ -1,17 +1,47 @@
 package handler
 
 import (
+	encodingJson "encoding/json"
 	"fmt"
 	"github.com/gorilla/websocket"
+	"github.com/kermesse-backend/api/middleware"
 	"github.com/kermesse-backend/internal/notifications"
+	"github.com/kermesse-backend/internal/policy"
+	"github.com/kermesse-backend/internal/stands"
+	"github.com/kermesse-backend/internal/tombolas"
+	"github.com/kermesse-backend/internal/users"
+	"github.com/kermesse-backend/pkg/errors"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// bearerSubprotocol lets browser clients, which cannot set headers on the
+// handshake, send their token as "Sec-WebSocket-Protocol: bearer, <token>".
+const bearerSubprotocol = "bearer"
+
 type WebSocketHandler struct {
-	upgrader websocket.Upgrader
+	topicAuthorizer
+	upgrader        websocket.Upgrader
+	hub             *notifications.Hub
+	usersRepository users.UsersRepository
+}
+
+// subscriptionRequest is the message a client sends to follow or stop
+// following a topic, e.g. {"action": "subscribe", "topic": "kermesse:3"}.
+type subscriptionRequest struct {
+	Action string `json:"action"`
+	Topic  string `json:"topic"`
+}
+
+type subscriptionResponse struct {
+	Action string `json:"action"`
+	Topic  string `json:"topic"`
+	Error  string `json:"error,omitempty"`
 }
 
-func NewWebSocketHandler() *WebSocketHandler {
+func NewWebSocketHandler(hub *notifications.Hub, usersRepository users.UsersRepository, policyService policy.PolicyService, standsRepository stands.StandsRepository, tombolasRepository tombolas.TombolaRepository) *WebSocketHandler {
 	return &WebSocketHandler{
 		upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
@@ -20,27 +50,60 @@ func NewWebSocketHandler() *WebSocketHandler {
 				return true
 			},
 		},
+		hub:             hub,
+		usersRepository: usersRepository,
+		topicAuthorizer: topicAuthorizer{
+			policyService:      policyService,
+			standsRepository:   standsRepository,
+			tombolasRepository: tombolasRepository,
+		},
 	}
 }
 
 func (h *WebSocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
-	organizerId := r.URL.Query().Get("organizerId")
-	if organizerId == "" {
-		http.Error(w, "Organizer ID is required", http.StatusBadRequest)
+	token, subprotocol := handshakeToken(r)
+	if token == "" {
+		http.Error(w, "token is required", http.StatusUnauthorized)
 		return
 	}
 
-	conn, err := h.upgrader.Upgrade(w, r, nil)
+	user, expiresAt, err := middleware.Authenticate(token, h.usersRepository)
+	if err != nil {
+		if e, ok := err.(errors.CustomError); ok {
+			http.Error(w, e.Err.Error(), http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, errors.InternalServerError, http.StatusInternalServerError)
+		return
+	}
+
+	var responseHeader http.Header
+	if subprotocol != "" {
+		responseHeader = http.Header{"Sec-WebSocket-Protocol": {subprotocol}}
+	}
+	conn, err := h.upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		fmt.Println("Upgrade error:", err)
 		return
 	}
 	defer conn.Close()
 
-	notifications.RegisterOrganizer(organizerId, conn)
-	defer notifications.UnregisterOrganizer(organizerId)
+	client := h.hub.Register(user.Id, conn)
+	defer h.hub.Unregister(client)
+
+	// a reconnecting client sends the id of the last event it received to get
+	// the ones it missed while offline
+	if lastEventId, err := strconv.Atoi(r.URL.Query().Get("last_event_id")); err == nil {
+		h.hub.Replay(client, lastEventId)
+	}
+
+	// the connection cannot outlive the token it was opened with
+	expiration := time.AfterFunc(time.Until(expiresAt), func() {
+		client.Close(websocket.ClosePolicyViolation, "token expired")
+	})
+	defer expiration.Stop()
 
-	fmt.Printf("Organizer %s connected\n", organizerId)
+	fmt.Printf("User %d connected\n", user.Id)
 
 	for {
 		_, message, err := conn.ReadMessage()
@@ -48,6 +111,57 @@ func (h *WebSocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Reques
 			fmt.Println("Read error:", err)
 			break
 		}
-		fmt.Printf("Received from %s: %s\n", organizerId, message)
+		h.handleClientMessage(client, message)
+	}
+}
+
+func (h *WebSocketHandler) handleClientMessage(client *notifications.Client, message []byte) {
+	var request subscriptionRequest
+	response := subscriptionResponse{}
+	if err := encodingJson.Unmarshal(message, &request); err != nil {
+		response.Error = "invalid message"
+		h.reply(client, response)
+		return
+	}
+	response.Action = request.Action
+	response.Topic = request.Topic
+
+	switch request.Action {
+	case "subscribe":
+		if !h.canSubscribe(client.UserId, request.Topic) {
+			response.Error = "forbidden topic"
+			break
+		}
+		h.hub.Subscribe(client, request.Topic)
+	case "unsubscribe":
+		h.hub.Unsubscribe(client, request.Topic)
+	default:
+		response.Error = "unknown action"
+	}
+	h.reply(client, response)
+}
+
+func (h *WebSocketHandler) reply(client *notifications.Client, response subscriptionResponse) {
+	message, err := encodingJson.Marshal(response)
+	if err != nil {
+		return
+	}
+	h.hub.Send(client, message)
+}
+
+// handshakeToken reads the JWT from the Authorization header, or from the
+// bearer subprotocol. It also returns the subprotocol to accept, if any.
+func handshakeToken(r *http.Request) (string, string) {
+	header := r.Header.Get("Authorization")
+	if tokenParts := strings.Split(header, " "); len(tokenParts) == 2 && tokenParts[0] == "Bearer" {
+		return tokenParts[1], ""
+	}
+
+	protocols := websocket.Subprotocols(r)
+	for i, protocol := range protocols {
+		if protocol == bearerSubprotocol && i+1 < len(protocols) {
+			return protocols[i+1], bearerSubprotocol
+		}
 	}
+	return "", ""
 }
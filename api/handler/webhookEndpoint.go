@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/kermesse-backend/api/middleware"
+	"github.com/kermesse-backend/internal/policy"
+	"github.com/kermesse-backend/internal/users"
+	"github.com/kermesse-backend/internal/webhooks"
+	"github.com/kermesse-backend/pkg/errors"
+	"github.com/kermesse-backend/pkg/json"
+	"net/http"
+	"strconv"
+)
+
+type WebhookEndpointHandler struct {
+	webhooksService webhooks.WebhooksService
+	usersRepository users.UsersRepository
+}
+
+func NewWebhookEndpointsHandler(webhooksService webhooks.WebhooksService, usersRepository users.UsersRepository) *WebhookEndpointHandler {
+	return &WebhookEndpointHandler{
+		webhooksService: webhooksService,
+		usersRepository: usersRepository,
+	}
+}
+
+func (h *WebhookEndpointHandler) RegisterRoutes(mux *mux.Router) {
+	mux.Handle("/kermesses/{id}/webhooks", errors.ErrorHandler(middleware.IsAuth(h.GetEndpoints, h.usersRepository, policy.Roles(policy.WebhookManage)...))).Methods(http.MethodGet)
+	mux.Handle("/kermesses/{id}/webhooks", errors.ErrorHandler(middleware.IsAuth(h.AddEndpoint, h.usersRepository, policy.Roles(policy.WebhookManage)...))).Methods(http.MethodPost)
+	mux.Handle("/webhooks/{id}", errors.ErrorHandler(middleware.IsAuth(h.DeleteEndpoint, h.usersRepository, policy.Roles(policy.WebhookManage)...))).Methods(http.MethodDelete)
+	mux.Handle("/webhooks/{id}/deliveries", errors.ErrorHandler(middleware.IsAuth(h.GetDeliveries, h.usersRepository, policy.Roles(policy.WebhookManage)...))).Methods(http.MethodGet)
+	mux.Handle("/webhooks/deliveries/{id}/redeliver", errors.ErrorHandler(middleware.IsAuth(h.Redeliver, h.usersRepository, policy.Roles(policy.WebhookManage)...))).Methods(http.MethodPost)
+}
+
+func (h *WebhookEndpointHandler) GetEndpoints(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	endpoints, err := h.webhooksService.GetEndpoints(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusOK, endpoints); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *WebhookEndpointHandler) AddEndpoint(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	var input map[string]interface{}
+	if err := json.Parse(r, &input); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	endpoint, err := h.webhooksService.AddEndpoint(r.Context(), id, input)
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusCreated, endpoint); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *WebhookEndpointHandler) DeleteEndpoint(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	if err := h.webhooksService.DeleteEndpoint(r.Context(), id); err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusAccepted, nil); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *WebhookEndpointHandler) GetDeliveries(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	deliveries, err := h.webhooksService.GetDeliveries(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusOK, deliveries); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (h *WebhookEndpointHandler) Redeliver(w http.ResponseWriter, r *http.Request) error {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	if err := h.webhooksService.Redeliver(r.Context(), id); err != nil {
+		return err
+	}
+	if err := json.Write(w, http.StatusAccepted, nil); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	goErrors "errors"
+	"github.com/gorilla/mux"
+	"github.com/kermesse-backend/internal/idempotency"
+	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/internal/users"
+	"github.com/kermesse-backend/pkg/errors"
+	"github.com/kermesse-backend/pkg/jwt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is set on the responses replayed from a
+	// previous request.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+	// maxCompleteAttempts is how many times storing a response is tried.
+	maxCompleteAttempts = 3
+)
+
+// replayedHeaders are the response headers stored along with the body, the
+// others are set again by the middlewares when the response is replayed.
+var replayedHeaders = []string{"Content-Type", "ETag", "Location", "X-Error-Code"}
+
+// Idempotency handles a POST or PATCH request sent with an Idempotency-Key
+// header once, its retries get the stored response instead of being handled
+// again, so that a retried purchase is not charged twice. The keys are scoped
+// to the caller, reusing one for another request is refused. Requests without
+// the header or without a valid bearer token are handled as usual, the
+// response refusing them is not stored.
+func Idempotency(idempotencyRepository idempotency.IdempotencyRepository, usersRepository users.UsersRepository) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return errors.ErrorHandler(func(w http.ResponseWriter, r *http.Request) error {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" || (r.Method != http.MethodPost && r.Method != http.MethodPatch) {
+				next.ServeHTTP(w, r)
+				return nil
+			}
+			userId, ok := authenticatedCallerId(r, usersRepository)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return nil
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				return errors.CustomError{
+					Key: errors.BadRequest,
+					Err: goErrors.New("idempotency key is too long"),
+				}
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				return errors.CustomError{
+					Key: errors.BadRequest,
+					Err: err,
+				}
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			record := types.IdempotencyKey{
+				UserId:      userId,
+				Key:         key,
+				Method:      r.Method,
+				Path:        r.URL.Path,
+				RequestHash: hashRequest(r, body),
+			}
+			reserved, err := idempotencyRepository.Reserve(record)
+			if err != nil {
+				return errors.CustomError{
+					Key: errors.InternalServerError,
+					Err: err,
+				}
+			}
+			if !reserved {
+				return replay(w, idempotencyRepository, record)
+			}
+
+			recorder := &responseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+			next.ServeHTTP(recorder, r)
+
+			// the failures of the server are stored too, a purchase may have
+			// charged the caller before failing
+			headers := make(map[string]string)
+			for _, name := range replayedHeaders {
+				if value := w.Header().Get(name); value != "" {
+					headers[name] = value
+				}
+			}
+			record.Headers, _ = json.Marshal(headers)
+			record.StatusCode = &recorder.statusCode
+			record.Body = recorder.body.Bytes()
+			for attempt := 1; attempt <= maxCompleteAttempts; attempt++ {
+				if err = idempotencyRepository.Complete(record); err == nil {
+					break
+				}
+			}
+			if err != nil {
+				// the key stays reserved, its retries are refused until it expires
+				log.Printf("Error storing idempotent response: %v", err)
+			}
+			return nil
+		})
+	}
+}
+
+// replay writes the response stored for the key, once the request that
+// reserved it is done.
+func replay(w http.ResponseWriter, idempotencyRepository idempotency.IdempotencyRepository, record types.IdempotencyKey) error {
+	stored, err := idempotencyRepository.GetKey(record.UserId, record.Key)
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	if stored.RequestHash != record.RequestHash {
+		return errors.CustomError{
+			Key: errors.IdempotencyKeyReused,
+			Err: goErrors.New("idempotency key was already used for another request"),
+		}
+	}
+	if stored.StatusCode == nil {
+		return errors.CustomError{
+			Key: errors.Conflict,
+			Err: goErrors.New("a request with this idempotency key is in progress"),
+		}
+	}
+
+	var headers map[string]string
+	if err := stored.Headers.Unmarshal(&headers); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	for name, value := range headers {
+		w.Header().Set(name, value)
+	}
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(*stored.StatusCode)
+	w.Write(stored.Body)
+	return nil
+}
+
+// callerId returns the user of the bearer token. The token is only checked
+// to tell the callers apart, IsAuth still authenticates the request.
+func callerId(r *http.Request) (int, bool) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		return 0, false
+	}
+	userId, err := jwt.GetTokenUserId(token, os.Getenv("JWT_SECRET"))
+	return userId, err == nil
+}
+
+// authenticatedCallerId returns the user of the bearer token, authenticated
+// as IsAuth does. An expired token, or the token of a deleted user, must not
+// reserve a key: the 401 would be replayed to the retries sent once logged in
+// again.
+func authenticatedCallerId(r *http.Request, usersRepository users.UsersRepository) (int, bool) {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		return 0, false
+	}
+	user, _, err := Authenticate(token, usersRepository)
+	return user.Id, err == nil
+}
+
+// hashRequest identifies the request, for a key reused with another one to be
+// told apart from a retry.
+func hashRequest(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// responseRecorder writes the response through while keeping a copy of it.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode  int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (recorder *responseRecorder) WriteHeader(statusCode int) {
+	if !recorder.wroteHeader {
+		recorder.statusCode = statusCode
+		recorder.wroteHeader = true
+	}
+	recorder.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (recorder *responseRecorder) Write(data []byte) (int, error) {
+	recorder.wroteHeader = true
+	recorder.body.Write(data)
+	return recorder.ResponseWriter.Write(data)
+}
@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 )
 
 func IsAuth(handlerFunc errors.ErrorHandler, usersRepository users.UsersRepository, roles ...string) errors.ErrorHandler {
@@ -30,15 +31,7 @@ func IsAuth(handlerFunc errors.ErrorHandler, usersRepository users.UsersReposito
 			}
 		}
 
-		userId, err := jwt.GetTokenUserId(tokenParts[1], os.Getenv("JWT_SECRET"))
-		if err != nil {
-			return errors.CustomError{
-				Key: errors.Unauthorized,
-				Err: goErrors.New("invalid token"),
-			}
-		}
-
-		user, err := usersRepository.GetUserById(userId)
+		user, expiresAt, err := Authenticate(tokenParts[1], usersRepository)
 		if err != nil {
 			return err
 		}
@@ -62,8 +55,37 @@ func IsAuth(handlerFunc errors.ErrorHandler, usersRepository users.UsersReposito
 		ctx := r.Context()
 		ctx = context.WithValue(ctx, types.UserIDSessionKey, user.Id)
 		ctx = context.WithValue(ctx, types.UserRoleSessionKey, user.Role)
+		ctx = context.WithValue(ctx, types.TokenExpiresAtSessionKey, expiresAt)
 		r = r.WithContext(ctx)
 
 		return handlerFunc(w, r)
 	}
 }
+
+// Authenticate validates a raw JWT and returns its user along with the token
+// expiration date. It is shared by IsAuth and the connections that cannot go
+// through it, such as the WebSocket handshake.
+func Authenticate(token string, usersRepository users.UsersRepository) (types.User, time.Time, error) {
+	userId, err := jwt.GetTokenUserId(token, os.Getenv("JWT_SECRET"))
+	if err != nil {
+		return types.User{}, time.Time{}, errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("invalid token"),
+		}
+	}
+
+	expiresAt, err := jwt.GetTokenExpiration(token, os.Getenv("JWT_SECRET"))
+	if err != nil || !expiresAt.After(time.Now()) {
+		return types.User{}, time.Time{}, errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("token expired"),
+		}
+	}
+
+	user, err := usersRepository.GetUserById(userId)
+	if err != nil {
+		return types.User{}, time.Time{}, err
+	}
+
+	return user, expiresAt, nil
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	goErrors "errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/kermesse-backend/internal/ratelimit"
+	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/pkg/errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	RateLimitLimitHeader     = "X-RateLimit-Limit"
+	RateLimitRemainingHeader = "X-RateLimit-Remaining"
+	RateLimitResetHeader     = "X-RateLimit-Reset"
+)
+
+// RateLimiter gives each caller a token bucket per route: the user when the
+// request carries a valid token, the IP of the client otherwise.
+type RateLimiter struct {
+	store        ratelimit.Store
+	defaultLimit ratelimit.Limit
+	limits       map[string]ratelimit.Limit
+}
+
+// NewRateLimiter applies limits to the routes they name, as in "POST /login",
+// and defaultLimit to the others.
+func NewRateLimiter(store ratelimit.Store, defaultLimit ratelimit.Limit, limits map[string]ratelimit.Limit) *RateLimiter {
+	return &RateLimiter{
+		store:        store,
+		defaultLimit: defaultLimit,
+		limits:       limits,
+	}
+}
+
+// Limit refuses the request with TOO_MANY_REQUESTS once the caller has no
+// token left, and sets the limit headers on every response. A failing store
+// lets the requests through.
+func (limiter *RateLimiter) Limit(handlerFunc errors.ErrorHandler) errors.ErrorHandler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		route := routeName(r)
+		limit, exists := limiter.limits[route]
+		if !exists {
+			limit = limiter.defaultLimit
+		}
+
+		caller := fmt.Sprintf("ip:%v", r.Context().Value(types.ClientIPSessionKey))
+		if userId, ok := callerId(r); ok {
+			caller = fmt.Sprintf("user:%d", userId)
+		}
+
+		result, err := limiter.store.Take(route+"|"+caller, limit)
+		if err != nil {
+			log.Printf("Error taking a rate limit token: %v", err)
+			return handlerFunc(w, r)
+		}
+
+		w.Header().Set(RateLimitLimitHeader, strconv.Itoa(result.Limit))
+		w.Header().Set(RateLimitRemainingHeader, strconv.Itoa(result.Remaining))
+		w.Header().Set(RateLimitResetHeader, strconv.Itoa(seconds(result.Reset)))
+		if !result.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(seconds(result.RetryAfter)))
+			return errors.CustomError{
+				Key: errors.TooManyRequests,
+				Err: goErrors.New("too many requests, retry later"),
+			}
+		}
+		return handlerFunc(w, r)
+	}
+}
+
+// Middleware applies Limit to every route of the router.
+func (limiter *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return errors.ErrorHandler(limiter.Limit(func(w http.ResponseWriter, r *http.Request) error {
+		next.ServeHTTP(w, r)
+		return nil
+	}))
+}
+
+// routeName is the method and the path template of the matched route, so that
+// /tickets/1/claim and /tickets/2/claim share the same bucket.
+func routeName(r *http.Request) string {
+	path := r.URL.Path
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			path = template
+		}
+	}
+	return r.Method + " " + path
+}
+
+// seconds rounds up, a client waiting for the given time must find a token.
+func seconds(duration time.Duration) int {
+	return int(math.Ceil(duration.Seconds()))
+}
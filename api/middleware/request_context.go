@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/pkg/generator"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	RequestIDHeader = "X-Request-ID"
+	// maxRequestIDLength bounds the request IDs taken from the clients, longer
+	// ones are replaced by a generated one.
+	maxRequestIDLength = 64
+)
+
+// RequestContext stores the request ID and the IP of the client in the
+// context of the request, so that the services can record them. The request
+// ID is taken from the X-Request-ID header when the client or a proxy sent
+// one, and is sent back in the response. X-Forwarded-For is only read on the
+// requests coming from one of trustedProxies.
+func RequestContext(trustedProxies []*net.IPNet) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestId := r.Header.Get(RequestIDHeader)
+			if requestId == "" || len(requestId) > maxRequestIDLength {
+				requestId, _ = generator.RandomCode(16)
+			}
+			w.Header().Set(RequestIDHeader, requestId)
+
+			ctx := r.Context()
+			ctx = context.WithValue(ctx, types.RequestIDSessionKey, requestId)
+			ctx = context.WithValue(ctx, types.ClientIPSessionKey, clientIP(r, trustedProxies))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ParseTrustedProxies reads a comma separated list of addresses and CIDR
+// ranges, such as "10.0.0.1, 192.168.0.0/16".
+func ParseTrustedProxies(value string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// clientIP is the address of the peer. When the peer is a trusted proxy, the
+// client is the right-most address of X-Forwarded-For that is not a trusted
+// proxy, the entries on its left were written by the client and may be
+// forged.
+func clientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	if peer == nil {
+		return ""
+	}
+	if !isTrusted(peer, trustedProxies) {
+		return peer.String()
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if ip == nil {
+			// the chain cannot be followed past a malformed entry
+			break
+		}
+		if !isTrusted(ip, trustedProxies) {
+			return ip.String()
+		}
+		peer = ip
+	}
+	return peer.String()
+}
+
+func isTrusted(ip net.IP, trustedProxies []*net.IPNet) bool {
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -7,6 +7,8 @@ import (
 
 	"github.com/joho/godotenv"
 	"github.com/kermesse-backend/api"
+	"github.com/kermesse-backend/internal/notifications"
+	"github.com/kermesse-backend/internal/ratelimit"
 	"github.com/kermesse-backend/third_party/database"
 )
 
@@ -17,13 +19,14 @@ func main() {
 	}
 
 	// connect to the database
-	db, err := database.NewPostgres(database.PostgresConfig{
+	databaseConfig := database.PostgresConfig{
 		Host:     os.Getenv("DB_HOST"),
 		Port:     os.Getenv("DB_PORT"),
 		User:     os.Getenv("DB_USER"),
 		Password: os.Getenv("DB_PASSWORD"),
 		Name:     os.Getenv("DB_NAME"),
-	})
+	}
+	db, err := database.NewPostgres(databaseConfig)
 	if err != nil {
 		log.Fatalf("Error connecting to the database: %v", err)
 	} else {
@@ -31,9 +34,29 @@ func main() {
 	}
 	defer db.Close()
 
+	// share the notifications between instances, unless running a single one
+	var broker notifications.Broker
+	if os.Getenv("NOTIFICATION_BROKER") == "memory" {
+		broker = notifications.NewMemoryBroker()
+	} else {
+		broker, err = notifications.NewPostgresBroker(db, database.NewListener(databaseConfig))
+		if err != nil {
+			log.Fatalf("Error listening for notifications: %v", err)
+		}
+	}
+	defer broker.Close()
+
+	// share the rate limits between instances only when asked to
+	var rateLimitStore ratelimit.Store
+	if os.Getenv("RATE_LIMIT_STORE") == "postgres" {
+		rateLimitStore = ratelimit.NewPostgresStore(db)
+	} else {
+		rateLimitStore = ratelimit.NewMemoryStore()
+	}
+
 	// create & run the API server
 	address := fmt.Sprintf("%s:%s", os.Getenv("HOST"), os.Getenv("PORT"))
-	server := api.NewAPIServer(address, db)
+	server := api.NewAPIServer(address, db, broker, rateLimitStore)
 	if err := server.Start(); err != nil {
 		log.Fatalf("Error starting the server: %v", err)
 	}
@@ -0,0 +1,122 @@
+package admin
+
+import (
+	"database/sql"
+	goErrors "errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/kermesse-backend/internal/audit"
+	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/pkg/utils"
+)
+
+type AdminRepository interface {
+	SearchUsers(filters map[string]interface{}) ([]types.UserBasic, error)
+	ChangeRole(userId int, role string, event types.AuditEvent) error
+	AdjustBalance(userId int, amount int, event types.AuditEvent) (int, error)
+	SetKermesseStatus(kermesseId int, from string, to string, event types.AuditEvent) error
+	DeleteUser(userId int, event types.AuditEvent) error
+}
+
+var (
+	ErrNegativeBalance = goErrors.New("balance cannot become negative")
+	ErrBalanceLeft     = goErrors.New("the user still has jetons")
+)
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewAdminRepository(db *sqlx.DB) *Repository {
+	return &Repository{
+		db: db,
+	}
+}
+
+// SearchUsers matches the search filter against the name and the email of the
+// users.
+func (repository *Repository) SearchUsers(filters map[string]interface{}) ([]types.UserBasic, error) {
+	var users []types.UserBasic
+	query := "SELECT id, name, email, balance, role FROM users WHERE deleted_at IS NULL"
+
+	var args []interface{}
+	if search, ok := filters["search"]; ok {
+		args = append(args, "%"+search.(string)+"%")
+		query += fmt.Sprintf(" AND (name ILIKE $%d OR email ILIKE $%d)", len(args), len(args))
+	}
+	if role, ok := filters["role"]; ok {
+		args = append(args, role)
+		query += fmt.Sprintf(" AND role = $%d", len(args))
+	}
+	query += fmt.Sprintf(" ORDER BY id LIMIT %v", filters["limit"])
+
+	err := repository.db.Select(&users, query, args...)
+	return users, err
+}
+
+func (repository *Repository) ChangeRole(userId int, role string, event types.AuditEvent) error {
+	return repository.withAudit(event, func(tx *sqlx.Tx) error {
+		return utils.ExecSingleRow(tx, sql.ErrNoRows, "UPDATE users SET role=$1 WHERE id=$2", role, userId)
+	})
+}
+
+// AdjustBalance adds amount, which may be negative, to the balance of the user
+// and returns the new balance.
+func (repository *Repository) AdjustBalance(userId int, amount int, event types.AuditEvent) (int, error) {
+	var balance int
+	err := repository.withAudit(event, func(tx *sqlx.Tx) error {
+		query := "UPDATE users SET balance = balance + $1 WHERE id = $2 AND balance + $1 >= 0 RETURNING balance"
+		err := tx.Get(&balance, query, amount, userId)
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return ErrNegativeBalance
+		}
+		return err
+	})
+	return balance, err
+}
+
+// SetKermesseStatus moves the kermesse from one status to the other, it
+// returns sql.ErrNoRows when the kermesse is not in the from status.
+func (repository *Repository) SetKermesseStatus(kermesseId int, from string, to string, event types.AuditEvent) error {
+	return repository.withAudit(event, func(tx *sqlx.Tx) error {
+		return utils.ExecSingleRow(tx, sql.ErrNoRows, "UPDATE kermesses SET status=$1 WHERE id=$2 AND status=$3", to, kermesseId, from)
+	})
+}
+
+// DeleteUser soft deletes the user along with their stand, it returns
+// ErrBalanceLeft while the user has jetons they would lose.
+func (repository *Repository) DeleteUser(userId int, event types.AuditEvent) error {
+	return repository.withAudit(event, func(tx *sqlx.Tx) error {
+		err := utils.ExecSingleRow(tx, sql.ErrNoRows, "UPDATE users SET deleted_at=NOW() WHERE id=$1 AND deleted_at IS NULL AND balance = 0", userId)
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return ErrBalanceLeft
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE stands SET deleted_at=NOW() WHERE user_id=$1 AND deleted_at IS NULL", userId)
+		return err
+	})
+}
+
+// withAudit runs fn and records the audit event in the same transaction, so
+// that no admin action goes unrecorded.
+func (repository *Repository) withAudit(event types.AuditEvent, fn func(tx *sqlx.Tx) error) (err error) {
+	tx, err := repository.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+	return audit.InsertEvent(tx, event)
+}
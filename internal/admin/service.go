@@ -0,0 +1,411 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	goErrors "errors"
+	"fmt"
+	"github.com/kermesse-backend/internal/audit"
+	"github.com/kermesse-backend/internal/kermesses"
+	"github.com/kermesse-backend/internal/notifications"
+	"github.com/kermesse-backend/internal/tombolas"
+	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/internal/users"
+	"github.com/kermesse-backend/pkg/errors"
+	"github.com/kermesse-backend/pkg/utils"
+	"strconv"
+	"strings"
+)
+
+type AdminService interface {
+	SearchUsers(params map[string]interface{}) ([]types.UserBasic, error)
+	ChangeRole(ctx context.Context, userId int, input map[string]interface{}) (types.UserBasic, error)
+	AdjustBalance(ctx context.Context, userId int, input map[string]interface{}) (types.UserBasic, error)
+	DeleteUser(ctx context.Context, userId int, input map[string]interface{}) error
+	CompleteKermesse(ctx context.Context, id int, input map[string]interface{}) error
+	ReopenKermesse(ctx context.Context, id int, input map[string]interface{}) error
+	RedrawTombola(ctx context.Context, id int, input map[string]interface{}) error
+}
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 100
+)
+
+// assignableRoles are the roles an administrator may give, students are only
+// created by their parents.
+var assignableRoles = []string{
+	types.UserRoleParent,
+	types.UserRoleOrganizer,
+	types.UserRoleStandHolder,
+	types.UserRoleAdmin,
+}
+
+// searchableRoles are the roles the users can be filtered by.
+var searchableRoles = []string{
+	types.UserRoleParent,
+	types.UserRoleStudent,
+	types.UserRoleOrganizer,
+	types.UserRoleStandHolder,
+	types.UserRoleAdmin,
+}
+
+type Service struct {
+	adminRepository     AdminRepository
+	usersRepository     users.UsersRepository
+	kermessesRepository kermesses.KermessesRepository
+	tombolasService     *tombolas.Service
+	hub                 *notifications.Hub
+}
+
+func NewAdminService(adminRepository AdminRepository, usersRepository users.UsersRepository, kermessesRepository kermesses.KermessesRepository, tombolasService *tombolas.Service, hub *notifications.Hub) *Service {
+	return &Service{
+		adminRepository:     adminRepository,
+		usersRepository:     usersRepository,
+		kermessesRepository: kermessesRepository,
+		tombolasService:     tombolasService,
+		hub:                 hub,
+	}
+}
+
+func (service *Service) SearchUsers(params map[string]interface{}) ([]types.UserBasic, error) {
+	limit, err := parseLimit(params)
+	if err != nil {
+		return nil, err
+	}
+	filters := map[string]interface{}{
+		"limit": limit,
+	}
+	if search, exists := params["search"]; exists && strings.TrimSpace(search.(string)) != "" {
+		filters["search"] = strings.TrimSpace(search.(string))
+	}
+	if role, exists := params["role"]; exists {
+		if value, ok := role.(string); !ok || !contains(searchableRoles, value) {
+			return nil, errors.CustomError{
+				Key: errors.BadRequest,
+				Err: fmt.Errorf("role must be one of %s", strings.Join(searchableRoles, ", ")),
+			}
+		}
+		filters["role"] = role
+	}
+
+	users, err := service.adminRepository.SearchUsers(filters)
+	if err != nil {
+		return nil, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	if users == nil {
+		return []types.UserBasic{}, nil
+	}
+
+	return users, nil
+}
+
+func (service *Service) ChangeRole(ctx context.Context, userId int, input map[string]interface{}) (types.UserBasic, error) {
+	actorId, ok := ctx.Value(types.UserIDSessionKey).(int)
+	if !ok {
+		return types.UserBasic{}, errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("user ID not found"),
+		}
+	}
+	if actorId == userId {
+		return types.UserBasic{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("administrators cannot change their own role"),
+		}
+	}
+
+	role, _ := input["role"].(string)
+	if !isAssignable(role) {
+		return types.UserBasic{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: fmt.Errorf("role must be one of %s", strings.Join(assignableRoles, ", ")),
+		}
+	}
+
+	user, err := service.getUser(userId)
+	if err != nil {
+		return types.UserBasic{}, err
+	}
+	if user.Role == types.UserRoleStudent {
+		return types.UserBasic{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("the role of a student cannot be changed"),
+		}
+	}
+	if user.Role == role {
+		return toUserBasic(user), nil
+	}
+
+	event := audit.NewEvent(ctx, audit.Entry{
+		Action:     types.AuditActionUserRoleChanged,
+		TargetType: types.AuditTargetUser,
+		TargetId:   userId,
+		Reason:     optionalReason(input),
+		Before:     map[string]interface{}{"role": user.Role},
+		After:      map[string]interface{}{"role": role},
+	})
+	err = service.adminRepository.ChangeRole(userId, role, event)
+	if err != nil {
+		return types.UserBasic{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	user.Role = role
+	return toUserBasic(user), nil
+}
+
+// AdjustBalance adds the amount, negative to take jetons away, to the balance
+// of the user. A reason is required.
+func (service *Service) AdjustBalance(ctx context.Context, userId int, input map[string]interface{}) (types.UserBasic, error) {
+	amount, err := utils.ConvertToInt(input, "amount")
+	if err != nil {
+		return types.UserBasic{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: err,
+		}
+	}
+	if amount == 0 {
+		return types.UserBasic{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("amount cannot be zero"),
+		}
+	}
+	reason := optionalReason(input)
+	if reason == "" {
+		return types.UserBasic{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("reason is required"),
+		}
+	}
+
+	user, err := service.getUser(userId)
+	if err != nil {
+		return types.UserBasic{}, err
+	}
+
+	event := audit.NewEvent(ctx, audit.Entry{
+		Action:     types.AuditActionBalanceAdjusted,
+		TargetType: types.AuditTargetUser,
+		TargetId:   userId,
+		Reason:     reason,
+		Before:     map[string]interface{}{"balance": user.Balance},
+		After:      map[string]interface{}{"balance": user.Balance + amount},
+		Details:    map[string]interface{}{"amount": amount},
+	})
+	balance, err := service.adminRepository.AdjustBalance(userId, amount, event)
+	if err != nil {
+		if goErrors.Is(err, ErrNegativeBalance) {
+			return types.UserBasic{}, errors.CustomError{
+				Key: errors.BadRequest,
+				Err: err,
+			}
+		}
+		return types.UserBasic{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	service.hub.NotifyUser(userId, notifications.NewEvent(notifications.EventBalanceAdjusted, 0, notifications.BalanceAdjustedPayload{
+		Amount:  amount,
+		Balance: balance,
+		Reason:  reason,
+	}))
+
+	user.Balance = balance
+	return toUserBasic(user), nil
+}
+
+// DeleteUser soft deletes the account, the user can no longer log in and their
+// email may be used again. Accounts still holding jetons are kept, the balance
+// has to be adjusted first.
+func (service *Service) DeleteUser(ctx context.Context, userId int, input map[string]interface{}) error {
+	actorId, ok := ctx.Value(types.UserIDSessionKey).(int)
+	if !ok {
+		return errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("user ID not found"),
+		}
+	}
+	if actorId == userId {
+		return errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("administrators cannot delete their own account"),
+		}
+	}
+
+	user, err := service.getUser(userId)
+	if err != nil {
+		return err
+	}
+
+	event := audit.NewEvent(ctx, audit.Entry{
+		Action:     types.AuditActionUserDeleted,
+		TargetType: types.AuditTargetUser,
+		TargetId:   userId,
+		Reason:     optionalReason(input),
+		Before:     toUserBasic(user),
+	})
+	err = service.adminRepository.DeleteUser(userId, event)
+	if err != nil {
+		if goErrors.Is(err, ErrBalanceLeft) {
+			return errors.CustomError{
+				Key: errors.BadRequest,
+				Err: err,
+			}
+		}
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+// CompleteKermesse finishes the kermesse even though some of its stands or
+// tombolas are still running.
+func (service *Service) CompleteKermesse(ctx context.Context, id int, input map[string]interface{}) error {
+	return service.setKermesseStatus(ctx, id, types.KermesseStatusStarted, types.KermesseStatusFinished, types.AuditActionKermesseForced, input)
+}
+
+func (service *Service) ReopenKermesse(ctx context.Context, id int, input map[string]interface{}) error {
+	return service.setKermesseStatus(ctx, id, types.KermesseStatusFinished, types.KermesseStatusStarted, types.AuditActionKermesseReopened, input)
+}
+
+func (service *Service) setKermesseStatus(ctx context.Context, id int, from string, to string, action string, input map[string]interface{}) error {
+	_, err := service.kermessesRepository.GetKermesseById(id)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return errors.CustomError{
+				Key: errors.NotFound,
+				Err: err,
+			}
+		}
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	event := audit.NewEvent(ctx, audit.Entry{
+		Action:     action,
+		TargetType: types.AuditTargetKermesse,
+		TargetId:   id,
+		KermesseId: id,
+		Reason:     optionalReason(input),
+		Before:     map[string]interface{}{"status": from},
+		After:      map[string]interface{}{"status": to},
+	})
+	err = service.adminRepository.SetKermesseStatus(id, from, to, event)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return errors.CustomError{
+				Key: errors.BadRequest,
+				Err: fmt.Errorf("kermesse is not %s", strings.ToLower(from)),
+			}
+		}
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+// RedrawTombola cancels the draw of a finished tombola and draws it again. It
+// is refused once a prize was delivered.
+func (service *Service) RedrawTombola(ctx context.Context, id int, input map[string]interface{}) error {
+	return service.tombolasService.RedrawTombola(ctx, id, optionalReason(input))
+}
+
+// EnsureAdmin gives the administrator role to the user with this email, it
+// bootstraps the first administrator from the ADMIN_EMAIL variable.
+func (service *Service) EnsureAdmin(email string) error {
+	user, err := service.usersRepository.GetUserByEmail(email)
+	if err != nil {
+		return err
+	}
+	if user.Role == types.UserRoleAdmin {
+		return nil
+	}
+	if user.Role == types.UserRoleStudent {
+		return goErrors.New("a student cannot become an administrator")
+	}
+
+	event := audit.NewEvent(context.Background(), audit.Entry{
+		Action:     types.AuditActionUserRoleChanged,
+		TargetType: types.AuditTargetUser,
+		TargetId:   user.Id,
+		Reason:     "ADMIN_EMAIL",
+		Before:     map[string]interface{}{"role": user.Role},
+		After:      map[string]interface{}{"role": types.UserRoleAdmin},
+	})
+	return service.adminRepository.ChangeRole(user.Id, types.UserRoleAdmin, event)
+}
+
+func (service *Service) getUser(id int) (types.User, error) {
+	user, err := service.usersRepository.GetUserById(id)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return types.User{}, errors.CustomError{
+				Key: errors.NotFound,
+				Err: err,
+			}
+		}
+		return types.User{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return user, nil
+}
+
+func optionalReason(input map[string]interface{}) string {
+	reason, _ := input["reason"].(string)
+	return strings.TrimSpace(reason)
+}
+
+func parseLimit(params map[string]interface{}) (int, error) {
+	param, exists := params["limit"]
+	if !exists {
+		return defaultListLimit, nil
+	}
+	limit, err := strconv.Atoi(param.(string))
+	if err != nil || limit <= 0 || limit > maxListLimit {
+		return 0, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: fmt.Errorf("limit must be between 1 and %d", maxListLimit),
+		}
+	}
+	return limit, nil
+}
+
+func isAssignable(role string) bool {
+	return contains(assignableRoles, role)
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+func toUserBasic(user types.User) types.UserBasic {
+	return types.UserBasic{
+		Id:      user.Id,
+		Name:    user.Name,
+		Email:   user.Email,
+		Balance: user.Balance,
+		Role:    user.Role,
+	}
+}
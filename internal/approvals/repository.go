@@ -0,0 +1,120 @@
+package approvals
+
+import (
+	goErrors "errors"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/pkg/utils"
+	"strings"
+)
+
+type ApprovalsRepository interface {
+	AddPurchaseRequest(input map[string]interface{}) (types.PurchaseRequest, error)
+	GetPurchaseRequests(filters map[string]interface{}) ([]types.PurchaseRequest, error)
+	GetPurchaseRequestById(id int) (types.PurchaseRequest, error)
+	Decide(id int, status string, decidedBy int) (types.PurchaseRequest, error)
+	Fail(id int, reason string) (types.PurchaseRequest, error)
+	ExpireDueRequests() ([]types.PurchaseRequest, error)
+}
+
+// ErrNotApproved is returned when completing a request that is not approved
+// or was already completed.
+var ErrNotApproved = goErrors.New("purchase request is not approved or already completed")
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewApprovalsRepository(db *sqlx.DB) *Repository {
+	return &Repository{
+		db: db,
+	}
+}
+
+func (repository *Repository) AddPurchaseRequest(input map[string]interface{}) (types.PurchaseRequest, error) {
+	var request types.PurchaseRequest
+	query := `
+		INSERT INTO purchase_requests (kind, student_id, kermesse_id, stand_id, tombola_id, item_name, quantity, amount, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW() + MAKE_INTERVAL(secs => $9))
+		RETURNING *
+	`
+	err := repository.db.Get(&request, query, input["kind"], input["student_id"], input["kermesse_id"], input["stand_id"], input["tombola_id"], input["item_name"], input["quantity"], input["amount"], input["timeout"])
+	return request, err
+}
+
+func (repository *Repository) GetPurchaseRequests(filters map[string]interface{}) ([]types.PurchaseRequest, error) {
+	var requests []types.PurchaseRequest
+	query := "SELECT * FROM purchase_requests"
+
+	var conditions []string
+	var args []interface{}
+	for _, column := range []string{"student_id", "status"} {
+		if value, ok := filters[column]; ok {
+			args = append(args, value)
+			conditions = append(conditions, fmt.Sprintf("%s=$%d", column, len(args)))
+		}
+	}
+	if guardianId, ok := filters["guardian_id"]; ok {
+		args = append(args, guardianId)
+		conditions = append(conditions, fmt.Sprintf("student_id IN (SELECT student_id FROM student_guardians WHERE guardian_id=$%d)", len(args)))
+	}
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC"
+
+	err := repository.db.Select(&requests, query, args...)
+	return requests, err
+}
+
+func (repository *Repository) GetPurchaseRequestById(id int) (types.PurchaseRequest, error) {
+	var request types.PurchaseRequest
+	query := "SELECT * FROM purchase_requests WHERE id=$1"
+	err := repository.db.Get(&request, query, id)
+	return request, err
+}
+
+// Decide resolves a pending request that has not expired yet, it returns
+// sql.ErrNoRows when the request is no longer pending.
+func (repository *Repository) Decide(id int, status string, decidedBy int) (types.PurchaseRequest, error) {
+	var request types.PurchaseRequest
+	query := `
+		UPDATE purchase_requests SET status=$2, decided_by=$3, decided_at=NOW()
+		WHERE id=$1 AND status='PENDING' AND expires_at > NOW()
+		RETURNING *
+	`
+	err := repository.db.Get(&request, query, id, status, decidedBy)
+	return request, err
+}
+
+// Fail marks an approved request whose purchase could not be completed.
+func (repository *Repository) Fail(id int, reason string) (types.PurchaseRequest, error) {
+	var request types.PurchaseRequest
+	query := "UPDATE purchase_requests SET status='FAILED', reason=$2 WHERE id=$1 RETURNING *"
+	err := repository.db.Get(&request, query, id, reason)
+	return request, err
+}
+
+// ExpireDueRequests expires the pending requests past their deadline and
+// returns them. Each request is returned to a single caller, whatever the
+// number of instances running it.
+func (repository *Repository) ExpireDueRequests() ([]types.PurchaseRequest, error) {
+	var requests []types.PurchaseRequest
+	query := `
+		UPDATE purchase_requests SET status='EXPIRED', decided_at=NOW()
+		WHERE status='PENDING' AND expires_at <= NOW()
+		RETURNING *
+	`
+	err := repository.db.Select(&requests, query)
+	return requests, err
+}
+
+// CompleteRequest marks the approved request as completed, in the transaction
+// of its purchase, so that its reservation stops counting once the purchase
+// is recorded and never before. It returns ErrNotApproved when the request is
+// not approved or already completed.
+func CompleteRequest(execer sqlx.Execer, id int) error {
+	query := "UPDATE purchase_requests SET completed_at=NOW() WHERE id=$1 AND status='APPROVED' AND completed_at IS NULL"
+	return utils.ExecSingleRow(execer, ErrNotApproved, query, id)
+}
@@ -0,0 +1,298 @@
+package approvals
+
+import (
+	"context"
+	"database/sql"
+	goErrors "errors"
+	"fmt"
+	"github.com/kermesse-backend/internal/notifications"
+	"github.com/kermesse-backend/internal/policy"
+	"github.com/kermesse-backend/internal/push"
+	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/internal/users"
+	"github.com/kermesse-backend/pkg/errors"
+	"log"
+	"strconv"
+	"time"
+)
+
+type ApprovalsService interface {
+	GetPurchaseRequests(ctx context.Context, params map[string]interface{}) ([]types.PurchaseRequest, error)
+	GetPurchaseRequestById(ctx context.Context, id int) (types.PurchaseRequest, error)
+	ApprovePurchaseRequest(ctx context.Context, id int) (types.PurchaseRequest, error)
+	DenyPurchaseRequest(ctx context.Context, id int) (types.PurchaseRequest, error)
+}
+
+// PurchaseHandler completes or releases the purchases of one kind. Complete
+// charges the student for a request approved by the parent, Release gives
+// back the reserved stock or tickets of a request that will not complete.
+type PurchaseHandler interface {
+	CompletePurchase(request types.PurchaseRequest) error
+	ReleasePurchase(request types.PurchaseRequest) error
+}
+
+type Service struct {
+	approvalsRepository ApprovalsRepository
+	usersRepository     users.UsersRepository
+	hub                 *notifications.Hub
+	pushService         *push.Service
+	timeout             time.Duration
+	handlers            map[string]PurchaseHandler
+	policyService       policy.PolicyService
+}
+
+func NewApprovalsService(approvalsRepository ApprovalsRepository, usersRepository users.UsersRepository, hub *notifications.Hub, pushService *push.Service, timeout time.Duration, policyService policy.PolicyService) *Service {
+	return &Service{
+		approvalsRepository: approvalsRepository,
+		usersRepository:     usersRepository,
+		hub:                 hub,
+		pushService:         pushService,
+		timeout:             timeout,
+		handlers:            make(map[string]PurchaseHandler),
+		policyService:       policyService,
+	}
+}
+
+// RegisterPurchaseHandler sets the handler of a purchase kind, handlers are
+// registered at start up.
+func (service *Service) RegisterPurchaseHandler(kind string, handler PurchaseHandler) {
+	service.handlers[kind] = handler
+}
+
+// RequestApproval records the purchase of the student, whose stock or tickets
+// the caller already reserved, and asks their guardians to approve it.
+func (service *Service) RequestApproval(student types.User, request types.PurchaseRequest) (types.PurchaseRequest, error) {
+	guardianIds, err := service.usersRepository.GetGuardianIds(student.Id)
+	if err != nil {
+		return types.PurchaseRequest{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	if len(guardianIds) == 0 {
+		return types.PurchaseRequest{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("student has no guardian to approve the purchase"),
+		}
+	}
+
+	request, err = service.approvalsRepository.AddPurchaseRequest(map[string]interface{}{
+		"kind":        request.Kind,
+		"student_id":  student.Id,
+		"kermesse_id": request.KermesseId,
+		"stand_id":    request.StandId,
+		"tombola_id":  request.TombolaId,
+		"item_name":   request.ItemName,
+		"quantity":    request.Quantity,
+		"amount":      request.Amount,
+		"timeout":     service.timeout.Seconds(),
+	})
+	if err != nil {
+		return types.PurchaseRequest{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	event := notifications.NewEvent(notifications.EventApprovalRequested, request.KermesseId, purchaseRequestPayload(request, student.Name))
+	service.hub.Notify(guardianIds, event)
+	for _, guardianId := range guardianIds {
+		service.pushService.NotifyUser(guardianId, push.Message{
+			Title: "Purchase to approve",
+			Body:  fmt.Sprintf("%s wants to spend %d on %s", student.Name, request.Amount, request.ItemName),
+			Data: map[string]string{
+				"type":       notifications.EventApprovalRequested,
+				"request_id": strconv.Itoa(request.Id),
+			},
+		})
+	}
+
+	return request, nil
+}
+
+func (service *Service) GetPurchaseRequests(ctx context.Context, params map[string]interface{}) ([]types.PurchaseRequest, error) {
+	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
+	if !ok {
+		return nil, errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("user ID not found"),
+		}
+	}
+	userRole, ok := ctx.Value(types.UserRoleSessionKey).(string)
+	if !ok {
+		return nil, errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("user role not found"),
+		}
+	}
+
+	filters := make(map[string]interface{})
+	switch userRole {
+	case types.UserRoleStudent:
+		filters["student_id"] = userId
+	case types.UserRoleParent:
+		filters["guardian_id"] = userId
+	}
+	if status, exists := params["status"]; exists {
+		filters["status"] = status
+	}
+
+	requests, err := service.approvalsRepository.GetPurchaseRequests(filters)
+	if err != nil {
+		return nil, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	if requests == nil {
+		return []types.PurchaseRequest{}, nil
+	}
+
+	return requests, nil
+}
+
+func (service *Service) GetPurchaseRequestById(ctx context.Context, id int) (types.PurchaseRequest, error) {
+	request, err := service.getPurchaseRequest(id)
+	if err != nil {
+		return types.PurchaseRequest{}, err
+	}
+
+	if err := service.policyService.Authorize(ctx, policy.StudentView, policy.Resource{OwnerId: request.StudentId}); err != nil {
+		return types.PurchaseRequest{}, err
+	}
+	return request, nil
+}
+
+// ApprovePurchaseRequest completes the purchase. The approved request keeps
+// its reservation until the purchase marks it completed, in the transaction
+// of the charge. A purchase that cannot be completed anymore, for want of
+// balance for instance, is released and marked as failed.
+func (service *Service) ApprovePurchaseRequest(ctx context.Context, id int) (types.PurchaseRequest, error) {
+	request, err := service.decide(ctx, id, types.PurchaseRequestStatusApproved)
+	if err != nil {
+		return types.PurchaseRequest{}, err
+	}
+
+	completeErr := service.handlers[request.Kind].CompletePurchase(request)
+	if completeErr != nil {
+		service.release(request)
+		request, err = service.approvalsRepository.Fail(request.Id, completeErr.Error())
+		if err != nil {
+			return types.PurchaseRequest{}, errors.CustomError{
+				Key: errors.InternalServerError,
+				Err: err,
+			}
+		}
+	}
+
+	service.notifyResolved(request, request.StudentId)
+	if completeErr != nil {
+		return types.PurchaseRequest{}, completeErr
+	}
+	return request, nil
+}
+
+func (service *Service) DenyPurchaseRequest(ctx context.Context, id int) (types.PurchaseRequest, error) {
+	request, err := service.decide(ctx, id, types.PurchaseRequestStatusDenied)
+	if err != nil {
+		return types.PurchaseRequest{}, err
+	}
+
+	service.release(request)
+	service.notifyResolved(request, request.StudentId)
+	return request, nil
+}
+
+// ExpireDueRequests denies the requests the parent did not answer in time and
+// releases their reservations.
+func (service *Service) ExpireDueRequests() error {
+	requests, err := service.approvalsRepository.ExpireDueRequests()
+	if err != nil {
+		return err
+	}
+	for _, request := range requests {
+		service.release(request)
+		guardianIds, err := service.usersRepository.GetGuardianIds(request.StudentId)
+		if err != nil {
+			log.Printf("Unable to get guardians of student %d: %v", request.StudentId, err)
+		}
+		service.notifyResolved(request, append([]int{request.StudentId}, guardianIds...)...)
+	}
+	return nil
+}
+
+// decide checks that the user in context is a guardian of the student and
+// resolves the request, if it is still pending.
+func (service *Service) decide(ctx context.Context, id int, status string) (types.PurchaseRequest, error) {
+	request, err := service.getPurchaseRequest(id)
+	if err != nil {
+		return types.PurchaseRequest{}, err
+	}
+	if err := service.policyService.Authorize(ctx, policy.StudentManage, policy.Resource{OwnerId: request.StudentId}); err != nil {
+		return types.PurchaseRequest{}, err
+	}
+
+	userId := ctx.Value(types.UserIDSessionKey).(int)
+	request, err = service.approvalsRepository.Decide(id, status, userId)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return types.PurchaseRequest{}, errors.CustomError{
+				Key: errors.BadRequest,
+				Err: goErrors.New("purchase request is no longer pending"),
+			}
+		}
+		return types.PurchaseRequest{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return request, nil
+}
+
+func (service *Service) getPurchaseRequest(id int) (types.PurchaseRequest, error) {
+	request, err := service.approvalsRepository.GetPurchaseRequestById(id)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return request, errors.CustomError{
+				Key: errors.NotFound,
+				Err: err,
+			}
+		}
+		return request, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return request, nil
+}
+
+func (service *Service) release(request types.PurchaseRequest) {
+	if err := service.handlers[request.Kind].ReleasePurchase(request); err != nil {
+		log.Printf("Unable to release purchase request %d: %v", request.Id, err)
+	}
+}
+
+func (service *Service) notifyResolved(request types.PurchaseRequest, userIds ...int) {
+	var studentName string
+	if student, err := service.usersRepository.GetUserById(request.StudentId); err == nil {
+		studentName = student.Name
+	}
+	event := notifications.NewEvent(notifications.EventPurchaseResolved, request.KermesseId, purchaseRequestPayload(request, studentName))
+	service.hub.Notify(userIds, event)
+}
+
+func purchaseRequestPayload(request types.PurchaseRequest, studentName string) notifications.PurchaseRequestPayload {
+	return notifications.PurchaseRequestPayload{
+		RequestId:   request.Id,
+		Kind:        request.Kind,
+		StudentId:   request.StudentId,
+		StudentName: studentName,
+		ItemName:    request.ItemName,
+		Quantity:    request.Quantity,
+		Amount:      request.Amount,
+		Status:      request.Status,
+		Reason:      request.Reason,
+		ExpiresAt:   request.ExpiresAt,
+	}
+}
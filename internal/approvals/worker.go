@@ -0,0 +1,39 @@
+package approvals
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Worker denies the purchase requests left unanswered past their deadline.
+// Every API instance runs one, each expired request is handled once.
+type Worker struct {
+	approvalsService *Service
+	interval         time.Duration
+}
+
+func NewWorker(approvalsService *Service, interval time.Duration) *Worker {
+	return &Worker{
+		approvalsService: approvalsService,
+		interval:         interval,
+	}
+}
+
+// Start blocks and expires the due requests on every tick until the context
+// is done.
+func (worker *Worker) Start(ctx context.Context) {
+	ticker := time.NewTicker(worker.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := worker.approvalsService.ExpireDueRequests(); err != nil {
+				log.Printf("Error expiring purchase requests: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package audit
+
+import (
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/kermesse-backend/internal/types"
+	"strings"
+)
+
+type AuditRepository interface {
+	AddEvent(event types.AuditEvent) error
+	GetEvents(filters map[string]interface{}) ([]types.AuditEvent, error)
+	GetKermesseOrganizerId(kermesseId int) (int, error)
+}
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewAuditRepository(db *sqlx.DB) *Repository {
+	return &Repository{
+		db: db,
+	}
+}
+
+func (repository *Repository) AddEvent(event types.AuditEvent) error {
+	return InsertEvent(repository.db, event)
+}
+
+// GetEvents returns the events matching the filters, the most recent first.
+// The organizer_id filter keeps the events of the kermesses of the organizer.
+func (repository *Repository) GetEvents(filters map[string]interface{}) ([]types.AuditEvent, error) {
+	var events []types.AuditEvent
+	query := "SELECT * FROM audit_events WHERE 1=1"
+
+	var conditions []string
+	if actorId, ok := filters["actor_id"]; ok {
+		conditions = append(conditions, fmt.Sprintf("actor_id = %v", actorId))
+	}
+	if targetId, ok := filters["target_id"]; ok {
+		conditions = append(conditions, fmt.Sprintf("target_id = %v", targetId))
+	}
+	if kermesseId, ok := filters["kermesse_id"]; ok {
+		conditions = append(conditions, fmt.Sprintf("kermesse_id = %v", kermesseId))
+	}
+	if organizerId, ok := filters["organizer_id"]; ok {
+		conditions = append(conditions, fmt.Sprintf("kermesse_id IN (SELECT id FROM kermesses WHERE user_id = %v)", organizerId))
+	}
+
+	var args []interface{}
+	if targetType, ok := filters["target_type"]; ok {
+		args = append(args, targetType)
+		conditions = append(conditions, fmt.Sprintf("target_type = $%d", len(args)))
+	}
+	if action, ok := filters["action"]; ok {
+		args = append(args, action)
+		conditions = append(conditions, fmt.Sprintf("action = $%d", len(args)))
+	}
+	if from, ok := filters["from"]; ok {
+		args = append(args, from)
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if to, ok := filters["to"]; ok {
+		args = append(args, to)
+		conditions = append(conditions, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+
+	if len(conditions) > 0 {
+		query += " AND " + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT %v", filters["limit"])
+
+	err := repository.db.Select(&events, query, args...)
+	return events, err
+}
+
+func (repository *Repository) GetKermesseOrganizerId(kermesseId int) (int, error) {
+	var organizerId int
+	query := "SELECT user_id FROM kermesses WHERE id=$1"
+	err := repository.db.Get(&organizerId, query, kermesseId)
+	return organizerId, err
+}
+
+// InsertEvent records the event with the execer, a transaction when the event
+// must only be recorded along with the action.
+func InsertEvent(execer sqlx.Execer, event types.AuditEvent) error {
+	query := `
+		INSERT INTO audit_events (actor_id, action, target_type, target_id, kermesse_id, reason, changes, details, ip, request_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+	`
+	_, err := execer.Exec(query, event.ActorId, event.Action, event.TargetType, event.TargetId, event.KermesseId, event.Reason, event.Changes, event.Details, event.Ip, event.RequestId)
+	return err
+}
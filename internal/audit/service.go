@@ -0,0 +1,353 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	goErrors "errors"
+	"fmt"
+	"github.com/kermesse-backend/internal/policy"
+	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/pkg/errors"
+	"io"
+	"log"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type AuditService interface {
+	GetEvents(ctx context.Context, params map[string]interface{}) ([]types.AuditEvent, error)
+	ExportEvents(ctx context.Context, params map[string]interface{}, w io.Writer) error
+}
+
+const (
+	defaultListLimit = 50
+	maxListLimit     = 100
+	// maxExportRows bounds the CSV export, narrower dates give the older
+	// events.
+	maxExportRows = 10000
+)
+
+// csvHeader lists the columns of the CSV export.
+var csvHeader = []string{"id", "created_at", "actor_id", "action", "target_type", "target_id", "kermesse_id", "reason", "changes", "details", "ip", "request_id"}
+
+// Entry describes an action to record. Before and After are the resource, or
+// the fields of interest, before and after the action: they are compared
+// field by field to store what changed. KermesseId is 0 when the action is
+// not part of a kermesse.
+type Entry struct {
+	Action     string
+	TargetType string
+	TargetId   int
+	KermesseId int
+	Reason     string
+	Before     interface{}
+	After      interface{}
+	Details    map[string]interface{}
+}
+
+type Service struct {
+	auditRepository AuditRepository
+	policyService   policy.PolicyService
+}
+
+func NewAuditService(auditRepository AuditRepository, policyService policy.PolicyService) *Service {
+	return &Service{
+		auditRepository: auditRepository,
+		policyService:   policyService,
+	}
+}
+
+// Record stores the entry along with the user, the IP and the request ID in
+// context. The action already happened, a failure is only logged.
+func (service *Service) Record(ctx context.Context, entry Entry) {
+	if err := service.auditRepository.AddEvent(NewEvent(ctx, entry)); err != nil {
+		log.Printf("Unable to record %s on %s %d: %v", entry.Action, entry.TargetType, entry.TargetId, err)
+	}
+}
+
+// NewEvent builds the audit event of the entry, for the callers recording it
+// in their own transaction with InsertEvent.
+func NewEvent(ctx context.Context, entry Entry) types.AuditEvent {
+	event := types.AuditEvent{
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetId:   entry.TargetId,
+		Changes:    encode(diff(entry.Before, entry.After)),
+		Details:    encode(entry.Details),
+	}
+	if actorId, ok := ctx.Value(types.UserIDSessionKey).(int); ok {
+		event.ActorId = &actorId
+	}
+	if entry.KermesseId != 0 {
+		event.KermesseId = &entry.KermesseId
+	}
+	if entry.Reason != "" {
+		event.Reason = &entry.Reason
+	}
+	// the column only holds a valid address, written in its canonical form
+	if value, ok := ctx.Value(types.ClientIPSessionKey).(string); ok {
+		if ip := net.ParseIP(value); ip != nil {
+			canonical := ip.String()
+			event.Ip = &canonical
+		}
+	}
+	if requestId, ok := ctx.Value(types.RequestIDSessionKey).(string); ok && requestId != "" {
+		event.RequestId = &requestId
+	}
+	return event
+}
+
+// GetEvents returns every event to the administrators, and the events of
+// their kermesses to the organizers.
+func (service *Service) GetEvents(ctx context.Context, params map[string]interface{}) ([]types.AuditEvent, error) {
+	limit, err := parseLimit(params)
+	if err != nil {
+		return nil, err
+	}
+	filters, err := service.getFilters(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	filters["limit"] = limit
+
+	events, err := service.auditRepository.GetEvents(filters)
+	if err != nil {
+		return nil, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	if events == nil {
+		return []types.AuditEvent{}, nil
+	}
+
+	return events, nil
+}
+
+// ExportEvents writes the events matching the same filters as GetEvents as
+// CSV, up to maxExportRows of them.
+func (service *Service) ExportEvents(ctx context.Context, params map[string]interface{}, w io.Writer) error {
+	filters, err := service.getFilters(ctx, params)
+	if err != nil {
+		return err
+	}
+	filters["limit"] = maxExportRows
+
+	events, err := service.auditRepository.GetEvents(filters)
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(csvHeader); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	for _, event := range events {
+		if err := writer.Write(toRecord(event)); err != nil {
+			return errors.CustomError{
+				Key: errors.InternalServerError,
+				Err: err,
+			}
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+// getFilters parses the query parameters and scopes the organizers to their
+// own kermesses.
+func (service *Service) getFilters(ctx context.Context, params map[string]interface{}) (map[string]interface{}, error) {
+	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
+	if !ok {
+		return nil, errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("user ID not found"),
+		}
+	}
+	userRole, _ := ctx.Value(types.UserRoleSessionKey).(string)
+
+	filters := make(map[string]interface{})
+	for _, key := range []string{"actor_id", "target_id", "kermesse_id"} {
+		if param, exists := params[key]; exists {
+			value, err := strconv.Atoi(param.(string))
+			if err != nil {
+				return nil, errors.CustomError{
+					Key: errors.BadRequest,
+					Err: fmt.Errorf("%s is not a valid number", key),
+				}
+			}
+			filters[key] = value
+		}
+	}
+	for _, key := range []string{"target_type", "action"} {
+		if param, exists := params[key]; exists {
+			filters[key] = param
+		}
+	}
+	for _, key := range []string{"from", "to"} {
+		if param, exists := params[key]; exists {
+			value, err := parseTime(param.(string))
+			if err != nil {
+				return nil, errors.CustomError{
+					Key: errors.BadRequest,
+					Err: fmt.Errorf("%s must be a date or an RFC 3339 time", key),
+				}
+			}
+			filters[key] = value
+		}
+	}
+
+	if kermesseId, exists := filters["kermesse_id"]; exists {
+		organizerId, err := service.auditRepository.GetKermesseOrganizerId(kermesseId.(int))
+		if err != nil {
+			if goErrors.Is(err, sql.ErrNoRows) {
+				return nil, errors.CustomError{
+					Key: errors.NotFound,
+					Err: err,
+				}
+			}
+			return nil, errors.CustomError{
+				Key: errors.InternalServerError,
+				Err: err,
+			}
+		}
+		err = service.policyService.Authorize(ctx, policy.AuditView, policy.Resource{OwnerId: organizerId, KermesseId: kermesseId.(int)})
+		if err != nil {
+			return nil, err
+		}
+	} else if userRole != types.UserRoleAdmin {
+		filters["organizer_id"] = userId
+	}
+
+	return filters, nil
+}
+
+// diff returns the fields of after that differ from before, along with the
+// fields of before that after does not have.
+func diff(before interface{}, after interface{}) map[string]types.AuditChange {
+	beforeFields := toFields(before)
+	afterFields := toFields(after)
+
+	changes := make(map[string]types.AuditChange)
+	for key, value := range afterFields {
+		previous, exists := beforeFields[key]
+		if !exists || !reflect.DeepEqual(previous, value) {
+			changes[key] = types.AuditChange{Before: previous, After: value}
+		}
+	}
+	for key, previous := range beforeFields {
+		if _, exists := afterFields[key]; !exists {
+			changes[key] = types.AuditChange{Before: previous}
+		}
+	}
+	return changes
+}
+
+// toFields turns a struct or a map into its JSON fields, so that both compare
+// alike.
+func toFields(value interface{}) map[string]interface{} {
+	fields := make(map[string]interface{})
+	if value == nil {
+		return fields
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fields
+	}
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return make(map[string]interface{})
+	}
+	return fields
+}
+
+func encode(value interface{}) []byte {
+	encoded, err := json.Marshal(value)
+	if err != nil || string(encoded) == "null" {
+		return []byte("{}")
+	}
+	return encoded
+}
+
+func toRecord(event types.AuditEvent) []string {
+	record := []string{
+		strconv.Itoa(event.Id),
+		event.CreatedAt.UTC().Format(time.RFC3339),
+		formatInt(event.ActorId),
+		event.Action,
+		event.TargetType,
+		strconv.Itoa(event.TargetId),
+		formatInt(event.KermesseId),
+		formatString(event.Reason),
+		string(event.Changes),
+		string(event.Details),
+		formatString(event.Ip),
+		formatString(event.RequestId),
+	}
+	for i, cell := range record {
+		record[i] = escapeFormula(cell)
+	}
+	return record
+}
+
+// escapeFormula prefixes the cells a spreadsheet would run as a formula with a
+// quote, the reasons and details come from the users.
+func escapeFormula(cell string) string {
+	if cell != "" && strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return "'" + cell
+	}
+	return cell
+}
+
+func formatInt(value *int) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.Itoa(*value)
+}
+
+func formatString(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+func parseTime(value string) (time.Time, error) {
+	if parsed, err := time.Parse(time.RFC3339, value); err == nil {
+		return parsed, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+func parseLimit(params map[string]interface{}) (int, error) {
+	param, exists := params["limit"]
+	if !exists {
+		return defaultListLimit, nil
+	}
+	limit, err := strconv.Atoi(param.(string))
+	if err != nil || limit <= 0 || limit > maxListLimit {
+		return 0, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: fmt.Errorf("limit must be between 1 and %d", maxListLimit),
+		}
+	}
+	return limit, nil
+}
@@ -0,0 +1,169 @@
+package guardians
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/kermesse-backend/internal/types"
+)
+
+type GuardiansRepository interface {
+	GetGuardians(studentId int) ([]types.Guardian, error)
+	GetGuardianRole(studentId int, guardianId int) (string, error)
+	RemoveGuardian(studentId int, guardianId int) error
+	AddInvitation(input map[string]interface{}) (types.GuardianInvitation, error)
+	DeleteInvitation(id int) error
+	HasPendingInvitation(studentId int, email string) (bool, error)
+	GetInvitationById(id int) (types.GuardianInvitation, error)
+	GetPendingInvitationsByEmail(email string) ([]types.GuardianInvitation, error)
+	AcceptInvitation(id int, guardianId int) error
+	DeclineInvitation(id int) error
+}
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewGuardiansRepository(db *sqlx.DB) *Repository {
+	return &Repository{
+		db: db,
+	}
+}
+
+func (repository *Repository) GetGuardians(studentId int) ([]types.Guardian, error) {
+	var guardians []types.Guardian
+	query := `
+		SELECT sg.student_id, sg.guardian_id, u.name, u.email, sg.role, sg.created_at
+		FROM student_guardians sg
+		JOIN users u ON u.id = sg.guardian_id
+		WHERE sg.student_id = $1
+		ORDER BY sg.role, sg.created_at
+	`
+	err := repository.db.Select(&guardians, query, studentId)
+	return guardians, err
+}
+
+// GetGuardianRole returns sql.ErrNoRows when the user is not a guardian of the
+// student.
+func (repository *Repository) GetGuardianRole(studentId int, guardianId int) (string, error) {
+	var role string
+	query := "SELECT role FROM student_guardians WHERE student_id = $1 AND guardian_id = $2"
+	err := repository.db.Get(&role, query, studentId, guardianId)
+	return role, err
+}
+
+func (repository *Repository) RemoveGuardian(studentId int, guardianId int) error {
+	query := "DELETE FROM student_guardians WHERE student_id = $1 AND guardian_id = $2 AND role = 'SECONDARY'"
+	_, err := repository.db.Exec(query, studentId, guardianId)
+	return err
+}
+
+// AddInvitation expires the previous invitation sent to the email for the
+// student, if it is past its expiry, and records the new one.
+func (repository *Repository) AddInvitation(input map[string]interface{}) (invitation types.GuardianInvitation, err error) {
+	tx, err := repository.db.Beginx()
+	if err != nil {
+		return invitation, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	query := `
+		UPDATE guardian_invitations SET status = 'EXPIRED'
+		WHERE student_id = $1 AND LOWER(email) = LOWER($2) AND status = 'PENDING' AND expires_at <= NOW()
+	`
+	_, err = tx.Exec(query, input["student_id"], input["email"])
+	if err != nil {
+		return invitation, err
+	}
+
+	query = `
+		WITH invitation AS (
+			INSERT INTO guardian_invitations (student_id, email, invited_by, token_hash, expires_at)
+			VALUES ($1, $2, $3, $4, NOW() + MAKE_INTERVAL(secs => $5))
+			RETURNING *
+		)
+		SELECT i.*, u.name AS student_name FROM invitation i JOIN users u ON u.id = i.student_id
+	`
+	err = tx.Get(&invitation, query, input["student_id"], input["email"], input["invited_by"], input["token_hash"], input["timeout"])
+	return invitation, err
+}
+
+// DeleteInvitation removes an invitation whose email could not be sent, the
+// student can then be invited again.
+func (repository *Repository) DeleteInvitation(id int) error {
+	query := "DELETE FROM guardian_invitations WHERE id = $1 AND status = 'PENDING'"
+	_, err := repository.db.Exec(query, id)
+	return err
+}
+
+func (repository *Repository) HasPendingInvitation(studentId int, email string) (bool, error) {
+	var exists bool
+	query := "SELECT EXISTS (SELECT 1 FROM guardian_invitations WHERE student_id = $1 AND LOWER(email) = LOWER($2) AND status = 'PENDING' AND expires_at > NOW())"
+	err := repository.db.Get(&exists, query, studentId, email)
+	return exists, err
+}
+
+func (repository *Repository) GetInvitationById(id int) (types.GuardianInvitation, error) {
+	var invitation types.GuardianInvitation
+	query := `
+		SELECT i.*, u.name AS student_name
+		FROM guardian_invitations i
+		JOIN users u ON u.id = i.student_id
+		WHERE i.id = $1
+	`
+	err := repository.db.Get(&invitation, query, id)
+	return invitation, err
+}
+
+func (repository *Repository) GetPendingInvitationsByEmail(email string) ([]types.GuardianInvitation, error) {
+	var invitations []types.GuardianInvitation
+	query := `
+		SELECT i.*, u.name AS student_name
+		FROM guardian_invitations i
+		JOIN users u ON u.id = i.student_id
+		WHERE LOWER(i.email) = LOWER($1) AND i.status = 'PENDING' AND i.expires_at > NOW()
+		ORDER BY i.id DESC
+	`
+	err := repository.db.Select(&invitations, query, email)
+	return invitations, err
+}
+
+// AcceptInvitation makes the user a secondary guardian of the student, it
+// returns sql.ErrNoRows when the invitation is no longer pending or has
+// expired.
+func (repository *Repository) AcceptInvitation(id int, guardianId int) (err error) {
+	tx, err := repository.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var studentId int
+	query := "UPDATE guardian_invitations SET status = 'ACCEPTED', responded_at = NOW() WHERE id = $1 AND status = 'PENDING' AND expires_at > NOW() RETURNING student_id"
+	err = tx.Get(&studentId, query, id)
+	if err != nil {
+		return err
+	}
+
+	query = "INSERT INTO student_guardians (student_id, guardian_id, role) VALUES ($1, $2, 'SECONDARY') ON CONFLICT DO NOTHING"
+	_, err = tx.Exec(query, studentId, guardianId)
+	return err
+}
+
+// DeclineInvitation returns sql.ErrNoRows when the invitation is no longer
+// pending or has expired.
+func (repository *Repository) DeclineInvitation(id int) error {
+	var invitationId int
+	query := "UPDATE guardian_invitations SET status = 'DECLINED', responded_at = NOW() WHERE id = $1 AND status = 'PENDING' AND expires_at > NOW() RETURNING id"
+	return repository.db.Get(&invitationId, query, id)
+}
@@ -0,0 +1,442 @@
+package guardians
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	goErrors "errors"
+	"fmt"
+	"github.com/kermesse-backend/internal/mail"
+	"github.com/kermesse-backend/internal/notifications"
+	"github.com/kermesse-backend/internal/policy"
+	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/internal/users"
+	"github.com/kermesse-backend/pkg/errors"
+	"log"
+	netMail "net/mail"
+	"strings"
+	"time"
+)
+
+type GuardiansService interface {
+	GetGuardians(ctx context.Context, studentId int) ([]types.Guardian, error)
+	InviteGuardian(ctx context.Context, studentId int, input map[string]interface{}) (types.GuardianInvitation, error)
+	RemoveGuardian(ctx context.Context, studentId int, guardianId int) error
+	GetInvitations(ctx context.Context) ([]types.GuardianInvitation, error)
+	AcceptInvitation(ctx context.Context, id int, input map[string]interface{}) error
+	DeclineInvitation(ctx context.Context, id int, input map[string]interface{}) error
+}
+
+type Service struct {
+	guardiansRepository GuardiansRepository
+	usersRepository     users.UsersRepository
+	hub                 *notifications.Hub
+	policyService       policy.PolicyService
+	mailSender          mail.Sender
+	// appUrl is the address of the web app, the invitation emails link to
+	// it.
+	appUrl            string
+	invitationTimeout time.Duration
+}
+
+func NewGuardiansService(guardiansRepository GuardiansRepository, usersRepository users.UsersRepository, hub *notifications.Hub, policyService policy.PolicyService, mailSender mail.Sender, appUrl string, invitationTimeout time.Duration) *Service {
+	return &Service{
+		guardiansRepository: guardiansRepository,
+		usersRepository:     usersRepository,
+		hub:                 hub,
+		policyService:       policyService,
+		mailSender:          mailSender,
+		appUrl:              strings.TrimSuffix(appUrl, "/"),
+		invitationTimeout:   invitationTimeout,
+	}
+}
+
+// GetGuardians is available to the student and to their guardians.
+func (service *Service) GetGuardians(ctx context.Context, studentId int) ([]types.Guardian, error) {
+	if err := service.policyService.Authorize(ctx, policy.StudentView, policy.Resource{OwnerId: studentId}); err != nil {
+		return nil, err
+	}
+
+	guardians, err := service.guardiansRepository.GetGuardians(studentId)
+	if err != nil {
+		return nil, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	if guardians == nil {
+		return []types.Guardian{}, nil
+	}
+
+	return guardians, nil
+}
+
+// InviteGuardian lets any guardian of the student invite a parent, by email,
+// to become a secondary guardian. The parent may not have an account yet, the
+// invitation is emailed to them either way and expires after the invitation
+// timeout.
+func (service *Service) InviteGuardian(ctx context.Context, studentId int, input map[string]interface{}) (types.GuardianInvitation, error) {
+	if err := service.policyService.Authorize(ctx, policy.StudentManage, policy.Resource{OwnerId: studentId}); err != nil {
+		return types.GuardianInvitation{}, err
+	}
+	userId := ctx.Value(types.UserIDSessionKey).(int)
+
+	email, ok := input["email"].(string)
+	email = strings.TrimSpace(email)
+	if !ok || email == "" {
+		return types.GuardianInvitation{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("email is required"),
+		}
+	}
+	if address, err := netMail.ParseAddress(email); err != nil || address.Address != email {
+		return types.GuardianInvitation{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("email is invalid"),
+		}
+	}
+
+	invitee, err := service.usersRepository.GetUserByEmail(email)
+	if err != nil && !goErrors.Is(err, sql.ErrNoRows) {
+		return types.GuardianInvitation{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	inviteeExists := err == nil
+	if inviteeExists {
+		if invitee.Role != types.UserRoleParent {
+			return types.GuardianInvitation{}, errors.CustomError{
+				Key: errors.BadRequest,
+				Err: goErrors.New("only a parent can become a guardian"),
+			}
+		}
+		isGuardian, err := service.usersRepository.IsGuardian(studentId, invitee.Id)
+		if err != nil {
+			return types.GuardianInvitation{}, errors.CustomError{
+				Key: errors.InternalServerError,
+				Err: err,
+			}
+		}
+		if isGuardian {
+			return types.GuardianInvitation{}, errors.CustomError{
+				Key: errors.BadRequest,
+				Err: goErrors.New("user is already a guardian of the student"),
+			}
+		}
+	}
+
+	pending, err := service.guardiansRepository.HasPendingInvitation(studentId, email)
+	if err != nil {
+		return types.GuardianInvitation{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	if pending {
+		return types.GuardianInvitation{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("an invitation is already pending for this email"),
+		}
+	}
+
+	var inviterName string
+	if inviter, err := service.usersRepository.GetUserById(userId); err == nil {
+		inviterName = inviter.Name
+	}
+
+	token, err := newInvitationToken()
+	if err != nil {
+		return types.GuardianInvitation{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	invitation, err := service.guardiansRepository.AddInvitation(map[string]interface{}{
+		"student_id": studentId,
+		"email":      email,
+		"invited_by": userId,
+		"token_hash": hashInvitationToken(token),
+		"timeout":    service.invitationTimeout.Seconds(),
+	})
+	if err != nil {
+		return types.GuardianInvitation{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	// the email is sent once the invitation is committed, a slow mail server
+	// must not hold a transaction open. The invitation is dropped when the
+	// invitee cannot learn about it.
+	err = service.mailSender.Send(ctx, service.invitationMessage(invitation, token, inviterName))
+	if err != nil {
+		if deleteErr := service.guardiansRepository.DeleteInvitation(invitation.Id); deleteErr != nil {
+			log.Printf("Unable to delete invitation %d: %v", invitation.Id, deleteErr)
+		}
+		return types.GuardianInvitation{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: fmt.Errorf("unable to send the invitation email: %w", err),
+		}
+	}
+
+	if inviteeExists {
+		service.hub.NotifyUser(invitee.Id, notifications.NewEvent(notifications.EventGuardianInvited, 0, notifications.GuardianInvitedPayload{
+			InvitationId:  invitation.Id,
+			StudentId:     studentId,
+			StudentName:   invitation.StudentName,
+			InvitedBy:     userId,
+			InvitedByName: inviterName,
+		}))
+	}
+
+	return invitation, nil
+}
+
+// invitationMessage links to the invitation in the web app, where the invitee
+// signs up with the invited email if they have no account yet. The link holds
+// the token answering the invitation.
+func (service *Service) invitationMessage(invitation types.GuardianInvitation, token string, inviterName string) mail.Message {
+	inviter := inviterName
+	if inviter == "" {
+		inviter = "A guardian"
+	}
+	return mail.Message{
+		To:      invitation.Email,
+		Subject: fmt.Sprintf("Become a guardian of %s", invitation.StudentName),
+		Body: fmt.Sprintf(
+			"%s invites you to become a guardian of %s.\n\n"+
+				"Open the link below to accept or decline the invitation, signing up with this email if you have no account yet:\n%s/guardian-invitations/%d?token=%s\n\n"+
+				"The invitation expires on %s.\n",
+			inviter, invitation.StudentName, service.appUrl, invitation.Id, token, invitation.ExpiresAt.UTC().Format("January 2, 2006 at 15:04 UTC"),
+		),
+	}
+}
+
+// RemoveGuardian lets the primary guardian remove a secondary guardian, and a
+// secondary guardian remove themselves. The primary guardian stays.
+func (service *Service) RemoveGuardian(ctx context.Context, studentId int, guardianId int) error {
+	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
+	if !ok {
+		return errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("user ID not found"),
+		}
+	}
+	role, err := service.getGuardianRole(studentId, userId)
+	if err != nil {
+		return err
+	}
+	if role != types.GuardianRolePrimary && guardianId != userId {
+		return errors.CustomError{
+			Key: errors.Forbidden,
+			Err: goErrors.New("only the primary guardian can remove another guardian"),
+		}
+	}
+
+	guardianRole, err := service.guardiansRepository.GetGuardianRole(studentId, guardianId)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return errors.CustomError{
+				Key: errors.NotFound,
+				Err: goErrors.New("guardian not found"),
+			}
+		}
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	if guardianRole == types.GuardianRolePrimary {
+		return errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("the primary guardian cannot be removed"),
+		}
+	}
+
+	err = service.guardiansRepository.RemoveGuardian(studentId, guardianId)
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+// GetInvitations returns the pending invitations sent to the email of the user
+// in context.
+func (service *Service) GetInvitations(ctx context.Context) ([]types.GuardianInvitation, error) {
+	user, err := service.getUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	invitations, err := service.guardiansRepository.GetPendingInvitationsByEmail(user.Email)
+	if err != nil {
+		return nil, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	if invitations == nil {
+		return []types.GuardianInvitation{}, nil
+	}
+
+	return invitations, nil
+}
+
+func (service *Service) AcceptInvitation(ctx context.Context, id int, input map[string]interface{}) error {
+	user, err := service.getInvitee(ctx, id, input)
+	if err != nil {
+		return err
+	}
+
+	err = service.guardiansRepository.AcceptInvitation(id, user.Id)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return errors.CustomError{
+				Key: errors.BadRequest,
+				Err: goErrors.New("invitation is no longer pending or has expired"),
+			}
+		}
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (service *Service) DeclineInvitation(ctx context.Context, id int, input map[string]interface{}) error {
+	if _, err := service.getInvitee(ctx, id, input); err != nil {
+		return err
+	}
+
+	err := service.guardiansRepository.DeclineInvitation(id)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return errors.CustomError{
+				Key: errors.BadRequest,
+				Err: goErrors.New("invitation is no longer pending or has expired"),
+			}
+		}
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+// getInvitee returns the user in context if they are a parent, the invitation
+// was sent to their email and input["token"] is the token of the emailed link.
+// The email alone is not enough, it is not verified at signup.
+func (service *Service) getInvitee(ctx context.Context, invitationId int, input map[string]interface{}) (types.User, error) {
+	token, ok := input["token"].(string)
+	if !ok || token == "" {
+		return types.User{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("token is required"),
+		}
+	}
+
+	user, err := service.getUser(ctx)
+	if err != nil {
+		return types.User{}, err
+	}
+	if user.Role != types.UserRoleParent {
+		return types.User{}, errors.CustomError{
+			Key: errors.Forbidden,
+			Err: goErrors.New("only a parent can become a guardian"),
+		}
+	}
+
+	invitation, err := service.guardiansRepository.GetInvitationById(invitationId)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return types.User{}, errors.CustomError{
+				Key: errors.NotFound,
+				Err: err,
+			}
+		}
+		return types.User{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	if !strings.EqualFold(invitation.Email, user.Email) {
+		return types.User{}, errors.CustomError{
+			Key: errors.Forbidden,
+			Err: goErrors.New("invitation was sent to another email"),
+		}
+	}
+	tokenHash := hashInvitationToken(token)
+	if invitation.TokenHash == nil || subtle.ConstantTimeCompare([]byte(*invitation.TokenHash), []byte(tokenHash)) != 1 {
+		return types.User{}, errors.CustomError{
+			Key: errors.Forbidden,
+			Err: goErrors.New("invalid invitation token"),
+		}
+	}
+	return user, nil
+}
+
+func (service *Service) getUser(ctx context.Context) (types.User, error) {
+	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
+	if !ok {
+		return types.User{}, errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("user ID not found"),
+		}
+	}
+	user, err := service.usersRepository.GetUserById(userId)
+	if err != nil {
+		return types.User{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return user, nil
+}
+
+// getGuardianRole returns a forbidden error when the user is not a guardian
+// of the student.
+func (service *Service) getGuardianRole(studentId int, userId int) (string, error) {
+	role, err := service.guardiansRepository.GetGuardianRole(studentId, userId)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return "", errors.CustomError{
+				Key: errors.Forbidden,
+				Err: goErrors.New("not allowed"),
+			}
+		}
+		return "", errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return role, nil
+}
+
+// newInvitationToken returns the random token of an invitation link.
+func newInvitationToken() (string, error) {
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
+
+// hashInvitationToken is what the database keeps of a token, a leaked table
+// does not answer any invitation.
+func hashInvitationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
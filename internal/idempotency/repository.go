@@ -0,0 +1,71 @@
+package idempotency
+
+import (
+	"database/sql"
+	goErrors "errors"
+	"github.com/jmoiron/sqlx"
+	"github.com/kermesse-backend/internal/types"
+)
+
+type IdempotencyRepository interface {
+	Reserve(record types.IdempotencyKey) (bool, error)
+	GetKey(userId int, key string) (types.IdempotencyKey, error)
+	Complete(record types.IdempotencyKey) error
+	DeleteExpired() (int64, error)
+}
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewIdempotencyRepository(db *sqlx.DB) *Repository {
+	return &Repository{
+		db: db,
+	}
+}
+
+// Reserve records the key for the request about to be handled, it returns
+// false when the caller already used the key. A key is kept 24 hours, even
+// when its request got no response stored: the handler may have charged the
+// caller before failing, running it again could charge twice.
+func (repository *Repository) Reserve(record types.IdempotencyKey) (bool, error) {
+	var reserved bool
+	query := `
+		INSERT INTO idempotency_keys (user_id, key, method, path, request_hash)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, key) DO UPDATE
+		SET method = EXCLUDED.method, path = EXCLUDED.path, request_hash = EXCLUDED.request_hash,
+			status_code = NULL, headers = '{}', body = NULL, created_at = NOW()
+		WHERE idempotency_keys.created_at < NOW() - INTERVAL '24 hours'
+		RETURNING TRUE
+	`
+	err := repository.db.Get(&reserved, query, record.UserId, record.Key, record.Method, record.Path, record.RequestHash)
+	if goErrors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return reserved, err
+}
+
+func (repository *Repository) GetKey(userId int, key string) (types.IdempotencyKey, error) {
+	var record types.IdempotencyKey
+	query := "SELECT * FROM idempotency_keys WHERE user_id=$1 AND key=$2"
+	err := repository.db.Get(&record, query, userId, key)
+	return record, err
+}
+
+// Complete stores the response of the request reserved with the key.
+func (repository *Repository) Complete(record types.IdempotencyKey) error {
+	query := "UPDATE idempotency_keys SET status_code=$1, headers=$2, body=$3 WHERE user_id=$4 AND key=$5"
+	_, err := repository.db.Exec(query, record.StatusCode, record.Headers, record.Body, record.UserId, record.Key)
+	return err
+}
+
+// DeleteExpired removes the keys older than 24 hours and returns how many
+// were removed.
+func (repository *Repository) DeleteExpired() (int64, error) {
+	result, err := repository.db.Exec("DELETE FROM idempotency_keys WHERE created_at < NOW() - INTERVAL '24 hours'")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,39 @@
+package idempotency
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Worker removes the expired idempotency keys. Every API instance runs one,
+// removing the same keys twice is harmless.
+type Worker struct {
+	idempotencyRepository IdempotencyRepository
+	interval              time.Duration
+}
+
+func NewWorker(idempotencyRepository IdempotencyRepository, interval time.Duration) *Worker {
+	return &Worker{
+		idempotencyRepository: idempotencyRepository,
+		interval:              interval,
+	}
+}
+
+// Start blocks and removes the expired keys on every tick until the context
+// is done.
+func (worker *Worker) Start(ctx context.Context) {
+	ticker := time.NewTicker(worker.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := worker.idempotencyRepository.DeleteExpired(); err != nil {
+				log.Printf("Error deleting expired idempotency keys: %v", err)
+			}
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package kermesses
 
 import (
+	"database/sql"
 	"fmt"
 	"github.com/jmoiron/sqlx"
 	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/pkg/utils"
 	"strings"
 )
 
@@ -11,7 +13,7 @@ type KermessesRepository interface {
 	AddKermesse(input map[string]interface{}) error
 	GetAllKermesses(filters map[string]interface{}) ([]types.Kermesse, error)
 	GetKermesseById(id int) (types.Kermesse, error)
-	ModifyKermesse(id int, input map[string]interface{}) error
+	ModifyKermesse(id int, version int, input map[string]interface{}) error
 	CompleteKermesse(id int) error
 	IsStandLinkable(standId int) (bool, error)
 	LinkStandToKermesse(input map[string]interface{}) error
@@ -20,6 +22,8 @@ type KermessesRepository interface {
 	GetUsersForInvitation(kermesseId int) ([]types.UserBasic, error)
 	getStatistics(id int, filters map[string]interface{}) (types.KermesseStatistics, error)
 	IsAllTombolaFinished(kermesseId int) (bool, error)
+	HasActivity(id int) (bool, error)
+	DeleteKermesse(id int) error
 }
 
 type Repository struct {
@@ -46,12 +50,15 @@ func (repository *Repository) GetAllKermesses(filters map[string]interface{}) ([
 			k.user_id AS user_id,
 			k.name AS name,
 			k.description AS description,
-			k.status AS status
+			k.status AS status,
+			k.created_at AS created_at,
+			k.updated_at AS updated_at,
+			k.version AS version
 		FROM kermesses k
 		    FULL OUTER JOIN kermesses_stands ks ON ks.kermesse_id = k.id
 			FULL OUTER JOIN kermesses_users ku ON ku.kermesse_id = k.id
 			FULL OUTER JOIN stands s ON ks.stand_id = s.id
-			WHERE 1=1
+			WHERE k.deleted_at IS NULL
 		`
 
 	var conditions []string
@@ -63,7 +70,7 @@ func (repository *Repository) GetAllKermesses(filters map[string]interface{}) ([
 		conditions = append(conditions, fmt.Sprintf("k.user_id = %v", organizerId))
 	}
 	if parentId, ok := filters["parent_id"]; ok {
-		conditions = append(conditions, fmt.Sprintf("ku.user_id = %v", parentId))
+		conditions = append(conditions, fmt.Sprintf("(ku.user_id = %v OR ku.user_id IN (SELECT student_id FROM student_guardians WHERE guardian_id = %v))", parentId, parentId))
 	}
 	if standHolderId, ok := filters["stand_holder_id"]; ok {
 		conditions = append(conditions, fmt.Sprintf("ks.stand_id IS NOT NULL AND s.user_id = %v", standHolderId))
@@ -80,16 +87,16 @@ func (repository *Repository) GetAllKermesses(filters map[string]interface{}) ([
 
 func (repository *Repository) GetKermesseById(id int) (types.Kermesse, error) {
 	var kermesse types.Kermesse
-	query := "SELECT * FROM kermesses WHERE id=$1"
+	query := "SELECT * FROM kermesses WHERE id=$1 AND deleted_at IS NULL"
 	err := repository.db.Get(&kermesse, query, id)
 	return kermesse, err
 }
 
-func (repository *Repository) ModifyKermesse(id int, input map[string]interface{}) error {
-	query := "UPDATE kermesses SET name=$1, description=$2 WHERE id=$3"
-	_, err := repository.db.Exec(query, input["name"], input["description"], id)
-
-	return err
+// ModifyKermesse updates the kermesse if it is still at the version, it
+// returns sql.ErrNoRows when it was changed in the meantime.
+func (repository *Repository) ModifyKermesse(id int, version int, input map[string]interface{}) error {
+	query := "UPDATE kermesses SET name=$1, description=$2 WHERE id=$3 AND version=$4 AND deleted_at IS NULL"
+	return utils.ExecSingleRow(repository.db, sql.ErrNoRows, query, input["name"], input["description"], id, version)
 }
 
 func (repository *Repository) CompleteKermesse(id int) error {
@@ -100,7 +107,7 @@ func (repository *Repository) CompleteKermesse(id int) error {
 
 func (repository *Repository) IsStandLinkable(standId int) (bool, error) {
 	var canLink bool
-	query := `SELECT EXISTS ( SELECT 1 FROM kermesses_stands ks JOIN kermesses k ON ks.kermesse_id = k.id WHERE ks.stand_id = $1 AND k.status = 'STARTED' ) AS is_linkable`
+	query := `SELECT EXISTS ( SELECT 1 FROM kermesses_stands ks JOIN kermesses k ON ks.kermesse_id = k.id WHERE ks.stand_id = $1 AND k.status = 'STARTED' AND k.deleted_at IS NULL ) AS is_linkable`
 	err := repository.db.QueryRow(query, standId).Scan(&canLink)
 	return !canLink, err
 }
@@ -111,7 +118,8 @@ func (repository *Repository) IsAllTombolaFinished(kermesseId int) (bool, error)
         SELECT COUNT(*) = 0
         FROM tombolas
         WHERE kermesse_id = $1
-        AND status != 'FINISHED';
+        AND status != 'FINISHED'
+        AND deleted_at IS NULL;
     `
 	err := repository.db.QueryRow(query, kermesseId).Scan(&allFinished)
 	if err != nil {
@@ -128,11 +136,48 @@ func (repository *Repository) LinkStandToKermesse(input map[string]interface{})
 
 func (repository *Repository) IsCompletionAllowed(id int) (bool, error) {
 	var completionAllowed bool
-	query := "SELECT EXISTS ( SELECT 1 FROM tombolas WHERE kermesse_id = $1 AND status = 'STARTED' ) AS can_end"
+	query := "SELECT EXISTS ( SELECT 1 FROM tombolas WHERE kermesse_id = $1 AND status = 'STARTED' AND deleted_at IS NULL ) AS can_end"
 	err := repository.db.QueryRow(query, id).Scan(&completionAllowed)
 	return !completionAllowed, err
 }
 
+// HasActivity reports whether jetons were spent in the kermesse, on a stand or
+// on a tombola.
+func (repository *Repository) HasActivity(id int) (bool, error) {
+	var hasActivity bool
+	query := `
+		SELECT EXISTS (SELECT 1 FROM participations WHERE kermesse_id = $1 AND deleted_at IS NULL)
+		OR EXISTS (
+			SELECT 1 FROM tickets t JOIN tombolas tb ON tb.id = t.tombola_id
+			WHERE tb.kermesse_id = $1 AND t.deleted_at IS NULL
+		)
+	`
+	err := repository.db.Get(&hasActivity, query, id)
+	return hasActivity, err
+}
+
+// DeleteKermesse soft deletes the kermesse along with its tombolas.
+func (repository *Repository) DeleteKermesse(id int) (err error) {
+	tx, err := repository.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	_, err = tx.Exec("UPDATE kermesses SET deleted_at=NOW() WHERE id=$1 AND deleted_at IS NULL", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE tombolas SET deleted_at=NOW() WHERE kermesse_id=$1 AND deleted_at IS NULL", id)
+	return err
+}
+
 func (repository *Repository) LinkUserToKermesse(input map[string]interface{}) error {
 	query := "INSERT INTO kermesses_users (kermesse_id, user_id) VALUES ($1, $2)"
 	_, err := repository.db.Exec(query, input["kermesse_id"], input["user_id"])
@@ -152,6 +197,7 @@ func (repository *Repository) GetUsersForInvitation(kermesseId int) ([]types.Use
 		LEFT JOIN kermesses_users ku ON u.id = ku.user_id
 		WHERE u.id IS NOT NULL
 		AND u.role = 'STUDENT'
+		AND u.deleted_at IS NULL
 		AND (ku.kermesse_id IS NULL OR ku.kermesse_id != $1)
 	`
 	err := repository.db.Select(&users, query, kermesseId)
@@ -202,25 +248,25 @@ func (repository *Repository) getStatistics(id int, filters map[string]interface
 }
 
 func (repository *Repository) getStandNumber(kermesseId int, standNumber *int) error {
-	query := "SELECT COUNT(*) FROM kermesses_stands WHERE kermesse_id=$1"
+	query := "SELECT COUNT(*) FROM kermesses_stands ks JOIN stands s ON s.id = ks.stand_id WHERE ks.kermesse_id=$1 AND s.deleted_at IS NULL"
 	return repository.db.Get(standNumber, query, kermesseId)
 }
 
 func (repository *Repository) getTombolaNumber(kermesseId int, tombolaNumber *int) error {
-	query := "SELECT COUNT(*) FROM tombolas WHERE kermesse_id=$1"
+	query := "SELECT COUNT(*) FROM tombolas WHERE kermesse_id=$1 AND deleted_at IS NULL"
 	return repository.db.Get(tombolaNumber, query, kermesseId)
 }
 
 func (repository *Repository) getUserNumber(kermesseId int, filters map[string]interface{}, userNumber *int) error {
-	query := `SELECT COUNT(*) FROM kermesses_users ku JOIN users u ON ku.user_id = u.id WHERE ku.kermesse_id=$1`
+	query := `SELECT COUNT(*) FROM kermesses_users ku JOIN users u ON ku.user_id = u.id WHERE ku.kermesse_id=$1 AND u.deleted_at IS NULL`
 	if filters["parent_id"] != nil {
-		query += fmt.Sprintf(" AND u.role='%v' AND u.parent_id=%v", types.UserRoleStudent, filters["parent_id"])
+		query += fmt.Sprintf(" AND u.role='%v' AND u.id IN (SELECT student_id FROM student_guardians WHERE guardian_id=%v)", types.UserRoleStudent, filters["parent_id"])
 	}
 	return repository.db.Get(userNumber, query, kermesseId)
 }
 
 func (repository *Repository) getParticipationStatistics(kermesseId int, filters map[string]interface{}, participationNumber *int, participationBenefits *int) error {
-	query := `SELECT COUNT(*) FROM participations p JOIN stands s ON p.stand_id = s.id WHERE p.kermesse_id=$1`
+	query := `SELECT COUNT(*) FROM participations p JOIN stands s ON p.stand_id = s.id WHERE p.kermesse_id=$1 AND p.deleted_at IS NULL`
 	if filters["stand_holder_id"] != nil {
 		query += fmt.Sprintf(" AND s.user_id=%v", filters["stand_holder_id"])
 	}
@@ -229,7 +275,7 @@ func (repository *Repository) getParticipationStatistics(kermesseId int, filters
 		return err
 	}
 
-	query = `SELECT COALESCE(SUM(p.balance), 0) FROM participations p JOIN stands s ON p.stand_id = s.id WHERE p.kermesse_id=$1`
+	query = `SELECT COALESCE(SUM(p.balance), 0) FROM participations p JOIN stands s ON p.stand_id = s.id WHERE p.kermesse_id=$1 AND p.deleted_at IS NULL`
 	if filters["stand_holder_id"] != nil {
 		query += fmt.Sprintf(" AND s.user_id=%v", filters["stand_holder_id"])
 	}
@@ -237,11 +283,11 @@ func (repository *Repository) getParticipationStatistics(kermesseId int, filters
 }
 
 func (repository *Repository) getTombolaBenefits(kermesseId int, tombolaBenefits *int) error {
-	query := `SELECT COALESCE(SUM(tb.price), 0) FROM tickets t JOIN tombolas tb ON t.tombola_id = tb.id WHERE tb.kermesse_id=$1`
+	query := `SELECT COALESCE(SUM(t.price), 0) FROM tickets t JOIN tombolas tb ON t.tombola_id = tb.id WHERE tb.kermesse_id=$1 AND t.deleted_at IS NULL`
 	return repository.db.Get(tombolaBenefits, query, kermesseId)
 }
 
 func (repository *Repository) getPoints(kermesseId int, userId int, points *int) error {
-	query := "SELECT COALESCE(SUM(point), 0) FROM participations WHERE kermesse_id=$1 AND user_id=$2"
+	query := "SELECT COALESCE(SUM(point), 0) FROM participations WHERE kermesse_id=$1 AND user_id=$2 AND deleted_at IS NULL"
 	return repository.db.Get(points, query, kermesseId, userId)
 }
@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	goErrors "errors"
+	"github.com/kermesse-backend/internal/audit"
+	"github.com/kermesse-backend/internal/policy"
 	"github.com/kermesse-backend/internal/types"
 	"github.com/kermesse-backend/internal/users"
 	"github.com/kermesse-backend/pkg/errors"
@@ -14,8 +16,9 @@ type KermessesService interface {
 	GetAllKermesses(ctx context.Context) ([]types.Kermesse, error)
 	GetKermesseById(ctx context.Context, id int) (types.KermesseWithStatistics, error)
 	AddKermesse(ctx context.Context, input map[string]interface{}) error
-	UpdateKermesse(ctx context.Context, id int, input map[string]interface{}) error
+	UpdateKermesse(ctx context.Context, id int, version int, input map[string]interface{}) error
 	MarkKermesseAsComplete(ctx context.Context, id int) error
+	DeleteKermesse(ctx context.Context, id int) error
 	AssignUserToKermesse(ctx context.Context, input map[string]interface{}) error
 	AssignStandToKermesse(ctx context.Context, input map[string]interface{}) error
 	GetUsersForInvitation(kermesseId int) ([]types.UserBasic, error)
@@ -24,12 +27,16 @@ type KermessesService interface {
 type Service struct {
 	kermessesRepository KermessesRepository
 	usersRepository     users.UsersRepository
+	policyService       policy.PolicyService
+	auditService        *audit.Service
 }
 
-func NewKermessesService(kermessesRepository KermessesRepository, usersRepository users.UsersRepository) *Service {
+func NewKermessesService(kermessesRepository KermessesRepository, usersRepository users.UsersRepository, policyService policy.PolicyService, auditService *audit.Service) *Service {
 	return &Service{
 		kermessesRepository: kermessesRepository,
 		usersRepository:     usersRepository,
+		policyService:       policyService,
+		auditService:        auditService,
 	}
 }
 
@@ -137,6 +144,9 @@ func (service *Service) GetKermesseById(ctx context.Context, id int) (types.Kerm
 		ParticipationNumber:  statistics.ParticipationNumber,
 		ParticipationBenefit: statistics.ParticipationBenefit,
 		Points:               statistics.Points,
+		CreatedAt:            kermesse.CreatedAt,
+		UpdatedAt:            kermesse.UpdatedAt,
+		Version:              kermesse.Version,
 	}
 
 	return KermesseWithStatistics, nil
@@ -162,7 +172,9 @@ func (service *Service) AddKermesse(ctx context.Context, input map[string]interf
 	return nil
 }
 
-func (service *Service) UpdateKermesse(ctx context.Context, id int, input map[string]interface{}) error {
+// UpdateKermesse changes the details of the kermesse. A version other than 0
+// must be the current one, the update is refused with a conflict otherwise.
+func (service *Service) UpdateKermesse(ctx context.Context, id int, version int, input map[string]interface{}) error {
 	kermesse, err := service.kermessesRepository.GetKermesseById(id)
 	if err != nil {
 		if goErrors.Is(err, sql.ErrNoRows) {
@@ -184,27 +196,41 @@ func (service *Service) UpdateKermesse(ctx context.Context, id int, input map[st
 		}
 	}
 
-	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
-	if !ok {
-		return errors.CustomError{
-			Key: errors.Unauthorized,
-			Err: goErrors.New("unable to fetch user id"),
-		}
+	if err := service.policyService.Authorize(ctx, policy.KermesseManage, policy.Resource{OwnerId: kermesse.UserId, KermesseId: kermesse.Id}); err != nil {
+		return err
 	}
-	if kermesse.UserId != userId {
+
+	if version != 0 && version != kermesse.Version {
 		return errors.CustomError{
-			Key: errors.Forbidden,
-			Err: goErrors.New("user is not authorized to modify this kermesse"),
+			Key: errors.Conflict,
+			Err: goErrors.New("kermesse was modified since it was read"),
 		}
 	}
 
-	err = service.kermessesRepository.ModifyKermesse(id, input)
+	err = service.kermessesRepository.ModifyKermesse(id, kermesse.Version, input)
 	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return errors.CustomError{
+				Key: errors.Conflict,
+				Err: goErrors.New("kermesse was modified since it was read"),
+			}
+		}
 		return errors.CustomError{
 			Key: errors.InternalServerError,
 			Err: err,
 		}
 	}
+
+	if modified, err := service.kermessesRepository.GetKermesseById(id); err == nil {
+		service.auditService.Record(ctx, audit.Entry{
+			Action:     types.AuditActionKermesseUpdated,
+			TargetType: types.AuditTargetKermesse,
+			TargetId:   id,
+			KermesseId: id,
+			Before:     kermesse,
+			After:      modified,
+		})
+	}
 	return nil
 }
 
@@ -246,27 +272,79 @@ func (service *Service) MarkKermesseAsComplete(ctx context.Context, id int) erro
 		}
 	}
 
-	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
-	if !ok {
+	if err := service.policyService.Authorize(ctx, policy.KermesseManage, policy.Resource{OwnerId: kermesse.UserId, KermesseId: kermesse.Id}); err != nil {
+		return err
+	}
+
+	err = service.kermessesRepository.CompleteKermesse(id)
+	if err != nil {
 		return errors.CustomError{
-			Key: errors.Unauthorized,
-			Err: goErrors.New("unable to fetch user id from context"),
+			Key: errors.InternalServerError,
+			Err: err,
 		}
 	}
-	if kermesse.UserId != userId {
+
+	service.auditService.Record(ctx, audit.Entry{
+		Action:     types.AuditActionKermesseCompleted,
+		TargetType: types.AuditTargetKermesse,
+		TargetId:   id,
+		KermesseId: id,
+		Before:     map[string]interface{}{"status": kermesse.Status},
+		After:      map[string]interface{}{"status": types.KermesseStatusFinished},
+	})
+	return nil
+}
+
+// DeleteKermesse soft deletes the kermesse and its tombolas. A kermesse where
+// jetons were already spent is kept, it has to be completed instead.
+func (service *Service) DeleteKermesse(ctx context.Context, id int) error {
+	kermesse, err := service.kermessesRepository.GetKermesseById(id)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return errors.CustomError{
+				Key: errors.NotFound,
+				Err: err,
+			}
+		}
 		return errors.CustomError{
-			Key: errors.Forbidden,
-			Err: goErrors.New("user is not authorized to mark this kermesse as complete"),
+			Key: errors.InternalServerError,
+			Err: err,
 		}
 	}
 
-	err = service.kermessesRepository.CompleteKermesse(id)
+	if err := service.policyService.Authorize(ctx, policy.KermesseManage, policy.Resource{OwnerId: kermesse.UserId, KermesseId: kermesse.Id}); err != nil {
+		return err
+	}
+
+	hasActivity, err := service.kermessesRepository.HasActivity(id)
 	if err != nil {
 		return errors.CustomError{
 			Key: errors.InternalServerError,
 			Err: err,
 		}
 	}
+	if hasActivity {
+		return errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("kermesse cannot be deleted because jetons were already spent in it"),
+		}
+	}
+
+	err = service.kermessesRepository.DeleteKermesse(id)
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	service.auditService.Record(ctx, audit.Entry{
+		Action:     types.AuditActionKermesseDeleted,
+		TargetType: types.AuditTargetKermesse,
+		TargetId:   id,
+		KermesseId: id,
+		Before:     kermesse,
+	})
 	return nil
 }
 
@@ -292,18 +370,8 @@ func (service *Service) AssignUserToKermesse(ctx context.Context, input map[stri
 		}
 	}
 
-	organizerId, ok := ctx.Value(types.UserIDSessionKey).(int)
-	if !ok {
-		return errors.CustomError{
-			Key: errors.Unauthorized,
-			Err: goErrors.New("user id not found"),
-		}
-	}
-	if kermesse.UserId != organizerId {
-		return errors.CustomError{
-			Key: errors.Forbidden,
-			Err: goErrors.New("forbidden"),
-		}
+	if err := service.policyService.Authorize(ctx, policy.KermesseManage, policy.Resource{OwnerId: kermesse.UserId, KermesseId: kermesse.Id}); err != nil {
+		return err
 	}
 
 	studentId, err := utils.ConvertToInt(input, "user_id")
@@ -337,9 +405,26 @@ func (service *Service) AssignUserToKermesse(ctx context.Context, input map[stri
 		}
 	}
 
-	if student.ParentId != nil {
-		input["user_id"] = student.ParentId
-		err = service.kermessesRepository.LinkUserToKermesse(input)
+	service.auditService.Record(ctx, audit.Entry{
+		Action:     types.AuditActionKermesseUserAdded,
+		TargetType: types.AuditTargetKermesse,
+		TargetId:   kermesse.Id,
+		KermesseId: kermesse.Id,
+		Details:    map[string]interface{}{"user_id": student.Id},
+	})
+
+	// guardians follow the kermesses of their students, one of them may
+	// already be linked through another student
+	guardianIds, err := service.usersRepository.GetGuardianIds(student.Id)
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	for _, guardianId := range guardianIds {
+		input["user_id"] = guardianId
+		service.kermessesRepository.LinkUserToKermesse(input)
 	}
 
 	return nil
@@ -388,18 +473,8 @@ func (s *Service) AssignStandToKermesse(ctx context.Context, input map[string]in
 		}
 	}
 
-	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
-	if !ok {
-		return errors.CustomError{
-			Key: errors.Unauthorized,
-			Err: goErrors.New("user id not found"),
-		}
-	}
-	if kermesse.UserId != userId {
-		return errors.CustomError{
-			Key: errors.Forbidden,
-			Err: goErrors.New("forbidden"),
-		}
+	if err := s.policyService.Authorize(ctx, policy.KermesseManage, policy.Resource{OwnerId: kermesse.UserId, KermesseId: kermesse.Id}); err != nil {
+		return err
 	}
 
 	err = s.kermessesRepository.LinkStandToKermesse(input)
@@ -410,6 +485,14 @@ func (s *Service) AssignStandToKermesse(ctx context.Context, input map[string]in
 		}
 	}
 
+	s.auditService.Record(ctx, audit.Entry{
+		Action:     types.AuditActionKermesseStandAdded,
+		TargetType: types.AuditTargetKermesse,
+		TargetId:   kermesse.Id,
+		KermesseId: kermesse.Id,
+		Details:    map[string]interface{}{"stand_id": standId},
+	})
+
 	return nil
 }
 
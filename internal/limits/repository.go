@@ -0,0 +1,111 @@
+package limits
+
+import (
+	"database/sql"
+	goErrors "errors"
+	"github.com/jmoiron/sqlx"
+	"github.com/kermesse-backend/internal/types"
+)
+
+type LimitsRepository interface {
+	GetLimitsByStudentId(studentId int) (types.SpendingLimit, error)
+	SetLimits(studentId int, input map[string]interface{}) (types.SpendingLimit, error)
+	GetSpending(studentId int, kermesseId int) (types.Spending, error)
+}
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewLimitsRepository(db *sqlx.DB) *Repository {
+	return &Repository{
+		db: db,
+	}
+}
+
+func (repository *Repository) GetLimitsByStudentId(studentId int) (types.SpendingLimit, error) {
+	return getLimitsByStudentId(repository.db, studentId)
+}
+
+func (repository *Repository) SetLimits(studentId int, input map[string]interface{}) (types.SpendingLimit, error) {
+	var limit types.SpendingLimit
+	query := `
+		INSERT INTO spending_limits (student_id, daily_limit, kermesse_limit, purchase_limit, food_limit, game_limit, tombola_limit, approval_threshold)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (student_id) DO UPDATE SET
+			daily_limit = EXCLUDED.daily_limit,
+			kermesse_limit = EXCLUDED.kermesse_limit,
+			purchase_limit = EXCLUDED.purchase_limit,
+			food_limit = EXCLUDED.food_limit,
+			game_limit = EXCLUDED.game_limit,
+			tombola_limit = EXCLUDED.tombola_limit,
+			approval_threshold = EXCLUDED.approval_threshold,
+			updated_at = NOW()
+		RETURNING *
+	`
+	err := repository.db.Get(&limit, query, studentId, input["daily_limit"], input["kermesse_limit"], input["purchase_limit"], input["food_limit"], input["game_limit"], input["tombola_limit"], input["approval_threshold"])
+	return limit, err
+}
+
+// GetSpending sums what the student paid today, whatever the kermesse, and in
+// the kermesse by category. Tickets bought by a parent on behalf of the
+// student are not spent by the student.
+func (repository *Repository) GetSpending(studentId int, kermesseId int) (types.Spending, error) {
+	return getSpending(repository.db, studentId, kermesseId)
+}
+
+// CheckSpendingTx checks the purchase against the limits of the student like
+// CheckSpending, within the transaction that debits the student. The row of
+// the student is locked first, so that another purchase of the student waits
+// for the transaction to end before reading what they spent.
+func CheckSpendingTx(tx *sqlx.Tx, studentId int, kermesseId int, category string, amount int) error {
+	_, err := tx.Exec("SELECT id FROM users WHERE id=$1 FOR UPDATE", studentId)
+	if err != nil {
+		return err
+	}
+
+	limit, err := getLimitsByStudentId(tx, studentId)
+	if goErrors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	spending, err := getSpending(tx, studentId, kermesseId)
+	if err != nil {
+		return err
+	}
+	return checkLimits(limit, spending, category, amount)
+}
+
+func getLimitsByStudentId(queryer sqlx.Queryer, studentId int) (types.SpendingLimit, error) {
+	var limit types.SpendingLimit
+	query := "SELECT * FROM spending_limits WHERE student_id=$1"
+	err := sqlx.Get(queryer, &limit, query, studentId)
+	return limit, err
+}
+
+func getSpending(queryer sqlx.Queryer, studentId int, kermesseId int) (types.Spending, error) {
+	var spending types.Spending
+	query := `
+		WITH spending AS (
+			SELECT p.balance AS amount, p.kermesse_id, p.category::TEXT AS category, p.created_at
+			FROM participations p
+			WHERE p.user_id = $1
+			UNION ALL
+			SELECT t.price AS amount, tb.kermesse_id, 'TOMBOLA' AS category, t.created_at
+			FROM tickets t
+			JOIN tombolas tb ON tb.id = t.tombola_id
+			WHERE t.buyer_id = $1
+		)
+		SELECT
+			COALESCE(SUM(amount) FILTER (WHERE created_at >= DATE_TRUNC('day', NOW())), 0) AS daily,
+			COALESCE(SUM(amount) FILTER (WHERE kermesse_id = $2), 0) AS kermesse,
+			COALESCE(SUM(amount) FILTER (WHERE kermesse_id = $2 AND category = 'FOOD'), 0) AS food,
+			COALESCE(SUM(amount) FILTER (WHERE kermesse_id = $2 AND category = 'GAME'), 0) AS game,
+			COALESCE(SUM(amount) FILTER (WHERE kermesse_id = $2 AND category = 'TOMBOLA'), 0) AS tombola
+		FROM spending
+	`
+	err := sqlx.Get(queryer, &spending, query, studentId, kermesseId)
+	return spending, err
+}
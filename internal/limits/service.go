@@ -0,0 +1,269 @@
+package limits
+
+import (
+	"context"
+	"database/sql"
+	goErrors "errors"
+	"fmt"
+	"github.com/kermesse-backend/internal/policy"
+	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/internal/users"
+	"github.com/kermesse-backend/pkg/errors"
+	"strconv"
+)
+
+type LimitsService interface {
+	GetLimits(ctx context.Context, studentId int) (types.SpendingLimit, error)
+	SetLimits(ctx context.Context, studentId int, input map[string]interface{}) (types.SpendingLimit, error)
+	GetAllowance(ctx context.Context, studentId int, params map[string]interface{}) (types.Allowance, error)
+}
+
+// limitFields are the limits a parent can set, all optional.
+var limitFields = []string{"daily_limit", "kermesse_limit", "purchase_limit", "food_limit", "game_limit", "tombola_limit", "approval_threshold"}
+
+type Service struct {
+	limitsRepository LimitsRepository
+	usersRepository  users.UsersRepository
+	policyService    policy.PolicyService
+}
+
+func NewLimitsService(limitsRepository LimitsRepository, usersRepository users.UsersRepository, policyService policy.PolicyService) *Service {
+	return &Service{
+		limitsRepository: limitsRepository,
+		usersRepository:  usersRepository,
+		policyService:    policyService,
+	}
+}
+
+func (service *Service) GetLimits(ctx context.Context, studentId int) (types.SpendingLimit, error) {
+	if err := service.checkAccess(ctx, studentId, policy.StudentView); err != nil {
+		return types.SpendingLimit{}, err
+	}
+
+	limit, err := service.getLimits(studentId)
+	if err != nil {
+		return types.SpendingLimit{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return limit, nil
+}
+
+// SetLimits replaces all the limits of the student, a limit left out or null
+// is removed.
+func (service *Service) SetLimits(ctx context.Context, studentId int, input map[string]interface{}) (types.SpendingLimit, error) {
+	if err := service.checkAccess(ctx, studentId, policy.StudentManage); err != nil {
+		return types.SpendingLimit{}, err
+	}
+
+	values := make(map[string]interface{})
+	for _, field := range limitFields {
+		value, exists := input[field]
+		if !exists || value == nil {
+			values[field] = nil
+			continue
+		}
+		floatValue, ok := value.(float64)
+		if !ok || floatValue < 0 {
+			return types.SpendingLimit{}, errors.CustomError{
+				Key: errors.BadRequest,
+				Err: fmt.Errorf("%s must be a positive number or null", field),
+			}
+		}
+		values[field] = int(floatValue)
+	}
+
+	limit, err := service.limitsRepository.SetLimits(studentId, values)
+	if err != nil {
+		return types.SpendingLimit{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return limit, nil
+}
+
+func (service *Service) GetAllowance(ctx context.Context, studentId int, params map[string]interface{}) (types.Allowance, error) {
+	if err := service.checkAccess(ctx, studentId, policy.StudentView); err != nil {
+		return types.Allowance{}, err
+	}
+
+	var kermesseId *int
+	if param, exists := params["kermesse_id"]; exists {
+		id, err := strconv.Atoi(param.(string))
+		if err != nil {
+			return types.Allowance{}, errors.CustomError{
+				Key: errors.BadRequest,
+				Err: goErrors.New("kermesse_id is not a valid number"),
+			}
+		}
+		kermesseId = &id
+	}
+
+	limit, err := service.getLimits(studentId)
+	if err != nil {
+		return types.Allowance{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	spending, err := service.limitsRepository.GetSpending(studentId, valueOrZero(kermesseId))
+	if err != nil {
+		return types.Allowance{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	allowance := types.Allowance{
+		StudentId:     studentId,
+		KermesseId:    kermesseId,
+		PurchaseLimit: limit.PurchaseLimit,
+		Daily:         allowanceLine(limit.DailyLimit, spending.Daily),
+	}
+	if kermesseId != nil {
+		kermesse := allowanceLine(limit.KermesseLimit, spending.Kermesse)
+		allowance.Kermesse = &kermesse
+		allowance.Categories = map[string]types.AllowanceLine{
+			types.ParticipationTypeFood:   allowanceLine(limit.FoodLimit, spending.Food),
+			types.ParticipationTypeGame:   allowanceLine(limit.GameLimit, spending.Game),
+			types.SpendingCategoryTombola: allowanceLine(limit.TombolaLimit, spending.Tombola),
+		}
+	}
+	return allowance, nil
+}
+
+// CheckSpending returns an error naming the first limit the purchase would
+// exceed. Category is a stand category or SpendingCategoryTombola.
+func (service *Service) CheckSpending(studentId int, kermesseId int, category string, amount int) error {
+	limit, err := service.getLimits(studentId)
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	spending, err := service.limitsRepository.GetSpending(studentId, kermesseId)
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return checkLimits(limit, spending, category, amount)
+}
+
+// checkLimits returns an error naming the first limit the purchase would
+// exceed given what the student already spent.
+func checkLimits(limit types.SpendingLimit, spending types.Spending, category string, amount int) error {
+	if limit.PurchaseLimit != nil && amount > *limit.PurchaseLimit {
+		return errors.CustomError{
+			Key: errors.PurchaseLimitExceeded,
+			Err: fmt.Errorf("purchase of %d exceeds the limit of %d per purchase", amount, *limit.PurchaseLimit),
+		}
+	}
+
+	if limit.DailyLimit != nil && spending.Daily+amount > *limit.DailyLimit {
+		return errors.CustomError{
+			Key: errors.DailyLimitExceeded,
+			Err: fmt.Errorf("daily limit of %d reached, %d left", *limit.DailyLimit, remaining(*limit.DailyLimit, spending.Daily)),
+		}
+	}
+	if limit.KermesseLimit != nil && spending.Kermesse+amount > *limit.KermesseLimit {
+		return errors.CustomError{
+			Key: errors.KermesseLimitExceeded,
+			Err: fmt.Errorf("kermesse limit of %d reached, %d left", *limit.KermesseLimit, remaining(*limit.KermesseLimit, spending.Kermesse)),
+		}
+	}
+
+	categoryLimit, categorySpent := limit.FoodLimit, spending.Food
+	switch category {
+	case types.ParticipationTypeGame:
+		categoryLimit, categorySpent = limit.GameLimit, spending.Game
+	case types.SpendingCategoryTombola:
+		categoryLimit, categorySpent = limit.TombolaLimit, spending.Tombola
+	}
+	if categoryLimit != nil && categorySpent+amount > *categoryLimit {
+		return errors.CustomError{
+			Key: errors.CategoryLimitExceeded,
+			Err: fmt.Errorf("%s limit of %d reached, %d left", category, *categoryLimit, remaining(*categoryLimit, categorySpent)),
+		}
+	}
+	return nil
+}
+
+// RequiresApproval reports whether the parent of the student must approve a
+// purchase of this amount before it is charged.
+func (service *Service) RequiresApproval(studentId int, amount int) (bool, error) {
+	limit, err := service.getLimits(studentId)
+	if err != nil {
+		return false, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return limit.ApprovalThreshold != nil && amount > *limit.ApprovalThreshold, nil
+}
+
+// getLimits returns the limits of the student, without any limit when the
+// parent never set them.
+func (service *Service) getLimits(studentId int) (types.SpendingLimit, error) {
+	limit, err := service.limitsRepository.GetLimitsByStudentId(studentId)
+	if goErrors.Is(err, sql.ErrNoRows) {
+		return types.SpendingLimit{StudentId: studentId}, nil
+	}
+	return limit, err
+}
+
+// checkAccess looks up the student and authorizes the action on them.
+func (service *Service) checkAccess(ctx context.Context, studentId int, action policy.Action) error {
+	student, err := service.usersRepository.GetUserById(studentId)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return errors.CustomError{
+				Key: errors.NotFound,
+				Err: err,
+			}
+		}
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	if student.Role != types.UserRoleStudent {
+		return errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("user is not a student"),
+		}
+	}
+
+	return service.policyService.Authorize(ctx, action, policy.Resource{OwnerId: student.Id})
+}
+
+func allowanceLine(limit *int, spent int) types.AllowanceLine {
+	line := types.AllowanceLine{
+		Limit: limit,
+		Spent: spent,
+	}
+	if limit != nil {
+		left := remaining(*limit, spent)
+		line.Remaining = &left
+	}
+	return line
+}
+
+func remaining(limit int, spent int) int {
+	if spent >= limit {
+		return 0
+	}
+	return limit - spent
+}
+
+func valueOrZero(value *int) int {
+	if value == nil {
+		return 0
+	}
+	return *value
+}
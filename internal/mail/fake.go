@@ -0,0 +1,19 @@
+package mail
+
+import (
+	"context"
+	"log"
+)
+
+// FakeSender logs the emails instead of sending them, it is used for local
+// runs.
+type FakeSender struct{}
+
+func NewFakeSender() *FakeSender {
+	return &FakeSender{}
+}
+
+func (sender *FakeSender) Send(ctx context.Context, message Message) error {
+	log.Printf("Mail to %s: %s\n%s", message.To, message.Subject, message.Body)
+	return nil
+}
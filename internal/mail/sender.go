@@ -0,0 +1,14 @@
+package mail
+
+import "context"
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers a plain text email to a single recipient.
+type Sender interface {
+	Send(ctx context.Context, message Message) error
+}
@@ -0,0 +1,78 @@
+package mail
+
+import (
+	"context"
+	goErrors "errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// SMTPSender sends the emails through an SMTP server. The server must offer
+// STARTTLS when credentials are given, net/smtp refuses to send them in clear
+// text to another host.
+type SMTPSender struct {
+	address string
+	auth    smtp.Auth
+	from    *mail.Address
+}
+
+func NewSMTPSender(host string, port string, username string, password string, from string) (*SMTPSender, error) {
+	if host == "" {
+		return nil, goErrors.New("SMTP host is required")
+	}
+	fromAddress, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender address: %w", err)
+	}
+	if port == "" {
+		port = "587"
+	}
+
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &SMTPSender{
+		address: net.JoinHostPort(host, port),
+		auth:    auth,
+		from:    fromAddress,
+	}, nil
+}
+
+func (sender *SMTPSender) Send(ctx context.Context, message Message) error {
+	to, err := mail.ParseAddress(message.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address: %w", err)
+	}
+
+	var content strings.Builder
+	fmt.Fprintf(&content, "From: %s\r\n", sender.from)
+	fmt.Fprintf(&content, "To: %s\r\n", to)
+	fmt.Fprintf(&content, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	fmt.Fprintf(&content, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	content.WriteString("MIME-Version: 1.0\r\n")
+	content.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	content.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	content.WriteString("\r\n")
+	content.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+
+	// smtp.SendMail takes no context, it is run aside so that the caller
+	// does not wait past its deadline.
+	result := make(chan error, 1)
+	go func() {
+		result <- smtp.SendMail(sender.address, sender.auth, sender.from.Address, []string{to.Address}, []byte(content.String()))
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,55 @@
+package notifications
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Broker carries the published messages to the hubs of every running
+// instance, each hub then delivers them to its own connections.
+type Broker interface {
+	Publish(message BrokerMessage) error
+	Listen(handler func(message BrokerMessage))
+	Close() error
+}
+
+// BrokerMessage is a message to deliver to the connections subscribed to one
+// of Topics, except those subscribed to one of Skip, which already received
+// their own copy of it.
+type BrokerMessage struct {
+	Topics []string        `json:"topics"`
+	Skip   []string        `json:"skip,omitempty"`
+	Data   json.RawMessage `json:"data"`
+}
+
+// MemoryBroker delivers the messages to the hub of the current process only,
+// it fits single-instance runs.
+type MemoryBroker struct {
+	mutex    sync.RWMutex
+	handlers []func(message BrokerMessage)
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{}
+}
+
+func (broker *MemoryBroker) Publish(message BrokerMessage) error {
+	broker.mutex.RLock()
+	defer broker.mutex.RUnlock()
+
+	for _, handler := range broker.handlers {
+		handler(message)
+	}
+	return nil
+}
+
+func (broker *MemoryBroker) Listen(handler func(message BrokerMessage)) {
+	broker.mutex.Lock()
+	defer broker.mutex.Unlock()
+
+	broker.handlers = append(broker.handlers, handler)
+}
+
+func (broker *MemoryBroker) Close() error {
+	return nil
+}
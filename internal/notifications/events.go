@@ -0,0 +1,250 @@
+package notifications
+
+import (
+	"encoding/json"
+	"github.com/kermesse-backend/internal/types"
+	"log"
+	"time"
+)
+
+// EventVersion is bumped whenever a breaking change is made to the envelope or
+// to one of the payloads. The schema is documented in docs/events.md.
+const EventVersion = 1
+
+const (
+	EventTicketSold           string = "ticket.sold"
+	EventParticipationCreated string = "participation.created"
+	EventGameScored           string = "game.scored"
+	EventStockLow             string = "stock.low"
+	EventTombolaDrawn         string = "tombola.drawn"
+	EventPrizeWon             string = "prize.won"
+	EventPrizeDelivered       string = "prize.delivered"
+	EventBalanceCredited      string = "balance.credited"
+	EventBalanceDebited       string = "balance.debited"
+	EventBalanceAdjusted      string = "balance.adjusted"
+	EventApprovalRequested    string = "purchase.approval_requested"
+	EventPurchaseResolved     string = "purchase.resolved"
+	EventGuardianInvited      string = "guardian.invited"
+)
+
+// Event is the envelope of every message sent to the clients. KermesseId is
+// omitted for events that do not happen inside a kermesse. Id is the id of the
+// inbox notification and is only set on events addressed to a user.
+type Event struct {
+	Id         int         `json:"id,omitempty"`
+	Version    int         `json:"version"`
+	Type       string      `json:"type"`
+	OccurredAt time.Time   `json:"occurred_at"`
+	KermesseId int         `json:"kermesse_id,omitempty"`
+	Payload    interface{} `json:"payload"`
+}
+
+func NewEvent(eventType string, kermesseId int, payload interface{}) Event {
+	return Event{
+		Version:    EventVersion,
+		Type:       eventType,
+		OccurredAt: time.Now().UTC(),
+		KermesseId: kermesseId,
+		Payload:    payload,
+	}
+}
+
+type TicketSoldPayload struct {
+	TombolaId   int    `json:"tombola_id"`
+	TombolaName string `json:"tombola_name"`
+	StudentId   int    `json:"student_id"`
+	StudentName string `json:"student_name"`
+	BuyerId     int    `json:"buyer_id"`
+	BuyerName   string `json:"buyer_name"`
+	Quantity    int    `json:"quantity"`
+	TotalPrice  int    `json:"total_price"`
+	Numbers     []int  `json:"numbers"`
+}
+
+type ParticipationCreatedPayload struct {
+	StandId     int    `json:"stand_id"`
+	StandName   string `json:"stand_name"`
+	Category    string `json:"category"`
+	StudentId   int    `json:"student_id"`
+	StudentName string `json:"student_name"`
+	Quantity    int    `json:"quantity"`
+	TotalPrice  int    `json:"total_price"`
+}
+
+type GameScoredPayload struct {
+	ParticipationId int    `json:"participation_id"`
+	StandId         int    `json:"stand_id"`
+	StandName       string `json:"stand_name"`
+	StudentId       int    `json:"student_id"`
+	StudentName     string `json:"student_name"`
+	Point           int    `json:"point"`
+}
+
+type StockLowPayload struct {
+	StandId   int    `json:"stand_id"`
+	StandName string `json:"stand_name"`
+	Stock     int    `json:"stock"`
+	Threshold int    `json:"threshold"`
+}
+
+type TombolaDrawnPayload struct {
+	TombolaId   int    `json:"tombola_id"`
+	TombolaName string `json:"tombola_name"`
+	WinnerCount int    `json:"winner_count"`
+}
+
+type PrizeWonPayload struct {
+	TicketId       int        `json:"ticket_id"`
+	TicketNumber   int        `json:"ticket_number"`
+	TombolaId      int        `json:"tombola_id"`
+	TombolaName    string     `json:"tombola_name"`
+	PrizeName      string     `json:"prize_name"`
+	ClaimExpiresAt *time.Time `json:"claim_expires_at"`
+}
+
+type PrizeDeliveredPayload struct {
+	TicketId    int    `json:"ticket_id"`
+	TombolaId   int    `json:"tombola_id"`
+	TombolaName string `json:"tombola_name"`
+	PrizeName   string `json:"prize_name"`
+}
+
+const (
+	BalanceSourceStripe  string = "STRIPE"
+	BalanceSourceParent  string = "PARENT"
+	BalanceSourceSibling string = "SIBLING"
+)
+
+// BalanceCreditedPayload carries the amount added to the balance, FromUserId
+// is set when the credit comes from a parent.
+type BalanceCreditedPayload struct {
+	Amount     int    `json:"amount"`
+	Source     string `json:"source"`
+	FromUserId *int   `json:"from_user_id"`
+}
+
+// PurchaseRequestPayload describes a purchase waiting for the approval of the
+// parent, or how it was resolved. Reason is only set on a failed purchase.
+type PurchaseRequestPayload struct {
+	RequestId   int       `json:"request_id"`
+	Kind        string    `json:"kind"`
+	StudentId   int       `json:"student_id"`
+	StudentName string    `json:"student_name"`
+	ItemName    string    `json:"item_name"`
+	Quantity    int       `json:"quantity"`
+	Amount      int       `json:"amount"`
+	Status      string    `json:"status"`
+	Reason      *string   `json:"reason"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// BalanceDebitedPayload carries the amount the parent took from the balance,
+// ToUserId is the parent or the sibling who received it.
+type BalanceDebitedPayload struct {
+	Amount   int `json:"amount"`
+	ToUserId int `json:"to_user_id"`
+}
+
+// BalanceAdjustedPayload carries the amount an administrator added to the
+// balance, negative when it was taken, and the balance after the adjustment.
+type BalanceAdjustedPayload struct {
+	Amount  int    `json:"amount"`
+	Balance int    `json:"balance"`
+	Reason  string `json:"reason"`
+}
+
+type GuardianInvitedPayload struct {
+	InvitationId  int    `json:"invitation_id"`
+	StudentId     int    `json:"student_id"`
+	StudentName   string `json:"student_name"`
+	InvitedBy     int    `json:"invited_by"`
+	InvitedByName string `json:"invited_by_name"`
+}
+
+// EventFromNotification rebuilds the event stored in an inbox notification.
+func EventFromNotification(notification types.Notification) Event {
+	event := Event{
+		Id:         notification.Id,
+		Version:    notification.Version,
+		Type:       notification.Type,
+		OccurredAt: notification.CreatedAt.UTC(),
+		Payload:    notification.Payload,
+	}
+	if notification.KermesseId != nil {
+		event.KermesseId = *notification.KermesseId
+	}
+	return event
+}
+
+func encodePayload(event Event) ([]byte, error) {
+	return json.Marshal(event.Payload)
+}
+
+// PublishEvent encodes the event and publishes it on the topics.
+func (hub *Hub) PublishEvent(event Event, topics ...string) {
+	message, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Unable to encode %s event: %v", event.Type, err)
+		return
+	}
+	hub.Publish(message, topics...)
+}
+
+// Notify stores the event in the inbox of each user and sends it to their
+// connections, then publishes it on the topics to the connections that did not
+// receive it yet. An inbox that cannot be written to is logged and the event is
+// still sent.
+func (hub *Hub) Notify(userIds []int, event Event, topics ...string) {
+	var userTopics []string
+	for _, userId := range userIds {
+		userEvent := event
+		notification, err := hub.repository.AddNotification(userId, event)
+		if err != nil {
+			log.Printf("Unable to store %s event for user %d: %v", event.Type, userId, err)
+		} else {
+			userEvent.Id = notification.Id
+		}
+		message, err := json.Marshal(userEvent)
+		if err != nil {
+			log.Printf("Unable to encode %s event: %v", event.Type, err)
+			return
+		}
+		userTopics = append(userTopics, UserTopic(userId))
+		hub.publish(BrokerMessage{Topics: []string{UserTopic(userId)}, Data: message})
+	}
+
+	if len(topics) == 0 {
+		return
+	}
+	message, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Unable to encode %s event: %v", event.Type, err)
+		return
+	}
+	hub.publish(BrokerMessage{Topics: topics, Skip: userTopics, Data: message})
+}
+
+// NotifyUser stores the event in the inbox of the user and sends it to every
+// connection of the user.
+func (hub *Hub) NotifyUser(userId int, event Event) {
+	hub.Notify([]int{userId}, event)
+}
+
+// Replay sends to the client the events of its user's inbox that are more
+// recent than lastEventId, the id of the last event it received before
+// reconnecting. Events published meanwhile may be received twice, clients
+// should ignore an id they already know.
+func (hub *Hub) Replay(client *Client, lastEventId int) {
+	notifications, err := hub.repository.GetNotificationsSince(client.UserId, lastEventId)
+	if err != nil {
+		log.Printf("Unable to replay events of user %d: %v", client.UserId, err)
+		return
+	}
+	for _, notification := range notifications {
+		message, err := json.Marshal(EventFromNotification(notification))
+		if err != nil {
+			continue
+		}
+		hub.Send(client, message)
+	}
+}
@@ -0,0 +1,288 @@
+package notifications
+
+import (
+	"fmt"
+	"github.com/gorilla/websocket"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// sendQueueSize is the number of messages buffered for a connection, a
+	// client that lets its queue fill up is disconnected and will catch up
+	// with the replay of its inbox when it reconnects.
+	sendQueueSize = 32
+	// writeWait is the time allowed to write a message to the peer.
+	writeWait = 10 * time.Second
+	// pongWait is the time allowed to read the next pong from the peer, pings
+	// are sent more often than that.
+	pongWait   = 60 * time.Second
+	pingPeriod = pongWait * 9 / 10
+	// maxMessageSize is the largest message accepted from the peer.
+	maxMessageSize = 4096
+)
+
+func UserTopic(userId int) string {
+	return fmt.Sprintf("user:%d", userId)
+}
+
+func KermesseTopic(kermesseId int) string {
+	return fmt.Sprintf("kermesse:%d", kermesseId)
+}
+
+func StandTopic(standId int) string {
+	return fmt.Sprintf("stand:%d", standId)
+}
+
+func TombolaTopic(tombolaId int) string {
+	return fmt.Sprintf("tombola:%d", tombolaId)
+}
+
+// Hub keeps track of the open connections of this instance and of the topics
+// they subscribed to. A user may have any number of connections, one per
+// device, and every connection is subscribed to its own user topic. Published
+// messages go through the broker, so that the hub of every instance delivers
+// them to its own connections.
+type Hub struct {
+	mutex      sync.RWMutex
+	clients    map[*Client]bool
+	topics     map[string]map[*Client]bool
+	repository NotificationsRepository
+	broker     Broker
+}
+
+// Client is a single connection of a user. Messages are queued on send and
+// written by a dedicated goroutine, the only one writing on the connection.
+// Stream clients have no WebSocket connection, their owner reads the queue
+// through Messages instead.
+type Client struct {
+	UserId    int
+	conn      *websocket.Conn
+	send      chan []byte
+	topics    map[string]bool
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewHub(repository NotificationsRepository, broker Broker) *Hub {
+	hub := &Hub{
+		clients:    make(map[*Client]bool),
+		topics:     make(map[string]map[*Client]bool),
+		repository: repository,
+		broker:     broker,
+	}
+	broker.Listen(hub.deliver)
+	return hub
+}
+
+// Register adds the WebSocket connection of a user to the hub and starts its
+// writer. The connection is closed when no pong is read within pongWait, the
+// caller must keep reading from it for the pongs to be processed.
+func (hub *Hub) Register(userId int, conn *websocket.Conn) *Client {
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	client := hub.register(userId, conn)
+	go client.writePump()
+	return client
+}
+
+// RegisterStream adds a connection that is not a WebSocket, such as a
+// Server-Sent Events stream, to the hub.
+func (hub *Hub) RegisterStream(userId int) *Client {
+	return hub.register(userId, nil)
+}
+
+func (hub *Hub) register(userId int, conn *websocket.Conn) *Client {
+	client := &Client{
+		UserId: userId,
+		conn:   conn,
+		send:   make(chan []byte, sendQueueSize),
+		topics: make(map[string]bool),
+		done:   make(chan struct{}),
+	}
+
+	hub.mutex.Lock()
+	hub.clients[client] = true
+	hub.mutex.Unlock()
+
+	hub.Subscribe(client, UserTopic(userId))
+	return client
+}
+
+// Messages returns the queue of a stream client, it is closed once the client
+// is unregistered.
+func (client *Client) Messages() <-chan []byte {
+	return client.send
+}
+
+// Unregister removes the client from the hub and stops its writer. It is safe
+// to call more than once.
+func (hub *Hub) Unregister(client *Client) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	if !hub.clients[client] {
+		return
+	}
+	delete(hub.clients, client)
+	for topic := range client.topics {
+		delete(hub.topics[topic], client)
+		if len(hub.topics[topic]) == 0 {
+			delete(hub.topics, topic)
+		}
+	}
+	close(client.send)
+}
+
+func (hub *Hub) Subscribe(client *Client, topic string) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	if !hub.clients[client] {
+		return
+	}
+	if hub.topics[topic] == nil {
+		hub.topics[topic] = make(map[*Client]bool)
+	}
+	hub.topics[topic][client] = true
+	client.topics[topic] = true
+}
+
+func (hub *Hub) Unsubscribe(client *Client, topic string) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	delete(client.topics, topic)
+	delete(hub.topics[topic], client)
+	if len(hub.topics[topic]) == 0 {
+		delete(hub.topics, topic)
+	}
+}
+
+// Publish sends the JSON message to every client, on any instance, subscribed
+// to at least one of the topics.
+func (hub *Hub) Publish(message []byte, topics ...string) {
+	hub.publish(BrokerMessage{Topics: topics, Data: message})
+}
+
+func (hub *Hub) publish(message BrokerMessage) {
+	if err := hub.broker.Publish(message); err != nil {
+		log.Printf("Unable to publish on %v: %v", message.Topics, err)
+	}
+}
+
+// deliver queues a message received from the broker for the local clients. A
+// client subscribed to several of its topics gets the message once.
+func (hub *Hub) deliver(message BrokerMessage) {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+
+	skipped := make(map[*Client]bool)
+	for _, topic := range message.Skip {
+		for client := range hub.topics[topic] {
+			skipped[client] = true
+		}
+	}
+	for _, topic := range message.Topics {
+		for client := range hub.topics[topic] {
+			if skipped[client] {
+				continue
+			}
+			skipped[client] = true
+			hub.enqueue(client, message.Data)
+		}
+	}
+}
+
+// Send queues a message for a single client, typically a reply to one of its
+// own requests.
+func (hub *Hub) Send(client *Client, message []byte) {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+
+	if hub.clients[client] {
+		hub.enqueue(client, message)
+	}
+}
+
+// Shutdown closes every client with a going away close frame, so that they
+// reconnect to another instance.
+func (hub *Hub) Shutdown() {
+	hub.mutex.RLock()
+	defer hub.mutex.RUnlock()
+
+	var wg sync.WaitGroup
+	for client := range hub.clients {
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			client.Close(websocket.CloseGoingAway, "server shutting down")
+		}(client)
+	}
+	wg.Wait()
+}
+
+// enqueue must be called with the hub lock held, so that the send channel
+// cannot be closed concurrently.
+func (hub *Hub) enqueue(client *Client, message []byte) {
+	select {
+	case client.send <- message:
+	default:
+		log.Printf("Send queue of user %d is full, disconnecting", client.UserId)
+		go client.Close(websocket.CloseTryAgainLater, "too slow")
+	}
+}
+
+// Close sends a close frame to a WebSocket client and closes its connection,
+// or ends a stream client. Its owner then sees the connection fail, or Done
+// closed, and unregisters it. It is safe to call concurrently and more than
+// once.
+func (client *Client) Close(code int, reason string) {
+	client.closeOnce.Do(func() {
+		close(client.done)
+		if client.conn != nil {
+			message := websocket.FormatCloseMessage(code, reason)
+			client.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(writeWait))
+			client.conn.Close()
+		}
+	})
+}
+
+// Done is closed once the client is closed by the hub.
+func (client *Client) Done() <-chan struct{} {
+	return client.done
+}
+
+// writePump is the only writer of the connection, besides the control frames
+// of Close. It sends the queued messages and a ping every pingPeriod.
+func (client *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		client.conn.Close()
+	}()
+
+	for {
+		select {
+		case message, ok := <-client.send:
+			if !ok {
+				return
+			}
+			client.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := client.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				log.Printf("Write error for user %d: %v", client.UserId, err)
+				return
+			}
+		case <-ticker.C:
+			client.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := client.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
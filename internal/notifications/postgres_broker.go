@@ -0,0 +1,120 @@
+package notifications
+
+import (
+	"encoding/json"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"log"
+	"strconv"
+	"time"
+)
+
+// BrokerChannel is the Postgres channel the instances notify and listen on.
+const BrokerChannel = "kermesse_notifications"
+
+// listenerPingInterval is the idle time after which the listener checks that
+// its connection is still alive.
+const listenerPingInterval = 90 * time.Second
+
+// messageRetention is how long a message stays in broker_messages for the
+// instances to load it, they are purged every purgeInterval.
+const (
+	messageRetention = 5 * time.Minute
+	purgeInterval    = time.Minute
+)
+
+// PostgresBroker fans the messages out to every instance connected to the same
+// database with LISTEN/NOTIFY. The messages are stored in broker_messages and
+// only their id is notified, NOTIFY payloads being limited to 8000 bytes.
+type PostgresBroker struct {
+	db       *sqlx.DB
+	listener *pq.Listener
+}
+
+// NewPostgresBroker publishes with db and receives with listener, which must
+// not be listening on any channel yet.
+func NewPostgresBroker(db *sqlx.DB, listener *pq.Listener) (*PostgresBroker, error) {
+	if err := listener.Listen(BrokerChannel); err != nil {
+		return nil, err
+	}
+	return &PostgresBroker{
+		db:       db,
+		listener: listener,
+	}, nil
+}
+
+func (broker *PostgresBroker) Publish(message BrokerMessage) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	query := `
+		WITH message AS (
+			INSERT INTO broker_messages (payload) VALUES ($2) RETURNING id
+		)
+		SELECT pg_notify($1, id::text) FROM message
+	`
+	_, err = broker.db.Exec(query, BrokerChannel, string(payload))
+	return err
+}
+
+// Listen starts receiving the notifications of every instance, including the
+// current one, and hands them to handler. It must be called once.
+func (broker *PostgresBroker) Listen(handler func(message BrokerMessage)) {
+	go func() {
+		purge := time.NewTicker(purgeInterval)
+		defer purge.Stop()
+
+		for {
+			select {
+			case notification, ok := <-broker.listener.NotificationChannel():
+				if !ok {
+					return
+				}
+				// a nil notification follows a reconnection, messages sent
+				// meanwhile are lost but remain in the inbox of their users
+				if notification == nil {
+					log.Println("Notification listener reconnected")
+					continue
+				}
+				message, err := broker.load(notification.Extra)
+				if err != nil {
+					log.Printf("Unable to load broker message %s: %v", notification.Extra, err)
+					continue
+				}
+				handler(message)
+			case <-purge.C:
+				if err := broker.purge(); err != nil {
+					log.Printf("Unable to purge broker messages: %v", err)
+				}
+			case <-time.After(listenerPingInterval):
+				go broker.listener.Ping()
+			}
+		}
+	}()
+}
+
+// load reads the message whose id was notified.
+func (broker *PostgresBroker) load(id string) (BrokerMessage, error) {
+	var message BrokerMessage
+	messageId, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return message, err
+	}
+	var payload string
+	if err := broker.db.Get(&payload, "SELECT payload FROM broker_messages WHERE id=$1", messageId); err != nil {
+		return message, err
+	}
+	err = json.Unmarshal([]byte(payload), &message)
+	return message, err
+}
+
+// purge deletes the messages every instance already had the time to load.
+func (broker *PostgresBroker) purge() error {
+	_, err := broker.db.Exec("DELETE FROM broker_messages WHERE created_at < $1", time.Now().Add(-messageRetention))
+	return err
+}
+
+func (broker *PostgresBroker) Close() error {
+	return broker.listener.Close()
+}
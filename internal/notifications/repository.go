@@ -0,0 +1,132 @@
+package notifications
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/jmoiron/sqlx"
+	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/pkg/utils"
+	"strings"
+	"time"
+)
+
+type NotificationsRepository interface {
+	AddNotification(userId int, event Event) (types.Notification, error)
+	GetNotifications(userId int, filters map[string]interface{}) ([]types.Notification, error)
+	GetNotificationsSince(userId int, lastId int) ([]types.Notification, error)
+	MarkAsRead(id int, userId int) error
+	MarkAllAsRead(userId int) error
+	CountUnread(userId int) (int, error)
+	AddStreamTicket(userId int, ticketHash string, sessionExpiresAt time.Time, lifetime time.Duration) (time.Time, error)
+	ConsumeStreamTicket(ticketHash string) (int, time.Time, error)
+}
+
+// maxReplayedNotifications bounds the number of events sent back to a client
+// reconnecting after a long time, older ones stay available in the inbox.
+const maxReplayedNotifications = 100
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewNotificationsRepository(db *sqlx.DB) *Repository {
+	return &Repository{
+		db: db,
+	}
+}
+
+func (repository *Repository) AddNotification(userId int, event Event) (types.Notification, error) {
+	var notification types.Notification
+	payload, err := encodePayload(event)
+	if err != nil {
+		return notification, err
+	}
+
+	var kermesseId *int
+	if event.KermesseId != 0 {
+		kermesseId = &event.KermesseId
+	}
+	query := `
+		INSERT INTO notifications (user_id, version, type, kermesse_id, payload, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING *
+	`
+	err = repository.db.Get(&notification, query, userId, event.Version, event.Type, kermesseId, payload, event.OccurredAt)
+	return notification, err
+}
+
+func (repository *Repository) GetNotifications(userId int, filters map[string]interface{}) ([]types.Notification, error) {
+	var notifications []types.Notification
+	query := "SELECT * FROM notifications WHERE user_id=$1"
+
+	var conditions []string
+	if _, ok := filters["unread"]; ok {
+		conditions = append(conditions, "is_read=FALSE")
+	}
+	if beforeId, ok := filters["before_id"]; ok {
+		conditions = append(conditions, fmt.Sprintf("id < %v", beforeId))
+	}
+	if len(conditions) > 0 {
+		query += " AND " + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT %v", filters["limit"])
+
+	err := repository.db.Select(&notifications, query, userId)
+	return notifications, err
+}
+
+func (repository *Repository) GetNotificationsSince(userId int, lastId int) ([]types.Notification, error) {
+	var notifications []types.Notification
+	query := "SELECT * FROM notifications WHERE user_id=$1 AND id > $2 ORDER BY id LIMIT $3"
+	err := repository.db.Select(&notifications, query, userId, lastId, maxReplayedNotifications)
+	return notifications, err
+}
+
+func (repository *Repository) MarkAsRead(id int, userId int) error {
+	query := "UPDATE notifications SET is_read=TRUE WHERE id=$1 AND user_id=$2"
+	return utils.ExecSingleRow(repository.db, sql.ErrNoRows, query, id, userId)
+}
+
+func (repository *Repository) MarkAllAsRead(userId int) error {
+	query := "UPDATE notifications SET is_read=TRUE WHERE user_id=$1 AND is_read=FALSE"
+	_, err := repository.db.Exec(query, userId)
+	return err
+}
+
+func (repository *Repository) CountUnread(userId int) (int, error) {
+	var count int
+	query := "SELECT COUNT(*) FROM notifications WHERE user_id=$1 AND is_read=FALSE"
+	err := repository.db.Get(&count, query, userId)
+	return count, err
+}
+
+// AddStreamTicket returns the expiration date of the ticket. The tickets that
+// expired unused are deleted on the way.
+func (repository *Repository) AddStreamTicket(userId int, ticketHash string, sessionExpiresAt time.Time, lifetime time.Duration) (time.Time, error) {
+	_, err := repository.db.Exec("DELETE FROM stream_tickets WHERE expires_at <= NOW()")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var expiresAt time.Time
+	query := `
+		INSERT INTO stream_tickets (ticket_hash, user_id, session_expires_at, expires_at)
+		VALUES ($1, $2, $3, NOW() + MAKE_INTERVAL(secs => $4))
+		RETURNING expires_at
+	`
+	err = repository.db.Get(&expiresAt, query, ticketHash, userId, sessionExpiresAt, lifetime.Seconds())
+	return expiresAt, err
+}
+
+// ConsumeStreamTicket deletes the ticket and returns its user along with the
+// expiration date of the token it was issued with. It returns sql.ErrNoRows
+// when the ticket is unknown, already used or expired.
+func (repository *Repository) ConsumeStreamTicket(ticketHash string) (int, time.Time, error) {
+	var ticket struct {
+		UserId           int       `db:"user_id"`
+		SessionExpiresAt time.Time `db:"session_expires_at"`
+	}
+	query := "DELETE FROM stream_tickets WHERE ticket_hash=$1 AND expires_at > NOW() RETURNING user_id, session_expires_at"
+	err := repository.db.Get(&ticket, query, ticketHash)
+	return ticket.UserId, ticket.SessionExpiresAt, err
+}
@@ -0,0 +1,206 @@
+package notifications
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	goErrors "errors"
+	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/pkg/errors"
+	"strconv"
+	"time"
+)
+
+type NotificationsService interface {
+	GetNotifications(ctx context.Context, params map[string]interface{}) ([]types.Notification, error)
+	MarkAsRead(ctx context.Context, id int) error
+	MarkAllAsRead(ctx context.Context) error
+	IssueStreamTicket(ctx context.Context) (types.StreamTicket, error)
+	RedeemStreamTicket(ticket string) (int, time.Time, error)
+}
+
+const (
+	defaultNotificationsLimit = 50
+	maxNotificationsLimit     = 100
+)
+
+// streamTicketLifetime leaves the client the time to open the stream right
+// after getting the ticket, the ticket ends up in access logs as part of the
+// URL.
+const streamTicketLifetime = 30 * time.Second
+
+type Service struct {
+	notificationsRepository NotificationsRepository
+}
+
+func NewNotificationsService(notificationsRepository NotificationsRepository) *Service {
+	return &Service{
+		notificationsRepository: notificationsRepository,
+	}
+}
+
+func (service *Service) GetNotifications(ctx context.Context, params map[string]interface{}) ([]types.Notification, error) {
+	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
+	if !ok {
+		return nil, errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("user ID not found"),
+		}
+	}
+
+	filters := map[string]interface{}{
+		"limit": defaultNotificationsLimit,
+	}
+	if unread, exists := params["unread"]; exists && unread == "true" {
+		filters["unread"] = true
+	}
+	if beforeId, exists := params["before_id"]; exists {
+		id, err := strconv.Atoi(beforeId.(string))
+		if err != nil {
+			return nil, errors.CustomError{
+				Key: errors.BadRequest,
+				Err: goErrors.New("before_id is not a valid number"),
+			}
+		}
+		filters["before_id"] = id
+	}
+	if limit, exists := params["limit"]; exists {
+		value, err := strconv.Atoi(limit.(string))
+		if err != nil || value <= 0 || value > maxNotificationsLimit {
+			return nil, errors.CustomError{
+				Key: errors.BadRequest,
+				Err: goErrors.New("limit must be between 1 and 100"),
+			}
+		}
+		filters["limit"] = value
+	}
+
+	notifications, err := service.notificationsRepository.GetNotifications(userId, filters)
+	if err != nil {
+		return nil, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	if notifications == nil {
+		return []types.Notification{}, nil
+	}
+
+	return notifications, nil
+}
+
+func (service *Service) MarkAsRead(ctx context.Context, id int) error {
+	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
+	if !ok {
+		return errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("user ID not found"),
+		}
+	}
+
+	err := service.notificationsRepository.MarkAsRead(id, userId)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return errors.CustomError{
+				Key: errors.NotFound,
+				Err: err,
+			}
+		}
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (service *Service) MarkAllAsRead(ctx context.Context) error {
+	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
+	if !ok {
+		return errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("user ID not found"),
+		}
+	}
+
+	err := service.notificationsRepository.MarkAllAsRead(userId)
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+// IssueStreamTicket returns a single-use ticket opening the event stream of
+// the user in context, for the browsers whose EventSource cannot send the
+// Authorization header. The stream still ends when the token expires.
+func (service *Service) IssueStreamTicket(ctx context.Context) (types.StreamTicket, error) {
+	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
+	if !ok {
+		return types.StreamTicket{}, errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("user ID not found"),
+		}
+	}
+	sessionExpiresAt, ok := ctx.Value(types.TokenExpiresAtSessionKey).(time.Time)
+	if !ok {
+		return types.StreamTicket{}, errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("token expiration not found"),
+		}
+	}
+
+	randomBytes := make([]byte, 32)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return types.StreamTicket{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	ticket := base64.RawURLEncoding.EncodeToString(randomBytes)
+
+	expiresAt, err := service.notificationsRepository.AddStreamTicket(userId, hashStreamTicket(ticket), sessionExpiresAt, streamTicketLifetime)
+	if err != nil {
+		return types.StreamTicket{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	return types.StreamTicket{
+		Ticket:    ticket,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// RedeemStreamTicket uses up the ticket and returns its user along with the
+// expiration date of the token it was issued with.
+func (service *Service) RedeemStreamTicket(ticket string) (int, time.Time, error) {
+	userId, sessionExpiresAt, err := service.notificationsRepository.ConsumeStreamTicket(hashStreamTicket(ticket))
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return 0, time.Time{}, errors.CustomError{
+				Key: errors.Unauthorized,
+				Err: goErrors.New("invalid or expired ticket"),
+			}
+		}
+		return 0, time.Time{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return userId, sessionExpiresAt, nil
+}
+
+// hashStreamTicket is what the database keeps of a ticket, a leaked table does
+// not open any stream.
+func hashStreamTicket(ticket string) string {
+	sum := sha256.Sum256([]byte(ticket))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,20 +1,32 @@
 package participations
 
 import (
+	"database/sql"
+	goErrors "errors"
 	"fmt"
 	"github.com/jmoiron/sqlx"
+	"github.com/kermesse-backend/internal/approvals"
+	"github.com/kermesse-backend/internal/limits"
+	"github.com/kermesse-backend/internal/notifications"
 	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/internal/webhooks"
+	"github.com/kermesse-backend/pkg/utils"
 	"strings"
 )
 
 type ParticipationsRepository interface {
 	GetAllParticipations(filters map[string]interface{}) ([]types.ParticipationUserStand, error)
 	GetParticipationById(id int) (types.ParticipationCompleteModel, error)
-	AddParticipation(input map[string]interface{}) error
-	UpdateParticipation(id int, input map[string]interface{}) error
+	PurchaseParticipation(input map[string]interface{}, events []notifications.Event) error
+	UpdateParticipation(id int, version int, input map[string]interface{}, event notifications.Event) error
 	IsEligibleForCreation(input map[string]interface{}) (bool, error)
 }
 
+var (
+	ErrInsufficientStock   = goErrors.New("insufficient stock")
+	ErrInsufficientBalance = goErrors.New("insufficient balance")
+)
+
 type Repository struct {
 	db *sqlx.DB
 }
@@ -42,16 +54,18 @@ func (repository *Repository) GetAllParticipations(filters map[string]interface{
 			u.id AS "user.id",
 			u.name AS "user.name",
 			u.email AS "user.email",
-			u.role AS "user.role"
+			u.role AS "user.role",
+			p.created_at AS created_at,
+			p.updated_at AS updated_at
 		FROM participations p
 		JOIN users u ON p.user_id = u.id
 		JOIN stands s ON p.stand_id = s.id
-		WHERE 1=1
+		WHERE p.deleted_at IS NULL
 	`
 
 	var conditions []string
 	if parentId, ok := filters["parent_id"]; ok {
-		conditions = append(conditions, fmt.Sprintf("(u.id = %v OR u.parent_id = %v)", parentId, parentId))
+		conditions = append(conditions, fmt.Sprintf("(u.id = %v OR u.id IN (SELECT student_id FROM student_guardians WHERE guardian_id = %v))", parentId, parentId))
 	}
 	if studentId, ok := filters["student_id"]; ok {
 		conditions = append(conditions, fmt.Sprintf("u.id = %v", studentId))
@@ -93,31 +107,105 @@ func (repository *Repository) GetParticipationById(id int) (types.ParticipationC
 			k.id AS "kermesse.id",
 			k.name AS "kermesse.name",
 			k.description AS "kermesse.description",
-			k.status AS "kermesse.status"
+			k.status AS "kermesse.status",
+			p.created_at AS created_at,
+			p.updated_at AS updated_at,
+			p.version AS version
 		FROM participations p
 		JOIN users u ON p.user_id = u.id
 		JOIN stands s ON p.stand_id = s.id
 		JOIN kermesses k ON p.kermesse_id = k.id
-		WHERE p.id=$1
+		WHERE p.id=$1 AND p.deleted_at IS NULL
 	`
 	err := repository.db.Get(&participation, query, id)
 	return participation, err
 }
 
-func (repository *Repository) AddParticipation(input map[string]interface{}) error {
-	query := "INSERT INTO participations (user_id, kermesse_id, stand_id, category, balance, status) VALUES ($1, $2, $3, $4, $5, $6)"
-	_, err := repository.db.Exec(query, input["user_id"], input["kermesse_id"], input["stand_id"], input["category"], input["balance"], input["status"])
+// PurchaseParticipation charges the student, pays the stand holder and
+// records the participation in a single transaction. The stock of a food stand
+// is taken when input["take_stock"] is true, and the spending limits of the
+// student are checked when input["check_limits"] is true. It fails with
+// ErrInsufficientStock or ErrInsufficientBalance, leaving nothing charged. The
+// webhook deliveries of the events are queued in the same transaction, and the
+// approved purchase request input["request_id"], if any, is completed in it.
+func (repository *Repository) PurchaseParticipation(input map[string]interface{}, events []notifications.Event) (err error) {
+	tx, err := repository.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	if requestId, ok := input["request_id"].(int); ok && requestId != 0 {
+		err = approvals.CompleteRequest(tx, requestId)
+		if err != nil {
+			return err
+		}
+	}
+
+	if input["check_limits"] == true {
+		err = limits.CheckSpendingTx(tx, input["user_id"].(int), input["kermesse_id"].(int), input["category"].(string), input["balance"].(int))
+		if err != nil {
+			return err
+		}
+	}
 
-	return err
+	if input["take_stock"] == true {
+		err = utils.ExecSingleRow(tx, ErrInsufficientStock, "UPDATE stands SET stock = stock - $1 WHERE id = $2 AND stock >= $1", input["quantity"], input["stand_id"])
+		if err != nil {
+			return err
+		}
+	}
+
+	err = utils.ExecSingleRow(tx, ErrInsufficientBalance, "UPDATE users SET balance = balance - $1 WHERE id = $2 AND balance >= $1", input["balance"], input["user_id"])
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE users SET balance = balance + $1 WHERE id = $2", input["balance"], input["stand_holder_id"])
+	if err != nil {
+		return err
+	}
+
+	query := "INSERT INTO participations (user_id, kermesse_id, stand_id, category, balance, status) VALUES ($1, $2, $3, $4, $5, $6)"
+	_, err = tx.Exec(query, input["user_id"], input["kermesse_id"], input["stand_id"], input["category"], input["balance"], input["status"])
+	if err != nil {
+		return err
+	}
+	return webhooks.QueueEvents(tx, events)
 }
 
-func (repository *Repository) UpdateParticipation(id int, input map[string]interface{}) error {
-	query := "UPDATE participations SET status=$1, point=$2 WHERE id=$3"
-	_, err := repository.db.Exec(query, input["status"], input["point"], id)
+// UpdateParticipation updates the participation if it is still at the
+// version, it returns sql.ErrNoRows when it was changed in the meantime. The
+// webhook deliveries of the event are queued in the same transaction.
+func (repository *Repository) UpdateParticipation(id int, version int, input map[string]interface{}, event notifications.Event) (err error) {
+	tx, err := repository.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
 
-	return err
+	query := "UPDATE participations SET status=$1, point=$2 WHERE id=$3 AND version=$4 AND deleted_at IS NULL"
+	err = utils.ExecSingleRow(tx, sql.ErrNoRows, query, input["status"], input["point"], id, version)
+	if err != nil {
+		return err
+	}
+	return webhooks.QueueEvent(tx, event)
 }
 
+// IsEligibleForCreation checks that the stand is part of the kermesse, that
+// the user is invited to it and that it is started. The spending limits and
+// the participation are recorded under that kermesse.
 func (repository *Repository) IsEligibleForCreation(input map[string]interface{}) (bool, error) {
 	var isEligible bool
 	query := `
@@ -126,9 +214,9 @@ func (repository *Repository) IsEligibleForCreation(input map[string]interface{}
 			FROM kermesses_users ku
   			JOIN kermesses_stands ks ON ku.kermesse_id = ks.kermesse_id
 			JOIN kermesses k ON ku.kermesse_id = k.id
-  			WHERE ku.user_id = $1 AND ks.stand_id = $2 AND k.status = 'STARTED'
+  			WHERE ku.user_id = $1 AND ks.stand_id = $2 AND ku.kermesse_id = $3 AND k.status = 'STARTED' AND k.deleted_at IS NULL
 		) AS is_associated
  	`
-	err := repository.db.QueryRow(query, input["user_id"], input["stand_id"]).Scan(&isEligible)
+	err := repository.db.QueryRow(query, input["user_id"], input["stand_id"], input["kermesse_id"]).Scan(&isEligible)
 	return isEligible, err
 }
@@ -4,8 +4,16 @@ import (
 	"context"
 	"database/sql"
 	goErrors "errors"
+	"fmt"
+	"strconv"
 
+	"github.com/kermesse-backend/internal/approvals"
+	"github.com/kermesse-backend/internal/audit"
 	"github.com/kermesse-backend/internal/kermesses"
+	"github.com/kermesse-backend/internal/limits"
+	"github.com/kermesse-backend/internal/notifications"
+	"github.com/kermesse-backend/internal/policy"
+	"github.com/kermesse-backend/internal/push"
 	"github.com/kermesse-backend/internal/stands"
 	"github.com/kermesse-backend/internal/types"
 	"github.com/kermesse-backend/internal/users"
@@ -16,8 +24,8 @@ import (
 type ParticipationsService interface {
 	GetAllParticipations(ctx context.Context, params map[string]interface{}) ([]types.ParticipationUserStand, error)
 	GetParticipationById(id int) (types.ParticipationCompleteModel, error)
-	AddParticipation(ctx context.Context, input map[string]interface{}) error
-	ModifyParticipation(ctx context.Context, id int, input map[string]interface{}) error
+	AddParticipation(ctx context.Context, input map[string]interface{}) (*types.PurchaseRequest, error)
+	ModifyParticipation(ctx context.Context, id int, version int, input map[string]interface{}) error
 }
 
 type Service struct {
@@ -25,14 +33,30 @@ type Service struct {
 	kermessesRepository      kermesses.KermessesRepository
 	usersRepository          users.UsersRepository
 	standsRepository         stands.StandsRepository
+	hub                      *notifications.Hub
+	pushService              *push.Service
+	limitsService            *limits.Service
+	approvalsService         *approvals.Service
+	policyService            policy.PolicyService
+	auditService             *audit.Service
 }
 
-func NewParticipationsService(usersRepository users.UsersRepository, kermessesRepository kermesses.KermessesRepository, participationsRepository ParticipationsRepository, standsRepository stands.StandsRepository) *Service {
+// lowStockThreshold is the remaining stock of a food stand under which its
+// holder is warned after each sale.
+const lowStockThreshold = 5
+
+func NewParticipationsService(usersRepository users.UsersRepository, kermessesRepository kermesses.KermessesRepository, participationsRepository ParticipationsRepository, standsRepository stands.StandsRepository, hub *notifications.Hub, pushService *push.Service, limitsService *limits.Service, approvalsService *approvals.Service, policyService policy.PolicyService, auditService *audit.Service) *Service {
 	return &Service{
 		participationsRepository: participationsRepository,
 		kermessesRepository:      kermessesRepository,
 		usersRepository:          usersRepository,
 		standsRepository:         standsRepository,
+		hub:                      hub,
+		pushService:              pushService,
+		limitsService:            limitsService,
+		approvalsService:         approvalsService,
+		policyService:            policyService,
+		auditService:             auditService,
 	}
 }
 
@@ -99,10 +123,20 @@ func (service *Service) GetParticipationById(id int) (types.ParticipationComplet
 	return participation, nil
 }
 
-func (service *Service) AddParticipation(ctx context.Context, input map[string]interface{}) error {
+// AddParticipation charges the student right away, or returns the purchase
+// request waiting for the approval of their parent when the price is above
+// the approval threshold.
+func (service *Service) AddParticipation(ctx context.Context, input map[string]interface{}) (*types.PurchaseRequest, error) {
 	standId, err := utils.ConvertToInt(input, "stand_id")
 	if err != nil {
-		return errors.CustomError{
+		return nil, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: err,
+		}
+	}
+	kermesseId, err := utils.ConvertToInt(input, "kermesse_id")
+	if err != nil {
+		return nil, errors.CustomError{
 			Key: errors.BadRequest,
 			Err: err,
 		}
@@ -110,19 +144,19 @@ func (service *Service) AddParticipation(ctx context.Context, input map[string]i
 	stand, err := service.standsRepository.GetStandById(standId)
 	if err != nil {
 		if goErrors.Is(err, sql.ErrNoRows) {
-			return errors.CustomError{
+			return nil, errors.CustomError{
 				Key: errors.NotFound,
 				Err: err,
 			}
 		}
-		return errors.CustomError{
+		return nil, errors.CustomError{
 			Key: errors.InternalServerError,
 			Err: err,
 		}
 	}
 	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
 	if !ok {
-		return errors.CustomError{
+		return nil, errors.CustomError{
 			Key: errors.Unauthorized,
 			Err: goErrors.New("unable to retrieve user id"),
 		}
@@ -130,25 +164,26 @@ func (service *Service) AddParticipation(ctx context.Context, input map[string]i
 	user, err := service.usersRepository.GetUserById(userId)
 	if err != nil {
 		if goErrors.Is(err, sql.ErrNoRows) {
-			return errors.CustomError{
+			return nil, errors.CustomError{
 				Key: errors.NotFound,
 				Err: err,
 			}
 		}
-		return errors.CustomError{
+		return nil, errors.CustomError{
 			Key: errors.InternalServerError,
 			Err: err,
 		}
 	}
 
 	canBeCreated, err := service.participationsRepository.IsEligibleForCreation(map[string]interface{}{
-		"user_id":  userId,
-		"stand_id": standId,
+		"user_id":     userId,
+		"stand_id":    standId,
+		"kermesse_id": kermesseId,
 	})
 	if err != nil || !canBeCreated {
-		return errors.CustomError{
+		return nil, errors.CustomError{
 			Key: errors.Forbidden,
-			Err: goErrors.New("participation creation is not allowed"),
+			Err: goErrors.New("the stand is not part of the kermesse or the user is not invited to it"),
 		}
 	}
 
@@ -157,7 +192,7 @@ func (service *Service) AddParticipation(ctx context.Context, input map[string]i
 	if stand.Category == types.ParticipationTypeFood {
 		quantity, err = utils.ConvertToInt(input, "quantity")
 		if err != nil {
-			return errors.CustomError{
+			return nil, errors.CustomError{
 				Key: errors.BadRequest,
 				Err: err,
 			}
@@ -166,38 +201,91 @@ func (service *Service) AddParticipation(ctx context.Context, input map[string]i
 	}
 
 	if stand.Category == types.ParticipationTypeFood && stand.Stock < quantity {
-		return errors.CustomError{
+		return nil, errors.CustomError{
 			Key: errors.BadRequest,
 			Err: goErrors.New("insufficient stock"),
 		}
 	}
 
 	if user.Balance < totalPrice {
-		return errors.CustomError{
+		return nil, errors.CustomError{
 			Key: errors.BadRequest,
 			Err: goErrors.New("insufficient balance"),
 		}
 	}
 
-	if stand.Category == types.ParticipationTypeFood {
-		err = service.standsRepository.AdjustStock(standId, -quantity)
+	if user.Role == types.UserRoleStudent {
+		err = service.limitsService.CheckSpending(userId, kermesseId, stand.Category, totalPrice)
 		if err != nil {
-			return errors.CustomError{
-				Key: errors.InternalServerError,
+			return nil, err
+		}
+
+		requiresApproval, err := service.limitsService.RequiresApproval(userId, totalPrice)
+		if err != nil {
+			return nil, err
+		}
+		if requiresApproval {
+			return service.requestApproval(user, stand, kermesseId, quantity, totalPrice)
+		}
+	}
+
+	return nil, service.completePurchase(user, stand, kermesseId, quantity, totalPrice, 0)
+}
+
+// requestApproval reserves the stock of a food stand while the parent of the
+// student decides. The stock is taken along with the record of the request,
+// the stand stays locked until the request is recorded.
+func (service *Service) requestApproval(user types.User, stand types.Stand, kermesseId int, quantity int, totalPrice int) (*types.PurchaseRequest, error) {
+	var request types.PurchaseRequest
+	record := func() error {
+		var err error
+		request, err = service.approvalsService.RequestApproval(user, types.PurchaseRequest{
+			Kind:       types.PurchaseKindParticipation,
+			KermesseId: kermesseId,
+			StandId:    &stand.Id,
+			ItemName:   stand.Name,
+			Quantity:   quantity,
+			Amount:     totalPrice,
+		})
+		return err
+	}
+
+	var err error
+	if stand.Category == types.ParticipationTypeFood {
+		err = service.standsRepository.ReserveStock(stand.Id, quantity, record)
+	} else {
+		err = record()
+	}
+	if err != nil {
+		if _, ok := err.(errors.CustomError); ok {
+			return nil, err
+		}
+		if goErrors.Is(err, stands.ErrInsufficientStock) {
+			return nil, errors.CustomError{
+				Key: errors.BadRequest,
 				Err: err,
 			}
 		}
+		return nil, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
 	}
+	return &request, nil
+}
 
-	err = service.usersRepository.AlterBalance(userId, -totalPrice)
+// CompletePurchase charges a participation approved by the parent, if the
+// spending limits of the student still allow it. Its stock was reserved when
+// it was requested.
+func (service *Service) CompletePurchase(request types.PurchaseRequest) error {
+	stand, err := service.standsRepository.GetStandById(*request.StandId)
 	if err != nil {
 		return errors.CustomError{
 			Key: errors.InternalServerError,
 			Err: err,
 		}
 	}
-
-	err = service.usersRepository.AlterBalance(stand.UserId, totalPrice)
+	user, err := service.usersRepository.GetUserById(request.StudentId)
 	if err != nil {
 		return errors.CustomError{
 			Key: errors.InternalServerError,
@@ -205,26 +293,127 @@ func (service *Service) AddParticipation(ctx context.Context, input map[string]i
 		}
 	}
 
-	input["balance"] = totalPrice
-	input["user_id"] = userId
-	input["category"] = stand.Category
+	canBeCreated, err := service.participationsRepository.IsEligibleForCreation(map[string]interface{}{
+		"user_id":     user.Id,
+		"stand_id":    stand.Id,
+		"kermesse_id": request.KermesseId,
+	})
+	if err != nil || !canBeCreated {
+		return errors.CustomError{
+			Key: errors.Forbidden,
+			Err: goErrors.New("participation creation is not allowed"),
+		}
+	}
+
+	if user.Balance < request.Amount {
+		return errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("insufficient balance"),
+		}
+	}
+
+	return service.completePurchase(user, stand, request.KermesseId, request.Quantity, request.Amount, request.Id)
+}
+
+// ReleasePurchase gives back the stock reserved by a participation that will
+// not complete.
+func (service *Service) ReleasePurchase(request types.PurchaseRequest) error {
+	stand, err := service.standsRepository.GetStandById(*request.StandId)
+	if err != nil {
+		return err
+	}
+	if stand.Category != types.ParticipationTypeFood {
+		return nil
+	}
+	return service.standsRepository.AdjustStock(stand.Id, request.Quantity)
+}
+
+// completePurchase charges the student, pays the stand holder and records the
+// participation. The purchase request requestId, when not zero, is completed
+// along with it and the stock of a food stand it reserved is not taken again.
+// The spending limits of a student are checked again along with the charge,
+// another purchase may have been made since they were first checked.
+func (service *Service) completePurchase(user types.User, stand types.Stand, kermesseId int, quantity int, totalPrice int, requestId int) error {
+	stockReserved := requestId != 0
+	status := types.ParticipationStatusFinished
 	if stand.Category == types.ParticipationTypeGame {
-		input["status"] = types.ParticipationStatusStarted
-	} else {
-		input["status"] = types.ParticipationStatusFinished
+		status = types.ParticipationStatusStarted
 	}
 
-	err = service.participationsRepository.AddParticipation(input)
+	event := notifications.NewEvent(notifications.EventParticipationCreated, kermesseId, notifications.ParticipationCreatedPayload{
+		StandId:     stand.Id,
+		StandName:   stand.Name,
+		Category:    stand.Category,
+		StudentId:   user.Id,
+		StudentName: user.Name,
+		Quantity:    quantity,
+		TotalPrice:  totalPrice,
+	})
+	events := []notifications.Event{event}
+
+	remaining := stand.Stock
+	if !stockReserved {
+		remaining -= quantity
+	}
+	var stockEvent *notifications.Event
+	if stand.Category == types.ParticipationTypeFood && remaining <= lowStockThreshold {
+		lowStock := notifications.NewEvent(notifications.EventStockLow, kermesseId, notifications.StockLowPayload{
+			StandId:   stand.Id,
+			StandName: stand.Name,
+			Stock:     remaining,
+			Threshold: lowStockThreshold,
+		})
+		stockEvent = &lowStock
+		events = append(events, lowStock)
+	}
+
+	err := service.participationsRepository.PurchaseParticipation(map[string]interface{}{
+		"user_id":         user.Id,
+		"kermesse_id":     kermesseId,
+		"stand_id":        stand.Id,
+		"stand_holder_id": stand.UserId,
+		"category":        stand.Category,
+		"quantity":        quantity,
+		"balance":         totalPrice,
+		"status":          status,
+		"take_stock":      stand.Category == types.ParticipationTypeFood && !stockReserved,
+		"check_limits":    user.Role == types.UserRoleStudent,
+		"request_id":      requestId,
+	}, events)
 	if err != nil {
+		if _, ok := err.(errors.CustomError); ok {
+			return err
+		}
+		if goErrors.Is(err, ErrInsufficientStock) || goErrors.Is(err, ErrInsufficientBalance) || goErrors.Is(err, approvals.ErrNotApproved) {
+			return errors.CustomError{
+				Key: errors.BadRequest,
+				Err: err,
+			}
+		}
 		return errors.CustomError{
 			Key: errors.InternalServerError,
 			Err: err,
 		}
 	}
+
+	service.hub.Notify([]int{stand.UserId}, event, notifications.StandTopic(stand.Id), notifications.KermesseTopic(kermesseId))
+	if stockEvent != nil {
+		service.hub.Notify([]int{stand.UserId}, *stockEvent, notifications.StandTopic(stand.Id))
+	}
 	return nil
 }
 
-func (service *Service) ModifyParticipation(ctx context.Context, id int, input map[string]interface{}) error {
+// ModifyParticipation posts the points of a game. A version other than 0 must
+// be the current one, the update is refused with a conflict otherwise.
+func (service *Service) ModifyParticipation(ctx context.Context, id int, version int, input map[string]interface{}) error {
+	point, err := utils.ConvertToInt(input, "point")
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.BadRequest,
+			Err: err,
+		}
+	}
+
 	participation, err := service.participationsRepository.GetParticipationById(id)
 	if err != nil {
 		if goErrors.Is(err, sql.ErrNoRows) {
@@ -268,24 +457,61 @@ func (service *Service) ModifyParticipation(ctx context.Context, id int, input m
 		}
 	}
 
-	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
-	if !ok || stand.UserId != userId {
+	if err := service.policyService.Authorize(ctx, policy.StandManage, policy.Resource{OwnerId: stand.UserId, KermesseId: kermesse.Id}); err != nil {
+		return err
+	}
+
+	if version != 0 && version != participation.Version {
 		return errors.CustomError{
-			Key: errors.Forbidden,
-			Err: goErrors.New("user is not authorized to modify this participation"),
+			Key: errors.Conflict,
+			Err: goErrors.New("participation was modified since it was read"),
 		}
 	}
 
-	err = service.participationsRepository.UpdateParticipation(id, map[string]interface{}{
-		"point":  input["point"],
-		"status": types.ParticipationStatusFinished,
+	event := notifications.NewEvent(notifications.EventGameScored, kermesse.Id, notifications.GameScoredPayload{
+		ParticipationId: participation.Id,
+		StandId:         stand.Id,
+		StandName:       stand.Name,
+		StudentId:       participation.User.Id,
+		StudentName:     participation.User.Name,
+		Point:           point,
 	})
+	err = service.participationsRepository.UpdateParticipation(id, participation.Version, map[string]interface{}{
+		"point":  point,
+		"status": types.ParticipationStatusFinished,
+	}, event)
 	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return errors.CustomError{
+				Key: errors.Conflict,
+				Err: goErrors.New("participation was modified since it was read"),
+			}
+		}
 		return errors.CustomError{
 			Key: errors.InternalServerError,
 			Err: err,
 		}
 	}
 
+	service.auditService.Record(ctx, audit.Entry{
+		Action:     types.AuditActionPointsAwarded,
+		TargetType: types.AuditTargetParticipation,
+		TargetId:   participation.Id,
+		KermesseId: kermesse.Id,
+		Before:     map[string]interface{}{"point": participation.Point, "status": participation.Status},
+		After:      map[string]interface{}{"point": point, "status": types.ParticipationStatusFinished},
+		Details:    map[string]interface{}{"stand_id": stand.Id, "student_id": participation.User.Id},
+	})
+
+	service.hub.Notify([]int{participation.User.Id}, event, notifications.StandTopic(stand.Id), notifications.KermesseTopic(kermesse.Id))
+	service.pushService.NotifyUser(participation.User.Id, push.Message{
+		Title: "Score posted",
+		Body:  fmt.Sprintf("You scored %d points at %s", point, stand.Name),
+		Data: map[string]string{
+			"type":             notifications.EventGameScored,
+			"participation_id": strconv.Itoa(participation.Id),
+		},
+	})
+
 	return nil
 }
@@ -0,0 +1,125 @@
+package policy
+
+import "github.com/kermesse-backend/internal/types"
+
+// Action is something a user may be allowed to do on a resource.
+type Action string
+
+const (
+	KermesseCreate Action = "kermesse:create"
+	// KermesseManage covers the changes to a kermesse: its details, its
+	// completion, the users invited to it and the stands linked to it.
+	KermesseManage Action = "kermesse:manage"
+	KermesseFollow Action = "kermesse:follow"
+	TombolaManage  Action = "tombola:manage"
+	PrizeDeliver   Action = "prize:deliver"
+	WebhookManage  Action = "webhook:manage"
+	StandCreate    Action = "stand:create"
+	// StandManage covers the changes to a stand and to its participations.
+	StandManage Action = "stand:manage"
+	StandFollow Action = "stand:follow"
+	UserUpdate  Action = "user:update"
+	UserFollow  Action = "user:follow"
+	// StudentView covers reading the limits, the allowance, the guardians,
+	// the purchase requests and the tickets of a student.
+	StudentView Action = "student:view"
+	// StudentAct covers buying and claiming prizes for a student.
+	StudentAct Action = "student:act"
+	// StudentManage covers what only a guardian may do: setting the limits,
+	// moving jetons, deciding on purchase requests and inviting guardians.
+	StudentManage     Action = "student:manage"
+	FamilyView        Action = "family:view"
+	TransferView      Action = "transfer:view"
+	InvitationRespond Action = "invitation:respond"
+	// AuditView covers reading the audit events of a kermesse.
+	AuditView Action = "audit:view"
+	// AdminAccess covers the administration of the platform.
+	AdminAccess Action = "admin:access"
+)
+
+// Resource is what an action applies to. OwnerId is the user the resource
+// belongs to: the organizer of a kermesse, the holder of a stand, the student
+// of a ticket or the user themselves. KermesseId is the kermesse the resource
+// is part of.
+type Resource struct {
+	OwnerId    int
+	KermesseId int
+}
+
+type relation int
+
+const (
+	// anyone requires no relation with the resource.
+	anyone relation = iota
+	// owner requires the user to own the resource.
+	owner
+	// guardian requires the user to be a guardian of the owner.
+	guardian
+	// member requires the user to take part in the kermesse of the resource.
+	member
+)
+
+// rule grants an action to the users having one of the roles, any role when
+// there is none, and the relation with the resource.
+type rule struct {
+	roles    []string
+	relation relation
+}
+
+var (
+	organizer   = []string{types.UserRoleOrganizer}
+	standHolder = []string{types.UserRoleStandHolder}
+	parent      = []string{types.UserRoleParent}
+	student     = []string{types.UserRoleStudent}
+	admin       = []string{types.UserRoleAdmin}
+)
+
+// policies lists the rules granting each action, an action is allowed as soon
+// as one of its rules matches. An action missing here is never allowed.
+var policies = map[Action][]rule{
+	KermesseCreate:    {{roles: organizer, relation: anyone}},
+	KermesseManage:    {{roles: organizer, relation: owner}},
+	KermesseFollow:    {{relation: member}},
+	TombolaManage:     {{roles: organizer, relation: owner}},
+	PrizeDeliver:      {{roles: organizer, relation: owner}},
+	WebhookManage:     {{roles: organizer, relation: owner}},
+	StandCreate:       {{roles: standHolder, relation: anyone}},
+	StandManage:       {{roles: standHolder, relation: owner}},
+	StandFollow:       {{relation: owner}},
+	UserUpdate:        {{relation: owner}},
+	UserFollow:        {{relation: owner}},
+	StudentView:       {{roles: student, relation: owner}, {roles: parent, relation: guardian}},
+	StudentAct:        {{roles: student, relation: owner}, {roles: parent, relation: guardian}},
+	StudentManage:     {{roles: parent, relation: guardian}},
+	FamilyView:        {{roles: parent, relation: anyone}},
+	TransferView:      {{roles: parent, relation: anyone}, {roles: student, relation: anyone}},
+	InvitationRespond: {{roles: parent, relation: anyone}},
+	AuditView:         {{roles: organizer, relation: owner}, {roles: admin, relation: anyone}},
+	AdminAccess:       {{roles: admin, relation: anyone}},
+}
+
+// Roles returns the roles that may be granted the action, for the routes to
+// turn the other users away before looking at the resource. It returns nil
+// when any role may be.
+func Roles(action Action) []string {
+	var roles []string
+	for _, rule := range policies[action] {
+		if len(rule.roles) == 0 {
+			return nil
+		}
+		roles = append(roles, rule.roles...)
+	}
+	return roles
+}
+
+func (rule rule) hasRole(role string) bool {
+	if len(rule.roles) == 0 {
+		return true
+	}
+	for _, allowed := range rule.roles {
+		if allowed == role {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,252 @@
+package policy
+
+import (
+	"context"
+	goErrors "errors"
+	"fmt"
+	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/pkg/errors"
+	"reflect"
+	"testing"
+)
+
+// fakeRepository answers the relation lookups from maps keyed by
+// {student, guardian} and {kermesse, user}.
+type fakeRepository struct {
+	guardians map[[2]int]bool
+	members   map[[2]int]bool
+	err       error
+}
+
+func (repository *fakeRepository) IsGuardian(studentId int, guardianId int) (bool, error) {
+	return repository.guardians[[2]int{studentId, guardianId}], repository.err
+}
+
+func (repository *fakeRepository) IsKermesseMember(kermesseId int, userId int) (bool, error) {
+	return repository.members[[2]int{kermesseId, userId}], repository.err
+}
+
+const (
+	userId           = 1
+	studentId        = 2
+	otherUserId      = 3
+	memberKermesseId = 10
+	otherKermesseId  = 11
+)
+
+// newFakeRepository makes the user a guardian of studentId and a member of
+// memberKermesseId. The lookups with a zero id answer true as well, the
+// service must not make them.
+func newFakeRepository() *fakeRepository {
+	return &fakeRepository{
+		guardians: map[[2]int]bool{{studentId, userId}: true, {0, userId}: true},
+		members:   map[[2]int]bool{{memberKermesseId, userId}: true, {0, userId}: true},
+	}
+}
+
+// scenario is the relation of the user with the resource.
+type scenario string
+
+const (
+	ownResource       scenario = "owner"
+	otherResource     scenario = "non-owner"
+	guardedResource   scenario = "guardian"
+	memberResource    scenario = "member"
+	nonMemberResource scenario = "non-member"
+	zeroResource      scenario = "zero ids"
+)
+
+var resources = map[scenario]Resource{
+	ownResource:       {OwnerId: userId},
+	otherResource:     {OwnerId: otherUserId},
+	guardedResource:   {OwnerId: studentId},
+	memberResource:    {OwnerId: otherUserId, KermesseId: memberKermesseId},
+	nonMemberResource: {OwnerId: otherUserId, KermesseId: otherKermesseId},
+	zeroResource:      {},
+}
+
+var (
+	allRoles     = []string{types.UserRoleParent, types.UserRoleStudent, types.UserRoleOrganizer, types.UserRoleStandHolder, types.UserRoleAdmin}
+	allScenarios = []scenario{ownResource, otherResource, guardedResource, memberResource, nonMemberResource, zeroResource}
+)
+
+// every lists the scenarios in which the roles are allowed whatever the
+// resource.
+func every(roles ...string) map[scenario][]string {
+	allowed := make(map[scenario][]string)
+	for _, scenario := range allScenarios {
+		allowed[scenario] = roles
+	}
+	return allowed
+}
+
+func TestCan(t *testing.T) {
+	// allowed lists, for each action and scenario, the roles granted the
+	// action. The other roles and scenarios are denied.
+	allowed := map[Action]map[scenario][]string{
+		KermesseCreate: every(types.UserRoleOrganizer),
+		KermesseManage: {ownResource: {types.UserRoleOrganizer}},
+		KermesseFollow: {memberResource: allRoles},
+		TombolaManage:  {ownResource: {types.UserRoleOrganizer}},
+		PrizeDeliver:   {ownResource: {types.UserRoleOrganizer}},
+		WebhookManage:  {ownResource: {types.UserRoleOrganizer}},
+		StandCreate:    every(types.UserRoleStandHolder),
+		StandManage:    {ownResource: {types.UserRoleStandHolder}},
+		StandFollow:    {ownResource: allRoles},
+		UserUpdate:     {ownResource: allRoles},
+		UserFollow:     {ownResource: allRoles},
+		StudentView: {
+			ownResource:     {types.UserRoleStudent},
+			guardedResource: {types.UserRoleParent},
+		},
+		StudentAct: {
+			ownResource:     {types.UserRoleStudent},
+			guardedResource: {types.UserRoleParent},
+		},
+		StudentManage:     {guardedResource: {types.UserRoleParent}},
+		FamilyView:        every(types.UserRoleParent),
+		TransferView:      every(types.UserRoleParent, types.UserRoleStudent),
+		InvitationRespond: every(types.UserRoleParent),
+		AuditView: {
+			ownResource:       {types.UserRoleOrganizer, types.UserRoleAdmin},
+			otherResource:     {types.UserRoleAdmin},
+			guardedResource:   {types.UserRoleAdmin},
+			memberResource:    {types.UserRoleAdmin},
+			nonMemberResource: {types.UserRoleAdmin},
+			zeroResource:      {types.UserRoleAdmin},
+		},
+		AdminAccess: every(types.UserRoleAdmin),
+	}
+
+	for action := range policies {
+		if _, exists := allowed[action]; !exists {
+			t.Errorf("action %s has no expectation", action)
+		}
+	}
+
+	service := NewPolicyService(newFakeRepository())
+	for action, scenarios := range allowed {
+		for _, scenario := range allScenarios {
+			for _, role := range allRoles {
+				want := contains(scenarios[scenario], role)
+				t.Run(fmt.Sprintf("%s/%s/%s", action, role, scenario), func(t *testing.T) {
+					got, err := service.Can(types.User{Id: userId, Role: role}, action, resources[scenario])
+					if err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+					if got != want {
+						t.Errorf("got %v, want %v", got, want)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestCanUnknownAction(t *testing.T) {
+	service := NewPolicyService(newFakeRepository())
+	for _, role := range allRoles {
+		allowed, err := service.Can(types.User{Id: userId, Role: role}, Action("unknown"), Resource{OwnerId: userId})
+		if err != nil || allowed {
+			t.Errorf("%s: got %v, %v, want false, nil", role, allowed, err)
+		}
+	}
+}
+
+func TestCanRepositoryError(t *testing.T) {
+	repository := newFakeRepository()
+	repository.err = goErrors.New("connection lost")
+	service := NewPolicyService(repository)
+
+	tests := []struct {
+		name     string
+		role     string
+		action   Action
+		resource Resource
+	}{
+		{"guardian lookup", types.UserRoleParent, StudentManage, resources[guardedResource]},
+		{"member lookup", types.UserRoleStudent, KermesseFollow, resources[memberResource]},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			allowed, err := service.Can(types.User{Id: userId, Role: test.role}, test.action, test.resource)
+			if !goErrors.Is(err, repository.err) || allowed {
+				t.Errorf("got %v, %v, want false, %v", allowed, err, repository.err)
+			}
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	failing := newFakeRepository()
+	failing.err = goErrors.New("connection lost")
+
+	tests := []struct {
+		name       string
+		repository *fakeRepository
+		userId     interface{}
+		role       interface{}
+		action     Action
+		resource   Resource
+		wantKey    string
+	}{
+		{"allowed", newFakeRepository(), userId, types.UserRoleOrganizer, KermesseManage, resources[ownResource], ""},
+		{"denied", newFakeRepository(), userId, types.UserRoleOrganizer, KermesseManage, resources[otherResource], errors.Forbidden},
+		{"no user", newFakeRepository(), nil, types.UserRoleOrganizer, KermesseManage, resources[ownResource], errors.Unauthorized},
+		{"no role", newFakeRepository(), userId, nil, KermesseManage, resources[ownResource], errors.Unauthorized},
+		{"repository error", failing, userId, types.UserRoleParent, StudentManage, resources[guardedResource], errors.InternalServerError},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.userId != nil {
+				ctx = context.WithValue(ctx, types.UserIDSessionKey, test.userId)
+			}
+			if test.role != nil {
+				ctx = context.WithValue(ctx, types.UserRoleSessionKey, test.role)
+			}
+
+			err := NewPolicyService(test.repository).Authorize(ctx, test.action, test.resource)
+			if test.wantKey == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			customError, ok := err.(errors.CustomError)
+			if !ok || customError.Key != test.wantKey {
+				t.Errorf("got %v, want a %s error", err, test.wantKey)
+			}
+		})
+	}
+}
+
+func TestRoles(t *testing.T) {
+	tests := []struct {
+		action Action
+		want   []string
+	}{
+		{KermesseManage, []string{types.UserRoleOrganizer}},
+		{StudentView, []string{types.UserRoleStudent, types.UserRoleParent}},
+		{AuditView, []string{types.UserRoleOrganizer, types.UserRoleAdmin}},
+		{KermesseFollow, nil},
+		{UserUpdate, nil},
+		{Action("unknown"), nil},
+	}
+	for _, test := range tests {
+		t.Run(string(test.action), func(t *testing.T) {
+			if got := Roles(test.action); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
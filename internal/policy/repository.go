@@ -0,0 +1,46 @@
+package policy
+
+import (
+	"github.com/jmoiron/sqlx"
+)
+
+type PolicyRepository interface {
+	IsGuardian(studentId int, guardianId int) (bool, error)
+	IsKermesseMember(kermesseId int, userId int) (bool, error)
+}
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewPolicyRepository(db *sqlx.DB) *Repository {
+	return &Repository{
+		db: db,
+	}
+}
+
+func (repository *Repository) IsGuardian(studentId int, guardianId int) (bool, error) {
+	var isGuardian bool
+	query := "SELECT EXISTS (SELECT 1 FROM student_guardians WHERE student_id = $1 AND guardian_id = $2)"
+	err := repository.db.Get(&isGuardian, query, studentId, guardianId)
+	return isGuardian, err
+}
+
+// IsKermesseMember reports whether the user organizes the kermesse, was
+// invited to it or holds one of its stands.
+func (repository *Repository) IsKermesseMember(kermesseId int, userId int) (bool, error) {
+	var isMember bool
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM kermesses k WHERE k.id = $1 AND k.user_id = $2
+			UNION
+			SELECT 1 FROM kermesses_users ku WHERE ku.kermesse_id = $1 AND ku.user_id = $2
+			UNION
+			SELECT 1 FROM kermesses_stands ks JOIN stands s ON s.id = ks.stand_id WHERE ks.kermesse_id = $1 AND s.user_id = $2 AND s.deleted_at IS NULL
+		) AND NOT EXISTS (
+			SELECT 1 FROM kermesses WHERE id = $1 AND deleted_at IS NOT NULL
+		) AS is_member
+	`
+	err := repository.db.Get(&isMember, query, kermesseId, userId)
+	return isMember, err
+}
@@ -0,0 +1,95 @@
+package policy
+
+import (
+	"context"
+	goErrors "errors"
+	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/pkg/errors"
+)
+
+type PolicyService interface {
+	Can(user types.User, action Action, resource Resource) (bool, error)
+	Authorize(ctx context.Context, action Action, resource Resource) error
+}
+
+type Service struct {
+	policyRepository PolicyRepository
+}
+
+func NewPolicyService(policyRepository PolicyRepository) *Service {
+	return &Service{
+		policyRepository: policyRepository,
+	}
+}
+
+// Can reports whether the user may perform the action on the resource. Only
+// the id and the role of the user are looked at.
+func (service *Service) Can(user types.User, action Action, resource Resource) (bool, error) {
+	for _, rule := range policies[action] {
+		if !rule.hasRole(user.Role) {
+			continue
+		}
+		matches, err := service.matches(user, rule.relation, resource)
+		if err != nil {
+			return false, err
+		}
+		if matches {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Authorize checks the action for the user in context and returns a forbidden
+// error when it is not allowed.
+func (service *Service) Authorize(ctx context.Context, action Action, resource Resource) error {
+	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
+	if !ok {
+		return errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("user ID not found"),
+		}
+	}
+	userRole, ok := ctx.Value(types.UserRoleSessionKey).(string)
+	if !ok {
+		return errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("user role not found"),
+		}
+	}
+
+	allowed, err := service.Can(types.User{Id: userId, Role: userRole}, action, resource)
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	if !allowed {
+		return errors.CustomError{
+			Key: errors.Forbidden,
+			Err: goErrors.New("not allowed"),
+		}
+	}
+	return nil
+}
+
+func (service *Service) matches(user types.User, relation relation, resource Resource) (bool, error) {
+	switch relation {
+	case anyone:
+		return true, nil
+	case owner:
+		return resource.OwnerId != 0 && resource.OwnerId == user.Id, nil
+	case guardian:
+		if resource.OwnerId == 0 {
+			return false, nil
+		}
+		return service.policyRepository.IsGuardian(resource.OwnerId, user.Id)
+	case member:
+		if resource.KermesseId == 0 {
+			return false, nil
+		}
+		return service.policyRepository.IsKermesseMember(resource.KermesseId, user.Id)
+	}
+	return false, nil
+}
@@ -0,0 +1,19 @@
+package push
+
+import (
+	"context"
+	"log"
+)
+
+// FakeProvider logs the notifications instead of sending them, it is used for
+// local runs.
+type FakeProvider struct{}
+
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{}
+}
+
+func (provider *FakeProvider) Send(ctx context.Context, token string, message Message) error {
+	log.Printf("Push to %s: %s - %s", token, message.Title, message.Body)
+	return nil
+}
@@ -0,0 +1,217 @@
+package push
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	goErrors "errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultFCMEndpoint is the base URL of the Firebase Cloud Messaging HTTP
+	// v1 API.
+	DefaultFCMEndpoint = "https://fcm.googleapis.com"
+	// fcmScope is the OAuth2 scope needed to send messages.
+	fcmScope = "https://www.googleapis.com/auth/firebase.messaging"
+	// tokenLifetime is the lifetime asked for the access tokens, the longest
+	// Google grants. They are renewed a minute before they expire.
+	tokenLifetime = time.Hour
+	tokenMargin   = time.Minute
+)
+
+// FCMProvider sends the notifications with the Firebase Cloud Messaging HTTP
+// v1 API, authenticated with the OAuth2 access tokens of a service account.
+type FCMProvider struct {
+	endpoint       string
+	serviceAccount serviceAccount
+	privateKey     *rsa.PrivateKey
+	client         *http.Client
+
+	mutex       sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+// serviceAccount holds the fields of the JSON key of a Google service account
+// that are needed to get access tokens.
+type serviceAccount struct {
+	ProjectId   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenUri    string `json:"token_uri"`
+}
+
+type fcmRequest struct {
+	Message fcmMessage `json:"message"`
+}
+
+type fcmMessage struct {
+	Token        string            `json:"token"`
+	Notification fcmNotification   `json:"notification"`
+	Data         map[string]string `json:"data,omitempty"`
+}
+
+type fcmNotification struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+type fcmErrorResponse struct {
+	Error struct {
+		Message string `json:"message"`
+		Status  string `json:"status"`
+		Details []struct {
+			ErrorCode string `json:"errorCode"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// NewFCMProvider reads the JSON key of the service account at credentialsFile.
+// The messages are sent to the Firebase project of the service account.
+func NewFCMProvider(endpoint string, credentialsFile string) (*FCMProvider, error) {
+	if endpoint == "" {
+		endpoint = DefaultFCMEndpoint
+	}
+
+	content, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the FCM credentials: %w", err)
+	}
+	var account serviceAccount
+	if err := json.Unmarshal(content, &account); err != nil {
+		return nil, fmt.Errorf("unable to parse the FCM credentials: %w", err)
+	}
+	if account.ProjectId == "" || account.ClientEmail == "" || account.TokenUri == "" {
+		return nil, goErrors.New("FCM credentials must hold project_id, client_email and token_uri")
+	}
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(account.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the FCM private key: %w", err)
+	}
+
+	return &FCMProvider{
+		endpoint:       strings.TrimSuffix(endpoint, "/"),
+		serviceAccount: account,
+		privateKey:     privateKey,
+		client:         &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (provider *FCMProvider) Send(ctx context.Context, token string, message Message) error {
+	accessToken, err := provider.getAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(fcmRequest{
+		Message: fcmMessage{
+			Token: token,
+			Notification: fcmNotification{
+				Title: message.Title,
+				Body:  message.Body,
+			},
+			Data: message.Data,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	sendUrl := fmt.Sprintf("%s/v1/projects/%s/messages:send", provider.endpoint, url.PathEscape(provider.serviceAccount.ProjectId))
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, sendUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer "+accessToken)
+
+	response, err := provider.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var result fcmErrorResponse
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return fmt.Errorf("fcm responded with status %d", response.StatusCode)
+	}
+	for _, detail := range result.Error.Details {
+		if detail.ErrorCode == "UNREGISTERED" {
+			return ErrInvalidToken
+		}
+	}
+	return fmt.Errorf("fcm error %s: %s", result.Error.Status, result.Error.Message)
+}
+
+// getAccessToken returns the cached access token, or exchanges a JWT signed
+// with the key of the service account for a new one once it is about to
+// expire.
+func (provider *FCMProvider) getAccessToken(ctx context.Context) (string, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	if provider.accessToken != "" && time.Now().Before(provider.expiresAt.Add(-tokenMargin)) {
+		return provider.accessToken, nil
+	}
+
+	now := time.Now()
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   provider.serviceAccount.ClientEmail,
+		"scope": fcmScope,
+		"aud":   provider.serviceAccount.TokenUri,
+		"iat":   now.Unix(),
+		"exp":   now.Add(tokenLifetime).Unix(),
+	}).SignedString(provider.privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, provider.serviceAccount.TokenUri, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	response, err := provider.client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token endpoint responded with status %d", response.StatusCode)
+	}
+	var result tokenResponse
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	if result.AccessToken == "" {
+		return "", goErrors.New("token endpoint returned no access token")
+	}
+
+	provider.accessToken = result.AccessToken
+	provider.expiresAt = now.Add(time.Duration(result.ExpiresIn) * time.Second)
+	return provider.accessToken, nil
+}
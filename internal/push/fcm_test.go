@@ -0,0 +1,283 @@
+package push
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	goErrors "errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const (
+	projectId   = "kermesse-project"
+	clientEmail = "push@kermesse-project.iam.gserviceaccount.com"
+	deviceToken = "device-token"
+)
+
+// fakeGoogle stands for both the OAuth2 token endpoint and the FCM API. It
+// hands out numbered access tokens and answers the sends with sendStatus and
+// sendBody.
+type fakeGoogle struct {
+	t          *testing.T
+	server     *httptest.Server
+	publicKey  *rsa.PublicKey
+	expiresIn  int
+	sendStatus int
+	sendBody   string
+
+	tokenRequests atomic.Int32
+	lastSend      *http.Request
+	lastMessage   fcmRequest
+}
+
+func newFakeGoogle(t *testing.T, publicKey *rsa.PublicKey) *fakeGoogle {
+	google := &fakeGoogle{
+		t:          t,
+		publicKey:  publicKey,
+		expiresIn:  3600,
+		sendStatus: http.StatusOK,
+		sendBody:   `{"name": "projects/kermesse-project/messages/1"}`,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", google.token)
+	mux.HandleFunc("/v1/projects/"+projectId+"/messages:send", google.send)
+	google.server = httptest.NewServer(mux)
+	t.Cleanup(google.server.Close)
+	return google
+}
+
+// token checks the signed assertion of the service account before issuing an
+// access token.
+func (google *fakeGoogle) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		google.t.Errorf("invalid token request: %v", err)
+	}
+	if grantType := r.PostForm.Get("grant_type"); grantType != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+		google.t.Errorf("grant_type = %q", grantType)
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(r.PostForm.Get("assertion"), claims, func(token *jwt.Token) (interface{}, error) {
+		return google.publicKey, nil
+	}, jwt.WithValidMethods([]string{"RS256"}))
+	if err != nil {
+		google.t.Errorf("invalid assertion: %v", err)
+	}
+	if claims["iss"] != clientEmail || claims["scope"] != fcmScope || claims["aud"] != google.server.URL+"/token" {
+		google.t.Errorf("unexpected assertion claims %v", claims)
+	}
+
+	count := google.tokenRequests.Add(1)
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"access_token": "access-%d", "expires_in": %d, "token_type": "Bearer"}`, count, google.expiresIn)
+}
+
+func (google *fakeGoogle) send(w http.ResponseWriter, r *http.Request) {
+	google.lastSend = r
+	google.lastMessage = fcmRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&google.lastMessage); err != nil {
+		google.t.Errorf("invalid send body: %v", err)
+	}
+	w.WriteHeader(google.sendStatus)
+	fmt.Fprint(w, google.sendBody)
+}
+
+// newTestProvider writes the JSON key of a fresh service account whose tokens
+// are issued by google, and reads it back with NewFCMProvider.
+func newTestProvider(t *testing.T) (*FCMProvider, *fakeGoogle) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	google := newFakeGoogle(t, &privateKey.PublicKey)
+
+	key := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	credentials, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"project_id":   projectId,
+		"client_email": clientEmail,
+		"private_key":  string(key),
+		"token_uri":    google.server.URL + "/token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialsFile := filepath.Join(t.TempDir(), "credentials.json")
+	if err := os.WriteFile(credentialsFile, credentials, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	provider, err := NewFCMProvider(google.server.URL+"/", credentialsFile)
+	if err != nil {
+		t.Fatalf("NewFCMProvider: %v", err)
+	}
+	return provider, google
+}
+
+var testMessage = Message{
+	Title: "Tombola drawn",
+	Body:  "You won the first prize",
+	Data:  map[string]string{"type": "tombola.drawn", "tombola_id": "4"},
+}
+
+func TestFCMSendRequest(t *testing.T) {
+	provider, google := newTestProvider(t)
+
+	if err := provider.Send(context.Background(), deviceToken, testMessage); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	if google.lastSend == nil {
+		t.Fatal("no message was sent")
+	}
+	if got := google.lastSend.Method; got != http.MethodPost {
+		t.Errorf("method = %s, want POST", got)
+	}
+	if got := google.lastSend.Header.Get("Authorization"); got != "Bearer access-1" {
+		t.Errorf("Authorization = %q, want %q", got, "Bearer access-1")
+	}
+	if got := google.lastSend.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	message := google.lastMessage.Message
+	if message.Token != deviceToken {
+		t.Errorf("token = %q, want %q", message.Token, deviceToken)
+	}
+	if message.Notification.Title != testMessage.Title || message.Notification.Body != testMessage.Body {
+		t.Errorf("notification = %+v, want %q, %q", message.Notification, testMessage.Title, testMessage.Body)
+	}
+	if len(message.Data) != len(testMessage.Data) || message.Data["tombola_id"] != "4" || message.Data["type"] != "tombola.drawn" {
+		t.Errorf("data = %v, want %v", message.Data, testMessage.Data)
+	}
+}
+
+func TestFCMAccessTokenIsCached(t *testing.T) {
+	provider, google := newTestProvider(t)
+
+	for i := 0; i < 3; i++ {
+		if err := provider.Send(context.Background(), deviceToken, testMessage); err != nil {
+			t.Fatalf("Send %d: %v", i, err)
+		}
+	}
+
+	if got := google.tokenRequests.Load(); got != 1 {
+		t.Errorf("token requests = %d, want 1", got)
+	}
+	if got := google.lastSend.Header.Get("Authorization"); got != "Bearer access-1" {
+		t.Errorf("Authorization = %q, want the cached token", got)
+	}
+}
+
+func TestFCMAccessTokenIsRefreshed(t *testing.T) {
+	provider, google := newTestProvider(t)
+	// a token expiring within the margin is renewed on the next send
+	google.expiresIn = int(tokenMargin.Seconds()) / 2
+
+	for i := 1; i <= 2; i++ {
+		if err := provider.Send(context.Background(), deviceToken, testMessage); err != nil {
+			t.Fatalf("Send %d: %v", i, err)
+		}
+		want := fmt.Sprintf("Bearer access-%d", i)
+		if got := google.lastSend.Header.Get("Authorization"); got != want {
+			t.Errorf("send %d: Authorization = %q, want %q", i, got, want)
+		}
+	}
+	if got := google.tokenRequests.Load(); got != 2 {
+		t.Errorf("token requests = %d, want 2", got)
+	}
+}
+
+func TestFCMTokenEndpointError(t *testing.T) {
+	provider, google := newTestProvider(t)
+	provider.serviceAccount.TokenUri = google.server.URL + "/missing"
+
+	err := provider.Send(context.Background(), deviceToken, testMessage)
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("got %v, want the status of the token endpoint", err)
+	}
+	if google.lastSend != nil {
+		t.Error("a message was sent without an access token")
+	}
+}
+
+func TestFCMSendErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		status      int
+		body        string
+		wantInvalid bool
+		wantMessage string
+	}{
+		{
+			name:        "unregistered token",
+			status:      http.StatusNotFound,
+			body:        `{"error": {"code": 404, "message": "Requested entity was not found.", "status": "NOT_FOUND", "details": [{"@type": "type.googleapis.com/google.firebase.fcm.v1.FcmError", "errorCode": "UNREGISTERED"}]}}`,
+			wantInvalid: true,
+		},
+		{
+			name:        "other fcm error",
+			status:      http.StatusBadRequest,
+			body:        `{"error": {"code": 400, "message": "Invalid registration token", "status": "INVALID_ARGUMENT", "details": [{"errorCode": "INVALID_ARGUMENT"}]}}`,
+			wantMessage: "fcm error INVALID_ARGUMENT: Invalid registration token",
+		},
+		{
+			name:        "html error page",
+			status:      http.StatusBadGateway,
+			body:        "<html><body>502 Bad Gateway</body></html>",
+			wantMessage: "fcm responded with status 502",
+		},
+		{
+			name:        "empty error body",
+			status:      http.StatusServiceUnavailable,
+			body:        "",
+			wantMessage: "fcm responded with status 503",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			provider, google := newTestProvider(t)
+			google.sendStatus = test.status
+			google.sendBody = test.body
+
+			err := provider.Send(context.Background(), deviceToken, testMessage)
+			if got := goErrors.Is(err, ErrInvalidToken); got != test.wantInvalid {
+				t.Fatalf("got %v, want invalid token %v", err, test.wantInvalid)
+			}
+			if test.wantMessage != "" && (err == nil || err.Error() != test.wantMessage) {
+				t.Errorf("got %v, want %q", err, test.wantMessage)
+			}
+		})
+	}
+}
+
+func TestNewFCMProviderInvalidCredentials(t *testing.T) {
+	tests := []struct {
+		name        string
+		credentials string
+	}{
+		{"not json", "project_id=kermesse-project"},
+		{"missing fields", `{"project_id": "kermesse-project"}`},
+		{"invalid key", `{"project_id": "p", "client_email": "e", "token_uri": "u", "private_key": "not a key"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			credentialsFile := filepath.Join(t.TempDir(), "credentials.json")
+			if err := os.WriteFile(credentialsFile, []byte(test.credentials), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := NewFCMProvider("", credentialsFile); err == nil {
+				t.Error("got no error")
+			}
+		})
+	}
+}
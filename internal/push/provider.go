@@ -0,0 +1,23 @@
+package push
+
+import (
+	"context"
+	goErrors "errors"
+)
+
+// ErrInvalidToken is returned by a provider when the device token is no longer
+// registered, the token should then be forgotten.
+var ErrInvalidToken = goErrors.New("device token is not registered")
+
+type Message struct {
+	Title string
+	Body  string
+	// Data is handed to the app along with the notification, it holds the
+	// event type and the ids needed to open the right screen.
+	Data map[string]string
+}
+
+// Provider delivers a push notification to a single device.
+type Provider interface {
+	Send(ctx context.Context, token string, message Message) error
+}
@@ -0,0 +1,54 @@
+package push
+
+import (
+	"github.com/jmoiron/sqlx"
+	"github.com/kermesse-backend/internal/types"
+)
+
+type DeviceTokensRepository interface {
+	GetDeviceTokensByUserId(userId int) ([]types.DeviceToken, error)
+	AddDeviceToken(input map[string]interface{}) error
+	DeleteDeviceToken(userId int, token string) error
+	DeleteToken(token string) error
+}
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewDeviceTokensRepository(db *sqlx.DB) *Repository {
+	return &Repository{
+		db: db,
+	}
+}
+
+func (repository *Repository) GetDeviceTokensByUserId(userId int) ([]types.DeviceToken, error) {
+	var tokens []types.DeviceToken
+	query := "SELECT * FROM device_tokens WHERE user_id=$1"
+	err := repository.db.Select(&tokens, query, userId)
+	return tokens, err
+}
+
+// AddDeviceToken registers the token for the user, a token already registered
+// by another user, after a logout on a shared device, is moved to this one.
+func (repository *Repository) AddDeviceToken(input map[string]interface{}) error {
+	query := `
+		INSERT INTO device_tokens (user_id, token, platform)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (token) DO UPDATE SET user_id = EXCLUDED.user_id, platform = EXCLUDED.platform
+	`
+	_, err := repository.db.Exec(query, input["user_id"], input["token"], input["platform"])
+	return err
+}
+
+func (repository *Repository) DeleteDeviceToken(userId int, token string) error {
+	query := "DELETE FROM device_tokens WHERE user_id=$1 AND token=$2"
+	_, err := repository.db.Exec(query, userId, token)
+	return err
+}
+
+func (repository *Repository) DeleteToken(token string) error {
+	query := "DELETE FROM device_tokens WHERE token=$1"
+	_, err := repository.db.Exec(query, token)
+	return err
+}
@@ -0,0 +1,115 @@
+package push
+
+import (
+	"context"
+	goErrors "errors"
+	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/pkg/errors"
+	"log"
+	"time"
+)
+
+type PushService interface {
+	RegisterDevice(ctx context.Context, input map[string]interface{}) error
+	UnregisterDevice(ctx context.Context, token string) error
+}
+
+// sendTimeout bounds the delivery of a notification to all the devices of a
+// user.
+const sendTimeout = 10 * time.Second
+
+type Service struct {
+	deviceTokensRepository DeviceTokensRepository
+	provider               Provider
+}
+
+func NewPushService(deviceTokensRepository DeviceTokensRepository, provider Provider) *Service {
+	return &Service{
+		deviceTokensRepository: deviceTokensRepository,
+		provider:               provider,
+	}
+}
+
+func (service *Service) RegisterDevice(ctx context.Context, input map[string]interface{}) error {
+	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
+	if !ok {
+		return errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("user ID not found"),
+		}
+	}
+
+	token, ok := input["token"].(string)
+	if !ok || token == "" {
+		return errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("token is required"),
+		}
+	}
+	platform, _ := input["platform"].(string)
+	if platform != types.DevicePlatformAndroid && platform != types.DevicePlatformIos && platform != types.DevicePlatformWeb {
+		return errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("platform must be ANDROID, IOS or WEB"),
+		}
+	}
+
+	err := service.deviceTokensRepository.AddDeviceToken(map[string]interface{}{
+		"user_id":  userId,
+		"token":    token,
+		"platform": platform,
+	})
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (service *Service) UnregisterDevice(ctx context.Context, token string) error {
+	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
+	if !ok {
+		return errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("user ID not found"),
+		}
+	}
+
+	err := service.deviceTokensRepository.DeleteDeviceToken(userId, token)
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+// NotifyUser pushes the message to every device of the user in the
+// background. Tokens rejected by the provider are forgotten.
+func (service *Service) NotifyUser(userId int, message Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		defer cancel()
+
+		tokens, err := service.deviceTokensRepository.GetDeviceTokensByUserId(userId)
+		if err != nil {
+			log.Printf("Unable to get devices of user %d: %v", userId, err)
+			return
+		}
+		for _, device := range tokens {
+			err := service.provider.Send(ctx, device.Token, message)
+			if goErrors.Is(err, ErrInvalidToken) {
+				if err := service.deviceTokensRepository.DeleteToken(device.Token); err != nil {
+					log.Printf("Unable to delete device token %d: %v", device.Id, err)
+				}
+				continue
+			}
+			if err != nil {
+				log.Printf("Unable to push to device %d of user %d: %v", device.Id, userId, err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,68 @@
+package ratelimit
+
+import (
+	"github.com/jmoiron/sqlx"
+	"log"
+	"sync"
+	"time"
+)
+
+// PostgresStore keeps the buckets in the database, for every instance
+// connected to it to share the same limits.
+type PostgresStore struct {
+	db        *sqlx.DB
+	mutex     sync.Mutex
+	lastSweep time.Time
+}
+
+func NewPostgresStore(db *sqlx.DB) *PostgresStore {
+	return &PostgresStore{
+		db:        db,
+		lastSweep: time.Now(),
+	}
+}
+
+// Take refills and takes a token from the bucket in a single statement, so
+// that concurrent requests of several instances cannot take the same token.
+func (store *PostgresStore) Take(key string, limit Limit) (Result, error) {
+	store.sweep()
+
+	var bucket struct {
+		Tokens  float64 `db:"tokens"`
+		Allowed bool    `db:"allowed"`
+	}
+	query := `
+		INSERT INTO rate_limit_buckets (key, tokens, allowed, updated_at, full_at)
+		VALUES ($1, $2 - 1, TRUE, NOW(), NOW() + $4 * INTERVAL '1 second')
+		ON CONFLICT (key) DO UPDATE
+		SET tokens = CASE
+				WHEN LEAST($2, rate_limit_buckets.tokens + EXTRACT(EPOCH FROM NOW() - rate_limit_buckets.updated_at) * $3) >= 1
+				THEN LEAST($2, rate_limit_buckets.tokens + EXTRACT(EPOCH FROM NOW() - rate_limit_buckets.updated_at) * $3) - 1
+				ELSE LEAST($2, rate_limit_buckets.tokens + EXTRACT(EPOCH FROM NOW() - rate_limit_buckets.updated_at) * $3)
+			END,
+			allowed = LEAST($2, rate_limit_buckets.tokens + EXTRACT(EPOCH FROM NOW() - rate_limit_buckets.updated_at) * $3) >= 1,
+			updated_at = NOW(),
+			full_at = NOW() + $4 * INTERVAL '1 second'
+		RETURNING tokens, allowed
+	`
+	err := store.db.Get(&bucket, query, key, limit.Requests, limit.rate(), limit.Period.Seconds())
+	if err != nil {
+		return Result{}, err
+	}
+	return newResult(bucket.Allowed, bucket.Tokens, limit), nil
+}
+
+// sweep removes the buckets left idle long enough to be full again, at most
+// once per sweepInterval on each instance.
+func (store *PostgresStore) sweep() {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if time.Since(store.lastSweep) < sweepInterval {
+		return
+	}
+	store.lastSweep = time.Now()
+	if _, err := store.db.Exec("DELETE FROM rate_limit_buckets WHERE full_at < NOW()"); err != nil {
+		log.Printf("Error deleting idle rate limit buckets: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket: it holds Requests tokens and refills them all over
+// Period, each request takes one token.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// rate is the number of tokens refilled per second.
+func (limit Limit) rate() float64 {
+	return float64(limit.Requests) / limit.Period.Seconds()
+}
+
+// Result is the state of a bucket once a request took, or failed to take, a
+// token from it. Reset is the time left before the bucket is full again and
+// RetryAfter the time left before a refused request may be retried.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+// Store keeps the buckets, each key has its own.
+type Store interface {
+	Take(key string, limit Limit) (Result, error)
+}
+
+// newResult describes the bucket holding tokens once the request was handled.
+func newResult(allowed bool, tokens float64, limit Limit) Result {
+	rate := limit.rate()
+	result := Result{
+		Allowed:   allowed,
+		Limit:     limit.Requests,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((float64(limit.Requests) - tokens) / rate * float64(time.Second)),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	return result
+}
+
+// sweepInterval is how often the buckets left idle long enough to be full
+// again are removed.
+const sweepInterval = time.Minute
+
+// MemoryStore keeps the buckets in the current process, it fits
+// single-instance runs.
+type MemoryStore struct {
+	mutex     sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+	fullAt    time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (store *MemoryStore) Take(key string, limit Limit) (Result, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(store.lastSweep) > sweepInterval {
+		for bucketKey, idle := range store.buckets {
+			if now.After(idle.fullAt) {
+				delete(store.buckets, bucketKey)
+			}
+		}
+		store.lastSweep = now
+	}
+
+	current, exists := store.buckets[key]
+	if !exists {
+		current = &bucket{tokens: float64(limit.Requests), updatedAt: now}
+		store.buckets[key] = current
+	}
+	current.tokens = math.Min(float64(limit.Requests), current.tokens+now.Sub(current.updatedAt).Seconds()*limit.rate())
+	current.updatedAt = now
+
+	allowed := current.tokens >= 1
+	if allowed {
+		current.tokens--
+	}
+	current.fullAt = now.Add(limit.Period)
+	return newResult(allowed, current.tokens, limit), nil
+}
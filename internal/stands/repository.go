@@ -1,9 +1,12 @@
 package stands
 
 import (
+	"database/sql"
+	goErrors "errors"
 	"fmt"
 	"github.com/jmoiron/sqlx"
 	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/pkg/utils"
 	"strings"
 )
 
@@ -13,10 +16,18 @@ type StandsRepository interface {
 	AddStand(input map[string]interface{}) error
 	ModifyStand(id int, input map[string]interface{}) error
 	AdjustStock(id int, quantity int) error
+	ReserveStock(id int, quantity int, record func() error) error
 	GetStandByUserId(userId int) (types.Stand, error)
-	UpdateStandByStandHolderId(userId int, input map[string]interface{}) error
+	UpdateStandByStandHolderId(userId int, version int, input map[string]interface{}) error
+	GetLatestKermesseId(standId int) (int, error)
+	DeleteStand(id int) error
 }
 
+var (
+	ErrStandInUse        = goErrors.New("stand is linked to a kermesse in progress")
+	ErrInsufficientStock = goErrors.New("insufficient stock")
+)
+
 type Repository struct {
 	db *sqlx.DB
 }
@@ -37,10 +48,13 @@ func (repository *Repository) GetAllStands(filters map[string]interface{}) ([]ty
 			s.price AS price,
 			s.stock AS stock,
 			s.description AS description,
-			s.category AS category
+			s.category AS category,
+			s.created_at AS created_at,
+			s.updated_at AS updated_at,
+			s.version AS version
 		FROM stands s
 		LEFT JOIN kermesses_stands ks ON ks.stand_id = s.id
-		WHERE 1=1 AND s.id IS NOT NULL
+		WHERE s.id IS NOT NULL AND s.deleted_at IS NULL
 	`
 
 	var conditions []string
@@ -52,7 +66,7 @@ func (repository *Repository) GetAllStands(filters map[string]interface{}) ([]ty
 					SELECT ks_inner.stand_id 
 					FROM kermesses_stands ks_inner
 					JOIN kermesses k ON ks_inner.kermesse_id = k.id
-					WHERE k.status = 'STARTED'
+					WHERE k.status = 'STARTED' AND k.deleted_at IS NULL
 				)
 			)
 		`)
@@ -72,7 +86,7 @@ func (repository *Repository) GetAllStands(filters map[string]interface{}) ([]ty
 
 func (repository *Repository) GetStandById(id int) (types.Stand, error) {
 	var stand types.Stand
-	query := "SELECT * FROM stands WHERE id=$1"
+	query := "SELECT * FROM stands WHERE id=$1 AND deleted_at IS NULL"
 	err := repository.db.Get(&stand, query, id)
 	return stand, err
 }
@@ -89,10 +103,39 @@ func (repository *Repository) AdjustStock(id int, quantity int) error {
 	return err
 }
 
-func (repository *Repository) UpdateStandByStandHolderId(userId int, input map[string]interface{}) error {
-	query := "UPDATE stands SET name=$1, price=$2, stock=$3, description=$4 WHERE user_id=$5"
-	_, err := repository.db.Exec(query, input["name"], input["price"], input["stock"], input["description"], userId)
-	return err
+// ReserveStock takes the quantity from the stock of the stand, or returns
+// ErrInsufficientStock, and calls record before committing. The reservation
+// is only kept when record succeeds, and the stand stays locked meanwhile so
+// that concurrent reservations cannot oversell it. The update leaves the keys
+// of the stand alone, the purchase request inserted by record on another
+// connection can still reference it.
+func (repository *Repository) ReserveStock(id int, quantity int, record func() error) (err error) {
+	tx, err := repository.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	query := "UPDATE stands SET stock = stock - $1 WHERE id = $2 AND stock >= $1"
+	err = utils.ExecSingleRow(tx, ErrInsufficientStock, query, quantity, id)
+	if err != nil {
+		return err
+	}
+	return record()
+}
+
+// UpdateStandByStandHolderId updates the stand of the stand holder if it is
+// still at the version, it returns sql.ErrNoRows when it was changed in the
+// meantime.
+func (repository *Repository) UpdateStandByStandHolderId(userId int, version int, input map[string]interface{}) error {
+	query := "UPDATE stands SET name=$1, price=$2, stock=$3, description=$4 WHERE user_id=$5 AND version=$6 AND deleted_at IS NULL"
+	return utils.ExecSingleRow(repository.db, sql.ErrNoRows, query, input["name"], input["price"], input["stock"], input["description"], userId, version)
 }
 
 func (repository *Repository) AddStand(input map[string]interface{}) error {
@@ -103,7 +146,30 @@ func (repository *Repository) AddStand(input map[string]interface{}) error {
 
 func (repository *Repository) GetStandByUserId(userId int) (types.Stand, error) {
 	stand := types.Stand{}
-	query := "SELECT * FROM stands WHERE user_id=$1 LIMIT 1"
+	query := "SELECT * FROM stands WHERE user_id=$1 AND deleted_at IS NULL LIMIT 1"
 	err := repository.db.Get(&stand, query, userId)
 	return stand, err
 }
+
+// GetLatestKermesseId returns the last kermesse the stand was linked to, it
+// returns sql.ErrNoRows when the stand was never linked.
+func (repository *Repository) GetLatestKermesseId(standId int) (int, error) {
+	var kermesseId int
+	query := "SELECT kermesse_id FROM kermesses_stands WHERE stand_id=$1 ORDER BY kermesse_id DESC LIMIT 1"
+	err := repository.db.Get(&kermesseId, query, standId)
+	return kermesseId, err
+}
+
+// DeleteStand soft deletes the stand, it returns ErrStandInUse while the stand
+// is linked to a started kermesse.
+func (repository *Repository) DeleteStand(id int) error {
+	query := `
+		UPDATE stands SET deleted_at=NOW()
+		WHERE id=$1 AND deleted_at IS NULL
+		AND NOT EXISTS (
+			SELECT 1 FROM kermesses_stands ks JOIN kermesses k ON k.id = ks.kermesse_id
+			WHERE ks.stand_id = $1 AND k.status = 'STARTED' AND k.deleted_at IS NULL
+		)
+	`
+	return utils.ExecSingleRow(repository.db, ErrStandInUse, query, id)
+}
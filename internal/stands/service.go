@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	goErrors "errors"
+	"github.com/kermesse-backend/internal/audit"
 	"github.com/kermesse-backend/internal/types"
 	"github.com/kermesse-backend/pkg/errors"
 )
@@ -12,17 +13,20 @@ type StandsService interface {
 	GetAllStands(params map[string]interface{}) ([]types.Stand, error)
 	GetStandById(id int) (types.Stand, error)
 	AddStand(ctx context.Context, input map[string]interface{}) error
-	ModifyStand(ctx context.Context, input map[string]interface{}) error
+	ModifyStand(ctx context.Context, version int, input map[string]interface{}) error
 	GetOwnStand(ctx context.Context) (types.Stand, error)
+	DeleteOwnStand(ctx context.Context) error
 }
 
 type Service struct {
 	standsRepository StandsRepository
+	auditService     *audit.Service
 }
 
-func NewStandsService(standsRepository StandsRepository) *Service {
+func NewStandsService(standsRepository StandsRepository, auditService *audit.Service) *Service {
 	return &Service{
 		standsRepository: standsRepository,
+		auditService:     auditService,
 	}
 }
 
@@ -86,7 +90,9 @@ func (service *Service) AddStand(ctx context.Context, input map[string]interface
 	return nil
 }
 
-func (service *Service) ModifyStand(ctx context.Context, input map[string]interface{}) error {
+// ModifyStand changes the stand of the stand holder. A version other than 0
+// must be the current one, the update is refused with a conflict otherwise.
+func (service *Service) ModifyStand(ctx context.Context, version int, input map[string]interface{}) error {
 
 	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
 	if !ok {
@@ -96,13 +102,103 @@ func (service *Service) ModifyStand(ctx context.Context, input map[string]interf
 		}
 	}
 
-	err := service.standsRepository.UpdateStandByStandHolderId(userId, input)
+	stand, err := service.standsRepository.GetStandByUserId(userId)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return errors.CustomError{
+				Key: errors.NotFound,
+				Err: err,
+			}
+		}
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	if version != 0 && version != stand.Version {
+		return errors.CustomError{
+			Key: errors.Conflict,
+			Err: goErrors.New("stand was modified since it was read"),
+		}
+	}
+
+	err = service.standsRepository.UpdateStandByStandHolderId(userId, stand.Version, input)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return errors.CustomError{
+				Key: errors.Conflict,
+				Err: goErrors.New("stand was modified since it was read"),
+			}
+		}
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	if modified, err := service.standsRepository.GetStandById(stand.Id); err == nil {
+		// a stand not linked yet is only visible to the administrators
+		kermesseId, _ := service.standsRepository.GetLatestKermesseId(stand.Id)
+		service.auditService.Record(ctx, audit.Entry{
+			Action:     types.AuditActionStandUpdated,
+			TargetType: types.AuditTargetStand,
+			TargetId:   stand.Id,
+			KermesseId: kermesseId,
+			Before:     stand,
+			After:      modified,
+		})
+	}
+	return nil
+}
+
+// DeleteOwnStand soft deletes the stand of the stand holder, which is then free
+// to open another one. It is refused while a kermesse using it is in progress.
+func (service *Service) DeleteOwnStand(ctx context.Context) error {
+	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
+	if !ok {
+		return errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("user id not found"),
+		}
+	}
+
+	stand, err := service.standsRepository.GetStandByUserId(userId)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return errors.CustomError{
+				Key: errors.NotFound,
+				Err: err,
+			}
+		}
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	kermesseId, _ := service.standsRepository.GetLatestKermesseId(stand.Id)
+	err = service.standsRepository.DeleteStand(stand.Id)
 	if err != nil {
+		if goErrors.Is(err, ErrStandInUse) {
+			return errors.CustomError{
+				Key: errors.BadRequest,
+				Err: err,
+			}
+		}
 		return errors.CustomError{
 			Key: errors.InternalServerError,
 			Err: err,
 		}
 	}
+
+	service.auditService.Record(ctx, audit.Entry{
+		Action:     types.AuditActionStandDeleted,
+		TargetType: types.AuditTargetStand,
+		TargetId:   stand.Id,
+		KermesseId: kermesseId,
+		Before:     stand,
+	})
 	return nil
 }
 
@@ -1,19 +1,38 @@
 package tickets
 
 import (
+	"database/sql"
+	goErrors "errors"
 	"fmt"
 	"github.com/jmoiron/sqlx"
+	"github.com/kermesse-backend/internal/approvals"
+	"github.com/kermesse-backend/internal/limits"
+	"github.com/kermesse-backend/internal/notifications"
 	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/internal/webhooks"
+	"github.com/kermesse-backend/pkg/utils"
 	"strings"
 )
 
 type TicketRepository interface {
 	GetAllTickets(filters map[string]interface{}) ([]types.TicketCompleteModel, error)
 	GetTicketById(id int) (types.TicketCompleteModel, error)
-	AddTicket(input map[string]interface{}) error
+	PurchaseTickets(input map[string]interface{}, newEvent func(numbers []int) notifications.Event) ([]int, error)
+	ReserveTickets(input map[string]interface{}, record func() error) error
 	IsEligibleForTicketCreation(input map[string]interface{}) (bool, error)
+	GetTicketByPickupCode(code string) (types.TicketCompleteModel, error)
+	ClaimPrize(id int) error
+	DeliverPrize(id int, event notifications.Event) error
+	ExpireClaims() error
 }
 
+var (
+	ErrTombolaNotStarted   = goErrors.New("tombola is not active or has ended")
+	ErrTombolaSoldOut      = goErrors.New("not enough tickets left in this tombola")
+	ErrStudentTicketLimit  = goErrors.New("ticket limit per student reached for this tombola")
+	ErrInsufficientBalance = goErrors.New("insufficient balance")
+)
+
 type Repository struct {
 	db *sqlx.DB
 }
@@ -42,12 +61,26 @@ func (repository *Repository) GetAllTickets(filters map[string]interface{}) ([]t
 			t.price AS "tombola.price",
 			t.prize AS "tombola.prize",
 			ticket.id AS id,
-			ticket.is_winner AS is_winner
+			ticket.number AS number,
+			ticket.price AS price,
+			ticket.buyer_id AS buyer_id,
+			ticket.is_winner AS is_winner,
+			tp.id AS prize_id,
+			tp.name AS prize_name,
+			tp.rank AS prize_rank,
+			ticket.claim_status AS claim_status,
+			ticket.pickup_code AS pickup_code,
+			ticket.claim_expires_at AS claim_expires_at,
+			ticket.claimed_at AS claimed_at,
+			ticket.delivered_at AS delivered_at,
+			ticket.created_at AS created_at,
+			ticket.updated_at AS updated_at
 		FROM tickets ticket
 		JOIN users u ON ticket.user_id = u.id
 		JOIN tombolas t ON ticket.tombola_id = t.id
 		JOIN kermesses k ON t.kermesse_id = k.id
-		WHERE 1=1
+		LEFT JOIN tombola_prizes tp ON ticket.prize_id = tp.id
+		WHERE ticket.deleted_at IS NULL
 	`
 
 	var conditions []string
@@ -58,7 +91,13 @@ func (repository *Repository) GetAllTickets(filters map[string]interface{}) ([]t
 		conditions = append(conditions, fmt.Sprintf("ticket.user_id IS NOT NULL AND ticket.user_id = %v", studentId))
 	}
 	if parentId, ok := filters["parent_id"]; ok {
-		conditions = append(conditions, fmt.Sprintf("u.parent_id IS NOT NULL AND u.parent_id = %v", parentId))
+		conditions = append(conditions, fmt.Sprintf("u.id IN (SELECT student_id FROM student_guardians WHERE guardian_id = %v)", parentId))
+	}
+	if kermesseId, ok := filters["kermesse_id"]; ok {
+		conditions = append(conditions, fmt.Sprintf("k.id = %v", kermesseId))
+	}
+	if _, ok := filters["unclaimed"]; ok {
+		conditions = append(conditions, "ticket.claim_status IN ('WON', 'CLAIMED', 'EXPIRED')")
 	}
 
 	if len(conditions) > 0 {
@@ -73,7 +112,20 @@ func (repository *Repository) GetTicketById(id int) (types.TicketCompleteModel,
 	query := `
 		SELECT
 			ticket.id AS id,
+			ticket.number AS number,
+			ticket.price AS price,
+			ticket.buyer_id AS buyer_id,
 			ticket.is_winner AS is_winner,
+			tp.id AS prize_id,
+			tp.name AS prize_name,
+			tp.rank AS prize_rank,
+			ticket.claim_status AS claim_status,
+			ticket.pickup_code AS pickup_code,
+			ticket.claim_expires_at AS claim_expires_at,
+			ticket.claimed_at AS claimed_at,
+			ticket.delivered_at AS delivered_at,
+			ticket.created_at AS created_at,
+			ticket.updated_at AS updated_at,
 			t.id AS "tombola.id",
 			t.name AS "tombola.name",
 			t.prize AS "tombola.prize",
@@ -91,12 +143,62 @@ func (repository *Repository) GetTicketById(id int) (types.TicketCompleteModel,
 		JOIN tombolas t ON ticket.tombola_id = t.id
 		JOIN kermesses k ON t.kermesse_id = k.id
 		JOIN users u ON ticket.user_id = u.id
-		WHERE ticket.id=$1
+		LEFT JOIN tombola_prizes tp ON ticket.prize_id = tp.id
+		WHERE ticket.id=$1 AND ticket.deleted_at IS NULL
 	`
 	err := repository.db.Get(&ticket, query, id)
 	return ticket, err
 }
 
+func (repository *Repository) GetTicketByPickupCode(code string) (types.TicketCompleteModel, error) {
+	var id int
+	query := "SELECT id FROM tickets WHERE pickup_code=$1 AND deleted_at IS NULL"
+	if err := repository.db.Get(&id, query, code); err != nil {
+		return types.TicketCompleteModel{}, err
+	}
+	return repository.GetTicketById(id)
+}
+
+func (repository *Repository) ClaimPrize(id int) error {
+	query := `
+		UPDATE tickets
+		SET claim_status='CLAIMED', claimed_at=NOW()
+		WHERE id=$1 AND claim_status='WON' AND claim_expires_at > NOW()
+	`
+	return utils.ExecSingleRow(repository.db, sql.ErrNoRows, query, id)
+}
+
+// DeliverPrize marks a claimed prize as delivered and queues the webhook
+// deliveries of the event in the same transaction.
+func (repository *Repository) DeliverPrize(id int, event notifications.Event) (err error) {
+	tx, err := repository.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	query := "UPDATE tickets SET claim_status='DELIVERED', delivered_at=NOW() WHERE id=$1 AND claim_status='CLAIMED'"
+	err = utils.ExecSingleRow(tx, sql.ErrNoRows, query, id)
+	if err != nil {
+		return err
+	}
+	return webhooks.QueueEvent(tx, event)
+}
+
+// ExpireClaims marks the prizes that were not delivered before the end of
+// their claim period as expired.
+func (repository *Repository) ExpireClaims() error {
+	query := "UPDATE tickets SET claim_status='EXPIRED' WHERE claim_status IN ('WON', 'CLAIMED') AND claim_expires_at <= NOW()"
+	_, err := repository.db.Exec(query)
+	return err
+}
+
 func (repository *Repository) IsEligibleForTicketCreation(input map[string]interface{}) (bool, error) {
 	var isEligible bool
 	query := `
@@ -104,15 +206,176 @@ func (repository *Repository) IsEligibleForTicketCreation(input map[string]inter
 			SELECT 1
 			FROM kermesses_users ku
 			JOIN kermesses k ON k.id = ku.kermesse_id
-			WHERE ku.kermesse_id = $1 AND ku.user_id = $2 AND k.status = 'STARTED'
+			WHERE ku.kermesse_id = $1 AND ku.user_id = $2 AND k.status = 'STARTED' AND k.deleted_at IS NULL
 		) AS is_eligible
 	`
 	err := repository.db.QueryRow(query, input["kermesse_id"], input["user_id"]).Scan(&isEligible)
 	return isEligible, err
 }
 
-func (repository *Repository) AddTicket(input map[string]interface{}) error {
-	query := "INSERT INTO tickets (user_id, tombola_id) VALUES ($1, $2)"
-	_, err := repository.db.Exec(query, input["user_id"], input["tombola_id"])
-	return err
+// tombolaLimits are the columns of a tombola a purchase is checked against.
+type tombolaLimits struct {
+	KermesseId           int    `db:"kermesse_id"`
+	Price                int    `db:"price"`
+	Status               string `db:"status"`
+	MaxTickets           *int   `db:"max_tickets"`
+	MaxTicketsPerStudent *int   `db:"max_tickets_per_student"`
+}
+
+// ReserveTickets checks that the tickets of a purchase request waiting for
+// approval are available, then runs record to store the request, which keeps
+// them aside until it is resolved. The tombola row stays locked meanwhile, so
+// that concurrent requests do not reserve the same tickets. The lock does not
+// block the key share lock taken by the foreign key of the request, which
+// record inserts on another connection.
+func (repository *Repository) ReserveTickets(input map[string]interface{}, record func() error) (err error) {
+	tx, err := repository.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var tombola tombolaLimits
+	query := "SELECT kermesse_id, price, status, max_tickets, max_tickets_per_student FROM tombolas WHERE id=$1 AND deleted_at IS NULL FOR NO KEY UPDATE"
+	err = tx.Get(&tombola, query, input["tombola_id"])
+	if err != nil {
+		return err
+	}
+	if tombola.Status != types.TombolaStatusStarted {
+		return ErrTombolaNotStarted
+	}
+	err = checkAvailability(tx, tombola, input)
+	if err != nil {
+		return err
+	}
+	return record()
+}
+
+// checkAvailability counts the tickets sold and the tickets reserved by the
+// purchase requests against the limits of the tombola. A request reserves its
+// tickets while it waits for approval and once approved until its purchase
+// is completed.
+func checkAvailability(queryer sqlx.Queryer, tombola tombolaLimits, input map[string]interface{}) error {
+	quantity := input["quantity"].(int)
+
+	if tombola.MaxTickets != nil && *tombola.MaxTickets > 0 {
+		var sold int
+		query := `
+			SELECT
+				(SELECT COUNT(*) FROM tickets WHERE tombola_id=$1) +
+				(SELECT COALESCE(SUM(quantity), 0) FROM purchase_requests WHERE tombola_id=$1 AND (status='PENDING' OR (status='APPROVED' AND completed_at IS NULL)))
+		`
+		err := sqlx.Get(queryer, &sold, query, input["tombola_id"])
+		if err != nil {
+			return err
+		}
+		if sold+quantity > *tombola.MaxTickets {
+			return ErrTombolaSoldOut
+		}
+	}
+
+	if tombola.MaxTicketsPerStudent != nil && *tombola.MaxTicketsPerStudent > 0 {
+		var owned int
+		query := `
+			SELECT
+				(SELECT COUNT(*) FROM tickets WHERE tombola_id=$1 AND user_id=$2) +
+				(SELECT COALESCE(SUM(quantity), 0) FROM purchase_requests WHERE tombola_id=$1 AND student_id=$2 AND (status='PENDING' OR (status='APPROVED' AND completed_at IS NULL)))
+		`
+		err := sqlx.Get(queryer, &owned, query, input["tombola_id"], input["user_id"])
+		if err != nil {
+			return err
+		}
+		if owned+quantity > *tombola.MaxTicketsPerStudent {
+			return ErrStudentTicketLimit
+		}
+	}
+	return nil
+}
+
+// PurchaseTickets issues input["quantity"] tickets of a tombola to a student and
+// debits the buyer, either the student or their parent, in a single
+// transaction. The tombola row is locked so that the ticket caps and the ticket
+// numbering stay consistent under concurrent purchases. When
+// input["check_limits"] is true, the spending limits of the buyer are checked
+// in the same transaction. The webhook deliveries of the event built by
+// newEvent from the ticket numbers are queued in the transaction as well. When
+// input["request_id"] is not zero, the approved purchase request is completed in
+// the transaction, its reservation is not counted against the purchase. It
+// returns the numbers of the issued tickets.
+func (repository *Repository) PurchaseTickets(input map[string]interface{}, newEvent func(numbers []int) notifications.Event) (numbers []int, err error) {
+	tx, err := repository.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var tombola tombolaLimits
+	query := "SELECT kermesse_id, price, status, max_tickets, max_tickets_per_student FROM tombolas WHERE id=$1 AND deleted_at IS NULL FOR UPDATE"
+	err = tx.Get(&tombola, query, input["tombola_id"])
+	if err != nil {
+		return nil, err
+	}
+	if tombola.Status != types.TombolaStatusStarted {
+		return nil, ErrTombolaNotStarted
+	}
+
+	if requestId, ok := input["request_id"].(int); ok && requestId != 0 {
+		err = approvals.CompleteRequest(tx, requestId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	quantity := input["quantity"].(int)
+	err = checkAvailability(tx, tombola, input)
+	if err != nil {
+		return nil, err
+	}
+
+	totalPrice := tombola.Price * quantity
+	if input["check_limits"] == true {
+		err = limits.CheckSpendingTx(tx, input["buyer_id"].(int), tombola.KermesseId, types.SpendingCategoryTombola, totalPrice)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = utils.ExecSingleRow(tx, ErrInsufficientBalance, "UPDATE users SET balance = balance - $1 WHERE id = $2 AND balance >= $1", totalPrice, input["buyer_id"])
+	if err != nil {
+		return nil, err
+	}
+
+	var lastNumber int
+	err = tx.Get(&lastNumber, "SELECT COALESCE(MAX(number), 0) FROM tickets WHERE tombola_id=$1", input["tombola_id"])
+	if err != nil {
+		return nil, err
+	}
+
+	query = "INSERT INTO tickets (user_id, buyer_id, tombola_id, number, price) VALUES ($1, $2, $3, $4, $5)"
+	for i := 1; i <= quantity; i++ {
+		number := lastNumber + i
+		_, err = tx.Exec(query, input["user_id"], input["buyer_id"], input["tombola_id"], number, tombola.Price)
+		if err != nil {
+			return nil, err
+		}
+		numbers = append(numbers, number)
+	}
+
+	err = webhooks.QueueEvent(tx, newEvent(numbers))
+	if err != nil {
+		return nil, err
+	}
+	return numbers, nil
 }
@@ -4,21 +4,27 @@ import (
 	"context"
 	"database/sql"
 	goErrors "errors"
-	"fmt"
+	"github.com/kermesse-backend/internal/approvals"
+	"github.com/kermesse-backend/internal/audit"
 	"github.com/kermesse-backend/internal/kermesses"
+	"github.com/kermesse-backend/internal/limits"
 	"github.com/kermesse-backend/internal/notifications"
+	"github.com/kermesse-backend/internal/policy"
 	"github.com/kermesse-backend/internal/tombolas"
 	"github.com/kermesse-backend/internal/types"
 	"github.com/kermesse-backend/internal/users"
 	"github.com/kermesse-backend/pkg/errors"
 	"github.com/kermesse-backend/pkg/utils"
-	"strconv"
+	"strings"
 )
 
 type TicketService interface {
 	GetAllTickets(ctx context.Context) ([]types.TicketCompleteModel, error)
-	GetTicketById(id int) (types.TicketCompleteModel, error)
-	CreateTicket(ctx context.Context, input map[string]interface{}) error
+	GetTicketById(ctx context.Context, id int) (types.TicketCompleteModel, error)
+	CreateTicket(ctx context.Context, input map[string]interface{}) (types.TicketPurchase, *types.PurchaseRequest, error)
+	ClaimPrize(ctx context.Context, id int) (types.TicketCompleteModel, error)
+	DeliverPrize(ctx context.Context, input map[string]interface{}) (types.TicketCompleteModel, error)
+	GetUnclaimedPrizes(ctx context.Context, kermesseId int) ([]types.TicketCompleteModel, error)
 }
 
 type Service struct {
@@ -26,14 +32,24 @@ type Service struct {
 	tombolasRepository tombolas.TombolaRepository
 	usersRepository    users.UsersRepository
 	kermesseRepository kermesses.KermessesRepository
+	hub                *notifications.Hub
+	limitsService      *limits.Service
+	approvalsService   *approvals.Service
+	policyService      policy.PolicyService
+	auditService       *audit.Service
 }
 
-func NewTicketsService(ticketsRepository TicketRepository, tombolasRepository tombolas.TombolaRepository, usersRepository users.UsersRepository, kermesseRepository kermesses.KermessesRepository) *Service {
+func NewTicketsService(ticketsRepository TicketRepository, tombolasRepository tombolas.TombolaRepository, usersRepository users.UsersRepository, kermesseRepository kermesses.KermessesRepository, hub *notifications.Hub, limitsService *limits.Service, approvalsService *approvals.Service, policyService policy.PolicyService, auditService *audit.Service) *Service {
 	return &Service{
 		ticketsRepository:  ticketsRepository,
 		tombolasRepository: tombolasRepository,
 		usersRepository:    usersRepository,
 		kermesseRepository: kermesseRepository,
+		hub:                hub,
+		limitsService:      limitsService,
+		approvalsService:   approvalsService,
+		policyService:      policyService,
+		auditService:       auditService,
 	}
 }
 
@@ -75,10 +91,19 @@ func (service *Service) GetAllTickets(ctx context.Context) ([]types.TicketComple
 		return []types.TicketCompleteModel{}, nil
 	}
 
+	// students and parents only get their own tickets, organizers never see pickup codes
+	for i := range tickets {
+		if userRole != types.UserRoleStudent && userRole != types.UserRoleParent {
+			tickets[i].PickupCode = nil
+		} else {
+			hideUnclaimedPickupCode(&tickets[i])
+		}
+	}
+
 	return tickets, nil
 }
 
-func (service *Service) GetTicketById(id int) (types.TicketCompleteModel, error) {
+func (service *Service) GetTicketById(ctx context.Context, id int) (types.TicketCompleteModel, error) {
 	ticket, err := service.ticketsRepository.GetTicketById(id)
 	if err != nil {
 		if goErrors.Is(err, sql.ErrNoRows) {
@@ -92,88 +117,221 @@ func (service *Service) GetTicketById(id int) (types.TicketCompleteModel, error)
 			Err: err,
 		}
 	}
+
+	if !service.isTicketHolder(ctx, ticket) {
+		ticket.PickupCode = nil
+	}
+	hideUnclaimedPickupCode(&ticket)
+
 	return ticket, nil
 }
 
-func (service *Service) CreateTicket(ctx context.Context, input map[string]interface{}) error {
+// CreateTicket buys the tickets right away, or returns the purchase request
+// waiting for the approval of the parent when a student buys above the
+// approval threshold.
+func (service *Service) CreateTicket(ctx context.Context, input map[string]interface{}) (types.TicketPurchase, *types.PurchaseRequest, error) {
 	tombolaId, err := utils.ConvertToInt(input, "tombola_id")
 	if err != nil {
-		return errors.CustomError{
+		return types.TicketPurchase{}, nil, errors.CustomError{
 			Key: errors.BadRequest,
 			Err: err,
 		}
 	}
+	quantity := 1
+	if _, exists := input["quantity"]; exists {
+		quantity, err = utils.ConvertToInt(input, "quantity")
+		if err != nil {
+			return types.TicketPurchase{}, nil, errors.CustomError{
+				Key: errors.BadRequest,
+				Err: err,
+			}
+		}
+	}
+	if quantity < 1 {
+		return types.TicketPurchase{}, nil, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("quantity must be at least 1"),
+		}
+	}
 	tombola, err := service.tombolasRepository.GetTombolaById(tombolaId)
 	if err != nil {
 		if goErrors.Is(err, sql.ErrNoRows) {
-			return errors.CustomError{
+			return types.TicketPurchase{}, nil, errors.CustomError{
 				Key: errors.NotFound,
 				Err: err,
 			}
 		}
-		return errors.CustomError{
+		return types.TicketPurchase{}, nil, errors.CustomError{
 			Key: errors.InternalServerError,
 			Err: err,
 		}
 	}
 	if tombola.Status != types.TombolaStatusStarted {
-		return errors.CustomError{
+		return types.TicketPurchase{}, nil, errors.CustomError{
 			Key: errors.BadRequest,
 			Err: goErrors.New("tombola is not active or has ended"),
 		}
 	}
 	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
 	if !ok {
-		return errors.CustomError{
+		return types.TicketPurchase{}, nil, errors.CustomError{
 			Key: errors.Unauthorized,
 			Err: goErrors.New("user ID not found in context"),
 		}
 	}
-	user, err := service.usersRepository.GetUserById(userId)
+	buyer, err := service.usersRepository.GetUserById(userId)
 	if err != nil {
 		if goErrors.Is(err, sql.ErrNoRows) {
-			return errors.CustomError{
+			return types.TicketPurchase{}, nil, errors.CustomError{
 				Key: errors.NotFound,
 				Err: err,
 			}
 		}
-		return errors.CustomError{
+		return types.TicketPurchase{}, nil, errors.CustomError{
 			Key: errors.InternalServerError,
 			Err: err,
 		}
 	}
-	if user.Balance < tombola.Price {
-		return errors.CustomError{
+
+	// a parent buys on behalf of one of their students, who owns the tickets
+	student := buyer
+	if buyer.Role == types.UserRoleParent {
+		studentId, err := utils.ConvertToInt(input, "student_id")
+		if err != nil {
+			return types.TicketPurchase{}, nil, errors.CustomError{
+				Key: errors.BadRequest,
+				Err: err,
+			}
+		}
+		student, err = service.usersRepository.GetUserById(studentId)
+		if err != nil {
+			if goErrors.Is(err, sql.ErrNoRows) {
+				return types.TicketPurchase{}, nil, errors.CustomError{
+					Key: errors.NotFound,
+					Err: err,
+				}
+			}
+			return types.TicketPurchase{}, nil, errors.CustomError{
+				Key: errors.InternalServerError,
+				Err: err,
+			}
+		}
+		if student.Role != types.UserRoleStudent {
+			return types.TicketPurchase{}, nil, errors.CustomError{
+				Key: errors.Forbidden,
+				Err: goErrors.New("not allowed"),
+			}
+		}
+		if err := service.policyService.Authorize(ctx, policy.StudentAct, policy.Resource{OwnerId: student.Id}); err != nil {
+			return types.TicketPurchase{}, nil, err
+		}
+	}
+
+	totalPrice := tombola.Price * quantity
+	if buyer.Balance < totalPrice {
+		return types.TicketPurchase{}, nil, errors.CustomError{
 			Key: errors.BadRequest,
 			Err: goErrors.New("insufficient balance"),
 		}
 	}
 
+	// parents are free to spend, the limits they set only apply to students
+	if buyer.Role == types.UserRoleStudent {
+		err = service.limitsService.CheckSpending(buyer.Id, tombola.KermesseId, types.SpendingCategoryTombola, totalPrice)
+		if err != nil {
+			return types.TicketPurchase{}, nil, err
+		}
+	}
+
 	canBeCreated, err := service.ticketsRepository.IsEligibleForTicketCreation(map[string]interface{}{
 		"kermesse_id": tombola.KermesseId,
-		"user_id":     userId,
+		"user_id":     student.Id,
 	})
 	if err != nil {
-		return errors.CustomError{
+		return types.TicketPurchase{}, nil, errors.CustomError{
 			Key: errors.InternalServerError,
 			Err: err,
 		}
 	}
 	if !canBeCreated {
-		return errors.CustomError{
+		return types.TicketPurchase{}, nil, errors.CustomError{
 			Key: errors.Forbidden,
 			Err: goErrors.New("not eligible to create ticket"),
 		}
 	}
-	err = service.usersRepository.AlterBalance(userId, -tombola.Price)
+
+	if buyer.Role == types.UserRoleStudent {
+		requiresApproval, err := service.limitsService.RequiresApproval(buyer.Id, totalPrice)
+		if err != nil {
+			return types.TicketPurchase{}, nil, err
+		}
+		if requiresApproval {
+			request, err := service.requestApproval(buyer, tombola, quantity, totalPrice)
+			if err != nil {
+				return types.TicketPurchase{}, nil, err
+			}
+			return types.TicketPurchase{}, &request, nil
+		}
+	}
+
+	purchase, err := service.purchaseTickets(tombola, student, buyer, quantity, 0)
+	if err != nil {
+		return types.TicketPurchase{}, nil, err
+	}
+	return purchase, nil, nil
+}
+
+// requestApproval keeps the tickets aside while the parent of the student
+// decides, the pending request counts as sold until it is resolved.
+func (service *Service) requestApproval(student types.User, tombola types.Tombola, quantity int, totalPrice int) (types.PurchaseRequest, error) {
+	var request types.PurchaseRequest
+	err := service.ticketsRepository.ReserveTickets(map[string]interface{}{
+		"tombola_id": tombola.Id,
+		"user_id":    student.Id,
+		"quantity":   quantity,
+	}, func() error {
+		var err error
+		request, err = service.approvalsService.RequestApproval(student, types.PurchaseRequest{
+			Kind:       types.PurchaseKindTicket,
+			KermesseId: tombola.KermesseId,
+			TombolaId:  &tombola.Id,
+			ItemName:   tombola.Name,
+			Quantity:   quantity,
+			Amount:     totalPrice,
+		})
+		return err
+	})
+	if err != nil {
+		// the errors of RequestApproval are already meant for the client
+		if _, ok := err.(errors.CustomError); ok {
+			return types.PurchaseRequest{}, err
+		}
+		if goErrors.Is(err, ErrTombolaNotStarted) || goErrors.Is(err, ErrTombolaSoldOut) || goErrors.Is(err, ErrStudentTicketLimit) {
+			return types.PurchaseRequest{}, errors.CustomError{
+				Key: errors.BadRequest,
+				Err: err,
+			}
+		}
+		return types.PurchaseRequest{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return request, nil
+}
+
+// CompletePurchase buys the tickets of a request approved by the parent, if
+// the spending limits of the student still allow it: other purchases may have
+// been made since the request.
+func (service *Service) CompletePurchase(request types.PurchaseRequest) error {
+	tombola, err := service.tombolasRepository.GetTombolaById(*request.TombolaId)
 	if err != nil {
 		return errors.CustomError{
 			Key: errors.InternalServerError,
 			Err: err,
 		}
 	}
-	input["user_id"] = userId
-	err = service.ticketsRepository.AddTicket(input)
+	student, err := service.usersRepository.GetUserById(request.StudentId)
 	if err != nil {
 		return errors.CustomError{
 			Key: errors.InternalServerError,
@@ -181,21 +339,329 @@ func (service *Service) CreateTicket(ctx context.Context, input map[string]inter
 		}
 	}
 
+	_, err = service.purchaseTickets(tombola, student, student, request.Quantity, request.Id)
+	return err
+}
+
+// ReleasePurchase has nothing to give back, the tickets of a request are only
+// reserved until it is completed, denied, expired or failed.
+func (service *Service) ReleasePurchase(request types.PurchaseRequest) error {
+	return nil
+}
+
+// purchaseTickets completes the purchase request requestId along with the
+// purchase, when it is not zero.
+func (service *Service) purchaseTickets(tombola types.Tombola, student types.User, buyer types.User, quantity int, requestId int) (types.TicketPurchase, error) {
 	kermesse, err := service.kermesseRepository.GetKermesseById(tombola.KermesseId)
 	if err != nil {
 		if goErrors.Is(err, sql.ErrNoRows) {
-			return errors.CustomError{
+			return types.TicketPurchase{}, errors.CustomError{
 				Key: errors.NotFound,
 				Err: err,
 			}
 		}
-		return errors.CustomError{
+		return types.TicketPurchase{}, errors.CustomError{
 			Key: errors.InternalServerError,
 			Err: err,
 		}
 	}
 
-	message := fmt.Sprintf("Student %s bought a ticket for %v tomola", user.Name, tombola.Name)
-	notifications.NotifyOrganizer(strconv.Itoa(kermesse.UserId), message)
-	return nil
+	totalPrice := tombola.Price * quantity
+	var event notifications.Event
+	numbers, err := service.ticketsRepository.PurchaseTickets(map[string]interface{}{
+		"tombola_id": tombola.Id,
+		"user_id":    student.Id,
+		"buyer_id":   buyer.Id,
+		"quantity":   quantity,
+		// parents are free to spend, the limits they set only apply to students
+		"check_limits": buyer.Role == types.UserRoleStudent,
+		"request_id":   requestId,
+	}, func(numbers []int) notifications.Event {
+		event = notifications.NewEvent(notifications.EventTicketSold, kermesse.Id, notifications.TicketSoldPayload{
+			TombolaId:   tombola.Id,
+			TombolaName: tombola.Name,
+			StudentId:   student.Id,
+			StudentName: student.Name,
+			BuyerId:     buyer.Id,
+			BuyerName:   buyer.Name,
+			Quantity:    quantity,
+			TotalPrice:  totalPrice,
+			Numbers:     numbers,
+		})
+		return event
+	})
+	if err != nil {
+		if _, ok := err.(errors.CustomError); ok {
+			return types.TicketPurchase{}, err
+		}
+		if goErrors.Is(err, ErrTombolaNotStarted) || goErrors.Is(err, ErrTombolaSoldOut) ||
+			goErrors.Is(err, ErrStudentTicketLimit) || goErrors.Is(err, ErrInsufficientBalance) ||
+			goErrors.Is(err, approvals.ErrNotApproved) {
+			return types.TicketPurchase{}, errors.CustomError{
+				Key: errors.BadRequest,
+				Err: err,
+			}
+		}
+		return types.TicketPurchase{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	service.hub.Notify([]int{kermesse.UserId}, event, notifications.TombolaTopic(tombola.Id))
+
+	return types.TicketPurchase{
+		TombolaId:  tombola.Id,
+		StudentId:  student.Id,
+		BuyerId:    buyer.Id,
+		Quantity:   quantity,
+		TotalPrice: totalPrice,
+		Numbers:    numbers,
+	}, nil
+}
+
+func (service *Service) ClaimPrize(ctx context.Context, id int) (types.TicketCompleteModel, error) {
+	if err := service.ticketsRepository.ExpireClaims(); err != nil {
+		return types.TicketCompleteModel{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	ticket, err := service.ticketsRepository.GetTicketById(id)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return ticket, errors.CustomError{
+				Key: errors.NotFound,
+				Err: err,
+			}
+		}
+		return ticket, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	if err := service.policyService.Authorize(ctx, policy.StudentAct, policy.Resource{OwnerId: ticket.User.Id}); err != nil {
+		return types.TicketCompleteModel{}, err
+	}
+
+	if ticket.ClaimStatus == nil {
+		return types.TicketCompleteModel{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("ticket is not a winning ticket"),
+		}
+	}
+	switch *ticket.ClaimStatus {
+	case types.PrizeClaimStatusClaimed:
+		return ticket, nil
+	case types.PrizeClaimStatusDelivered:
+		return types.TicketCompleteModel{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("prize has already been delivered"),
+		}
+	case types.PrizeClaimStatusExpired:
+		return types.TicketCompleteModel{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("claim period is over"),
+		}
+	}
+
+	err = service.ticketsRepository.ClaimPrize(id)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return types.TicketCompleteModel{}, errors.CustomError{
+				Key: errors.BadRequest,
+				Err: goErrors.New("prize cannot be claimed"),
+			}
+		}
+		return types.TicketCompleteModel{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	ticket, err = service.ticketsRepository.GetTicketById(id)
+	if err != nil {
+		return ticket, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return ticket, nil
+}
+
+func (service *Service) DeliverPrize(ctx context.Context, input map[string]interface{}) (types.TicketCompleteModel, error) {
+	code, ok := input["pickup_code"].(string)
+	if !ok || code == "" {
+		return types.TicketCompleteModel{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("pickup_code is required"),
+		}
+	}
+
+	if err := service.ticketsRepository.ExpireClaims(); err != nil {
+		return types.TicketCompleteModel{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	ticket, err := service.ticketsRepository.GetTicketByPickupCode(strings.ToUpper(code))
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return ticket, errors.CustomError{
+				Key: errors.NotFound,
+				Err: goErrors.New("unknown pickup code"),
+			}
+		}
+		return ticket, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	kermesse, err := service.kermesseRepository.GetKermesseById(ticket.Kermesse.Id)
+	if err != nil {
+		return types.TicketCompleteModel{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	if err := service.policyService.Authorize(ctx, policy.PrizeDeliver, policy.Resource{OwnerId: kermesse.UserId, KermesseId: kermesse.Id}); err != nil {
+		return types.TicketCompleteModel{}, err
+	}
+
+	claimStatus := *ticket.ClaimStatus
+	switch claimStatus {
+	case types.PrizeClaimStatusWon:
+		return types.TicketCompleteModel{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("prize has not been claimed yet"),
+		}
+	case types.PrizeClaimStatusDelivered:
+		return types.TicketCompleteModel{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("prize has already been delivered"),
+		}
+	case types.PrizeClaimStatusExpired:
+		return types.TicketCompleteModel{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("claim period is over"),
+		}
+	}
+
+	prizeName := ticket.Tombola.Prize
+	if ticket.PrizeName != nil {
+		prizeName = *ticket.PrizeName
+	}
+	event := notifications.NewEvent(notifications.EventPrizeDelivered, ticket.Kermesse.Id, notifications.PrizeDeliveredPayload{
+		TicketId:    ticket.Id,
+		TombolaId:   ticket.Tombola.Id,
+		TombolaName: ticket.Tombola.Name,
+		PrizeName:   prizeName,
+	})
+	err = service.ticketsRepository.DeliverPrize(ticket.Id, event)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return types.TicketCompleteModel{}, errors.CustomError{
+				Key: errors.BadRequest,
+				Err: goErrors.New("prize cannot be delivered"),
+			}
+		}
+		return types.TicketCompleteModel{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	ticket, err = service.ticketsRepository.GetTicketById(ticket.Id)
+	if err != nil {
+		return ticket, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	ticket.PickupCode = nil
+
+	service.auditService.Record(ctx, audit.Entry{
+		Action:     types.AuditActionPrizeDelivered,
+		TargetType: types.AuditTargetTicket,
+		TargetId:   ticket.Id,
+		KermesseId: kermesse.Id,
+		Before:     map[string]interface{}{"claim_status": claimStatus},
+		After:      map[string]interface{}{"claim_status": types.PrizeClaimStatusDelivered},
+		Details:    map[string]interface{}{"tombola_id": ticket.Tombola.Id, "student_id": ticket.User.Id, "prize_name": prizeName},
+	})
+	service.hub.NotifyUser(ticket.User.Id, event)
+
+	return ticket, nil
+}
+
+func (service *Service) GetUnclaimedPrizes(ctx context.Context, kermesseId int) ([]types.TicketCompleteModel, error) {
+	kermesse, err := service.kermesseRepository.GetKermesseById(kermesseId)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return nil, errors.CustomError{
+				Key: errors.NotFound,
+				Err: err,
+			}
+		}
+		return nil, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	if err := service.policyService.Authorize(ctx, policy.PrizeDeliver, policy.Resource{OwnerId: kermesse.UserId, KermesseId: kermesse.Id}); err != nil {
+		return nil, err
+	}
+
+	if err := service.ticketsRepository.ExpireClaims(); err != nil {
+		return nil, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	tickets, err := service.ticketsRepository.GetAllTickets(map[string]interface{}{
+		"kermesse_id": kermesseId,
+		"unclaimed":   true,
+	})
+	if err != nil {
+		return nil, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	if tickets == nil {
+		return []types.TicketCompleteModel{}, nil
+	}
+
+	for i := range tickets {
+		tickets[i].PickupCode = nil
+	}
+
+	return tickets, nil
+}
+
+// isTicketHolder reports whether the user in context owns the ticket or is a
+// guardian of its owner.
+func (service *Service) isTicketHolder(ctx context.Context, ticket types.TicketCompleteModel) bool {
+	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
+	if !ok {
+		return false
+	}
+	userRole, _ := ctx.Value(types.UserRoleSessionKey).(string)
+
+	isHolder, err := service.policyService.Can(types.User{Id: userId, Role: userRole}, policy.StudentView, policy.Resource{OwnerId: ticket.User.Id})
+	return err == nil && isHolder
+}
+
+// hideUnclaimedPickupCode only keeps the pickup code once the prize is claimed,
+// that is when the student is about to collect it.
+func hideUnclaimedPickupCode(ticket *types.TicketCompleteModel) {
+	if ticket.ClaimStatus == nil || *ticket.ClaimStatus != types.PrizeClaimStatusClaimed {
+		ticket.PickupCode = nil
+	}
 }
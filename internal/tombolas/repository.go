@@ -1,9 +1,17 @@
 package tombolas
 
 import (
+	"context"
+	"database/sql"
+	goErrors "errors"
 	"fmt"
 	"github.com/jmoiron/sqlx"
+	"github.com/kermesse-backend/internal/audit"
+	"github.com/kermesse-backend/internal/notifications"
 	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/internal/webhooks"
+	"github.com/kermesse-backend/pkg/generator"
+	"github.com/kermesse-backend/pkg/utils"
 	"strings"
 )
 
@@ -11,10 +19,25 @@ type TombolaRepository interface {
 	GetAllTombolas(filters map[string]interface{}) ([]types.Tombola, error)
 	GetTombolaById(id int) (types.Tombola, error)
 	AddTombola(input map[string]interface{}) error
-	ModifyTombola(id int, input map[string]interface{}) error
-	SelectWinner(id int) error
+	ModifyTombola(id int, version int, input map[string]interface{}) error
+	GetPrizesByTombolaId(id int) ([]types.TombolaPrize, error)
+	ReplacePrizes(id int, prizes []types.TombolaPrize) error
+	SelectWinner(id int, newEvents func(winners []types.Ticket) []notifications.Event) ([]types.Ticket, error)
+	Redraw(id int, event types.AuditEvent, newEvents func(winners []types.Ticket) []notifications.Event) ([]types.Ticket, error)
+	GetDueTombolas() ([]types.Tombola, error)
+	WithDrawLock(fn func() error) (bool, error)
+	DeleteTombola(id int) error
 }
 
+var (
+	ErrPrizeDelivered = goErrors.New("a prize of this tombola was already delivered")
+	ErrTicketsSold    = goErrors.New("tickets of this tombola were already sold")
+)
+
+// drawLockKey identifies the advisory lock taken by the instance running the
+// scheduled draws.
+const drawLockKey = 20260429
+
 type Repository struct {
 	db *sqlx.DB
 }
@@ -34,8 +57,15 @@ func (repository *Repository) GetAllTombolas(filters map[string]interface{}) ([]
 			t.name AS name,
 			t.prize AS prize,
 			t.price AS price,
-			t.status AS status
-		FROM tombolas t WHERE 1=1
+			t.status AS status,
+			t.one_win_per_student AS one_win_per_student,
+			t.max_tickets AS max_tickets,
+			t.max_tickets_per_student AS max_tickets_per_student,
+			t.draw_at AS draw_at,
+			t.created_at AS created_at,
+			t.updated_at AS updated_at,
+			t.version AS version
+		FROM tombolas t WHERE t.deleted_at IS NULL
 	`
 
 	var conditions []string
@@ -52,26 +82,90 @@ func (repository *Repository) GetAllTombolas(filters map[string]interface{}) ([]
 
 func (repository *Repository) GetTombolaById(id int) (types.Tombola, error) {
 	var tombola types.Tombola
-	query := "SELECT * FROM tombolas WHERE id=$1"
+	query := "SELECT * FROM tombolas WHERE id=$1 AND deleted_at IS NULL"
 	err := repository.db.Get(&tombola, query, id)
 
 	return tombola, err
 }
 
-func (repository *Repository) AddTombola(input map[string]interface{}) error {
-	query := "INSERT INTO tombolas (kermesse_id, name, price, prize) VALUES ($1, $2, $3, $4)"
-	_, err := repository.db.Exec(query, input["kermesse_id"], input["name"], input["price"], input["prize"])
+func (repository *Repository) AddTombola(input map[string]interface{}) (err error) {
+	tx, err := repository.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
 
-	return err
+	var tombolaId int
+	query := `
+		INSERT INTO tombolas (kermesse_id, name, price, prize, one_win_per_student, max_tickets, max_tickets_per_student, draw_at)
+		VALUES ($1, $2, $3, $4, COALESCE($5, FALSE), $6, $7, $8)
+		RETURNING id
+	`
+	err = tx.QueryRow(query, input["kermesse_id"], input["name"], input["price"], input["prize"], input["one_win_per_student"], input["max_tickets"], input["max_tickets_per_student"], input["draw_at"]).Scan(&tombolaId)
+	if err != nil {
+		return err
+	}
+
+	prizes, _ := input["prizes"].([]types.TombolaPrize)
+	return insertPrizes(tx, tombolaId, prizes)
 }
 
-func (repository *Repository) ModifyTombola(id int, input map[string]interface{}) error {
-	query := "UPDATE tombolas SET name=$1, price=$2, prize=$3 WHERE id=$4"
-	_, err := repository.db.Exec(query, input["name"], input["price"], input["prize"], id)
+// ModifyTombola updates the tombola if it is still at the version, it returns
+// sql.ErrNoRows when it was changed in the meantime. A new input["prize"]
+// renames the main prize, the best ranked one, in the same transaction so that
+// the legacy prize column keeps naming it.
+func (repository *Repository) ModifyTombola(id int, version int, input map[string]interface{}) (err error) {
+	tx, err := repository.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	query := `
+		UPDATE tombolas
+		SET name=$1, price=$2, prize=COALESCE($3, prize),
+			one_win_per_student=COALESCE($4, one_win_per_student),
+			max_tickets=COALESCE($5, max_tickets),
+			max_tickets_per_student=COALESCE($6, max_tickets_per_student),
+			draw_at=COALESCE($7, draw_at)
+		WHERE id=$8 AND version=$9 AND deleted_at IS NULL
+	`
+	err = utils.ExecSingleRow(tx, sql.ErrNoRows, query, input["name"], input["price"], input["prize"], input["one_win_per_student"], input["max_tickets"], input["max_tickets_per_student"], input["draw_at"], id, version)
+	if err != nil {
+		return err
+	}
+
+	if input["prize"] == nil {
+		return nil
+	}
+	query = `
+		UPDATE tombola_prizes SET name=$1
+		WHERE id = (SELECT id FROM tombola_prizes WHERE tombola_id=$2 ORDER BY rank LIMIT 1)
+	`
+	_, err = tx.Exec(query, input["prize"], id)
 	return err
 }
 
-func (repository *Repository) SelectWinner(id int) error {
+func (repository *Repository) GetPrizesByTombolaId(id int) ([]types.TombolaPrize, error) {
+	var prizes []types.TombolaPrize
+	query := "SELECT id, tombola_id, name, rank, quantity FROM tombola_prizes WHERE tombola_id=$1 ORDER BY rank"
+	err := repository.db.Select(&prizes, query, id)
+	return prizes, err
+}
+
+func (repository *Repository) ReplacePrizes(id int, prizes []types.TombolaPrize) (err error) {
 	tx, err := repository.db.Beginx()
 	if err != nil {
 		return err
@@ -84,21 +178,236 @@ func (repository *Repository) SelectWinner(id int) error {
 		}
 	}()
 
-	query := "UPDATE tombolas SET status='FINISHED' WHERE id=$1"
-	_, err = tx.Exec(query, id)
+	_, err = tx.Exec("DELETE FROM tombola_prizes WHERE tombola_id=$1", id)
+	if err != nil {
+		return err
+	}
+
+	err = insertPrizes(tx, id, prizes)
 	if err != nil {
 		return err
 	}
 
+	// keep the legacy prize column pointing at the main prize
+	_, err = tx.Exec("UPDATE tombolas SET prize=$1 WHERE id=$2", prizes[0].Name, id)
+	return err
+}
+
+func insertPrizes(tx *sqlx.Tx, tombolaId int, prizes []types.TombolaPrize) error {
+	query := "INSERT INTO tombola_prizes (tombola_id, name, rank, quantity) VALUES ($1, $2, $3, $4)"
+	for _, prize := range prizes {
+		if _, err := tx.Exec(query, tombolaId, prize.Name, prize.Rank, prize.Quantity); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SelectWinner finishes the tombola and draws a distinct winning ticket for every
+// unit of every prize, best rank first. When the tombola is configured with
+// one_win_per_student, a student already holding a winning ticket is skipped.
+// Each winning ticket gets a pickup code and thirty days to claim its prize.
+// It returns sql.ErrNoRows when the tombola has already been drawn. The
+// webhook deliveries of the events newEvents builds from the winning tickets
+// are queued in the same transaction, the winning tickets are returned.
+func (repository *Repository) SelectWinner(id int, newEvents func(winners []types.Ticket) []notifications.Event) (winners []types.Ticket, err error) {
+	tx, err := repository.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var oneWinPerStudent bool
+	query := "UPDATE tombolas SET status='FINISHED' WHERE id=$1 AND status='STARTED' RETURNING one_win_per_student"
+	err = tx.QueryRow(query, id).Scan(&oneWinPerStudent)
+	if err != nil {
+		return nil, err
+	}
+
+	err = drawWinners(tx, id, oneWinPerStudent)
+	if err != nil {
+		return nil, err
+	}
+	return queueDrawEvents(tx, id, newEvents)
+}
+
+// Redraw cancels the draw of a finished tombola and draws its winners again,
+// unless one of the prizes was already handed over. It returns sql.ErrNoRows
+// when the tombola is not finished. The audit event is recorded and the events
+// of the new draw are queued as in SelectWinner, in the same transaction.
+func (repository *Repository) Redraw(id int, event types.AuditEvent, newEvents func(winners []types.Ticket) []notifications.Event) (winners []types.Ticket, err error) {
+	tx, err := repository.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	var oneWinPerStudent bool
+	query := "SELECT one_win_per_student FROM tombolas WHERE id=$1 AND status='FINISHED' FOR UPDATE"
+	err = tx.QueryRow(query, id).Scan(&oneWinPerStudent)
+	if err != nil {
+		return nil, err
+	}
+
+	// the winning tickets are locked before their delivery is checked, a
+	// prize delivered meanwhile would otherwise be reset with the others
+	var claimStatuses []*string
+	query = "SELECT claim_status FROM tickets WHERE tombola_id=$1 AND is_winner = true FOR UPDATE"
+	err = tx.Select(&claimStatuses, query, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, claimStatus := range claimStatuses {
+		if claimStatus != nil && *claimStatus == types.PrizeClaimStatusDelivered {
+			return nil, ErrPrizeDelivered
+		}
+	}
+
 	query = `
 		UPDATE tickets
-		SET is_winner = true
-		WHERE id = ( SELECT id FROM tickets WHERE tombola_id = $1 ORDER BY RANDOM() LIMIT 1 )
-		AND tombola_id = $1
+		SET is_winner = false, prize_id = NULL, claim_status = NULL, pickup_code = NULL,
+			claim_expires_at = NULL, claimed_at = NULL
+		WHERE tombola_id = $1 AND is_winner = true
 	`
 	_, err = tx.Exec(query, id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = drawWinners(tx, id, oneWinPerStudent)
+	if err != nil {
+		return nil, err
+	}
+
+	err = audit.InsertEvent(tx, event)
+	if err != nil {
+		return nil, err
+	}
+	return queueDrawEvents(tx, id, newEvents)
+}
+
+// queueDrawEvents queues the webhook deliveries of the events of a draw and
+// returns its winning tickets.
+func queueDrawEvents(tx *sqlx.Tx, id int, newEvents func(winners []types.Ticket) []notifications.Event) ([]types.Ticket, error) {
+	var winners []types.Ticket
+	query := "SELECT * FROM tickets WHERE tombola_id=$1 AND is_winner = true AND deleted_at IS NULL ORDER BY id"
+	err := tx.Select(&winners, query, id)
+	if err != nil {
+		return nil, err
+	}
+	err = webhooks.QueueEvents(tx, newEvents(winners))
+	if err != nil {
+		return nil, err
+	}
+	return winners, nil
+}
+
+// drawWinners draws a distinct winning ticket for every unit of every prize of
+// the tombola, best rank first.
+func drawWinners(tx *sqlx.Tx, id int, oneWinPerStudent bool) error {
+	var prizes []types.TombolaPrize
+	query := "SELECT id, tombola_id, name, rank, quantity FROM tombola_prizes WHERE tombola_id=$1 ORDER BY rank"
+	err := tx.Select(&prizes, query, id)
 	if err != nil {
 		return err
 	}
-	return err
+
+	drawQuery := `
+		UPDATE tickets
+		SET is_winner = true, prize_id = $2,
+			claim_status = 'WON', pickup_code = $3, claim_expires_at = NOW() + INTERVAL '30 days'
+		WHERE id = (
+			SELECT id FROM tickets
+			WHERE tombola_id = $1 AND prize_id IS NULL
+			ORDER BY RANDOM() LIMIT 1
+		)
+	`
+	if oneWinPerStudent {
+		drawQuery = `
+			UPDATE tickets
+			SET is_winner = true, prize_id = $2,
+				claim_status = 'WON', pickup_code = $3, claim_expires_at = NOW() + INTERVAL '30 days'
+			WHERE id = (
+				SELECT id FROM tickets
+				WHERE tombola_id = $1 AND prize_id IS NULL
+				AND user_id NOT IN (SELECT user_id FROM tickets WHERE tombola_id = $1 AND prize_id IS NOT NULL)
+				ORDER BY RANDOM() LIMIT 1
+			)
+		`
+	}
+
+	for _, prize := range prizes {
+		for i := 0; i < prize.Quantity; i++ {
+			pickupCode, err := generator.RandomCode(8)
+			if err != nil {
+				return err
+			}
+			result, err := tx.Exec(drawQuery, id, prize.Id, pickupCode)
+			if err != nil {
+				return err
+			}
+			affected, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			// no eligible ticket left, the remaining prizes stay undrawn
+			if affected == 0 {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+func (repository *Repository) GetDueTombolas() ([]types.Tombola, error) {
+	var tombolas []types.Tombola
+	query := "SELECT * FROM tombolas WHERE status='STARTED' AND draw_at IS NOT NULL AND draw_at <= NOW() AND deleted_at IS NULL ORDER BY draw_at"
+	err := repository.db.Select(&tombolas, query)
+	return tombolas, err
+}
+
+// WithDrawLock runs fn while holding the scheduled draws advisory lock. The lock
+// is session scoped, so it is taken and released on a dedicated connection. It
+// reports false without running fn when another instance holds the lock.
+func (repository *Repository) WithDrawLock(fn func() error) (bool, error) {
+	ctx := context.Background()
+	conn, err := repository.db.Connx(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowxContext(ctx, "SELECT pg_try_advisory_lock($1)", drawLockKey).Scan(&locked); err != nil {
+		return false, err
+	}
+	if !locked {
+		return false, nil
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", drawLockKey)
+
+	return true, fn()
+}
+
+// DeleteTombola soft deletes the tombola, it returns ErrTicketsSold once a
+// ticket was bought since the buyers would lose their jetons.
+func (repository *Repository) DeleteTombola(id int) error {
+	query := `
+		UPDATE tombolas SET deleted_at=NOW()
+		WHERE id=$1 AND deleted_at IS NULL
+		AND NOT EXISTS (SELECT 1 FROM tickets WHERE tombola_id=$1)
+	`
+	return utils.ExecSingleRow(repository.db, ErrTicketsSold, query, id)
 }
@@ -0,0 +1,47 @@
+package tombolas
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Scheduler periodically runs the tombola draws that are due. Every API
+// instance runs one, the draw advisory lock makes sure a single instance
+// draws at a time.
+type Scheduler struct {
+	tombolasService    *Service
+	tombolasRepository TombolaRepository
+	interval           time.Duration
+}
+
+func NewScheduler(tombolasService *Service, tombolasRepository TombolaRepository, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		tombolasService:    tombolasService,
+		tombolasRepository: tombolasRepository,
+		interval:           interval,
+	}
+}
+
+// Start blocks and runs the due draws on every tick until the context is done.
+func (scheduler *Scheduler) Start(ctx context.Context) {
+	ticker := time.NewTicker(scheduler.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			scheduler.runDueDraws()
+		}
+	}
+}
+
+// runDueDraws skips the tick silently when another instance holds the draw
+// lock, it is the usual case with several instances.
+func (scheduler *Scheduler) runDueDraws() {
+	if _, err := scheduler.tombolasRepository.WithDrawLock(scheduler.tombolasService.DrawDueTombolas); err != nil {
+		log.Printf("Error running scheduled tombola draws: %v", err)
+	}
+}
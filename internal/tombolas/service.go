@@ -4,29 +4,49 @@ import (
 	"context"
 	"database/sql"
 	goErrors "errors"
+	"fmt"
+	"github.com/kermesse-backend/internal/audit"
 	"github.com/kermesse-backend/internal/kermesses"
+	"github.com/kermesse-backend/internal/notifications"
+	"github.com/kermesse-backend/internal/policy"
+	"github.com/kermesse-backend/internal/push"
 	"github.com/kermesse-backend/internal/types"
 	"github.com/kermesse-backend/pkg/errors"
 	"github.com/kermesse-backend/pkg/utils"
+	"log"
+	"sort"
+	"strconv"
+	"time"
 )
 
 type TombolaService interface {
 	GetAllTombolas(params map[string]interface{}) ([]types.Tombola, error)
 	GetTombolaById(id int) (types.Tombola, error)
 	AddTombola(ctx context.Context, input map[string]interface{}) error
-	ModifyTombola(ctx context.Context, id int, input map[string]interface{}) error
+	ModifyTombola(ctx context.Context, id int, version int, input map[string]interface{}) error
 	FinishTombola(ctx context.Context, id int) error
+	DeleteTombola(ctx context.Context, id int) error
+	GetPrizes(id int) ([]types.TombolaPrize, error)
+	ReplacePrizes(ctx context.Context, id int, input map[string]interface{}) error
 }
 
 type Service struct {
 	tombolasRepository  TombolaRepository
 	kermessesRepository kermesses.KermessesRepository
+	hub                 *notifications.Hub
+	pushService         *push.Service
+	policyService       policy.PolicyService
+	auditService        *audit.Service
 }
 
-func NewTombolasService(tombolasRepository TombolaRepository, kermessesRepository kermesses.KermessesRepository) *Service {
+func NewTombolasService(tombolasRepository TombolaRepository, kermessesRepository kermesses.KermessesRepository, hub *notifications.Hub, pushService *push.Service, policyService policy.PolicyService, auditService *audit.Service) *Service {
 	return &Service{
 		tombolasRepository:  tombolasRepository,
 		kermessesRepository: kermessesRepository,
+		hub:                 hub,
+		pushService:         pushService,
+		policyService:       policyService,
+		auditService:        auditService,
 	}
 }
 
@@ -64,6 +84,16 @@ func (service *Service) GetTombolaById(id int) (types.Tombola, error) {
 			Err: err,
 		}
 	}
+
+	prizes, err := service.tombolasRepository.GetPrizesByTombolaId(id)
+	if err != nil {
+		return tombola, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	tombola.Prizes = prizes
+
 	return tombola, nil
 }
 
@@ -96,13 +126,31 @@ func (service *Service) AddTombola(ctx context.Context, input map[string]interfa
 		}
 	}
 
-	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
-	if !ok || kermesse.UserId != userId {
+	if err := service.policyService.Authorize(ctx, policy.TombolaManage, policy.Resource{OwnerId: kermesse.UserId, KermesseId: kermesse.Id}); err != nil {
+		return err
+	}
+
+	prizes, err := parsePrizes(input)
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.BadRequest,
+			Err: err,
+		}
+	}
+	if err := parseTicketLimits(input); err != nil {
+		return errors.CustomError{
+			Key: errors.BadRequest,
+			Err: err,
+		}
+	}
+	if err := parseDrawAt(input); err != nil {
 		return errors.CustomError{
-			Key: errors.Forbidden,
-			Err: goErrors.New("unauthorized"),
+			Key: errors.BadRequest,
+			Err: err,
 		}
 	}
+	input["prizes"] = prizes
+	input["prize"] = prizes[0].Name
 
 	err = service.tombolasRepository.AddTombola(input)
 	if err != nil {
@@ -114,7 +162,9 @@ func (service *Service) AddTombola(ctx context.Context, input map[string]interfa
 	return nil
 }
 
-func (service *Service) ModifyTombola(ctx context.Context, id int, input map[string]interface{}) error {
+// ModifyTombola changes the tombola. A version other than 0 must be the current
+// one, the update is refused with a conflict otherwise.
+func (service *Service) ModifyTombola(ctx context.Context, id int, version int, input map[string]interface{}) error {
 	tombola, err := service.tombolasRepository.GetTombolaById(id)
 	if err != nil {
 		if goErrors.Is(err, sql.ErrNoRows) {
@@ -150,22 +200,121 @@ func (service *Service) ModifyTombola(ctx context.Context, id int, input map[str
 		}
 	}
 
-	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
-	if !ok || kermesse.UserId != userId {
+	if err := service.policyService.Authorize(ctx, policy.TombolaManage, policy.Resource{OwnerId: kermesse.UserId, KermesseId: kermesse.Id}); err != nil {
+		return err
+	}
+
+	if version != 0 && version != tombola.Version {
+		return errors.CustomError{
+			Key: errors.Conflict,
+			Err: goErrors.New("tombola was modified since it was read"),
+		}
+	}
+
+	if err := parseTicketLimits(input); err != nil {
+		return errors.CustomError{
+			Key: errors.BadRequest,
+			Err: err,
+		}
+	}
+	if err := parseDrawAt(input); err != nil {
+		return errors.CustomError{
+			Key: errors.BadRequest,
+			Err: err,
+		}
+	}
+	if prize, exists := input["prize"]; exists && prize != nil {
+		if name, ok := prize.(string); !ok || name == "" {
+			return errors.CustomError{
+				Key: errors.BadRequest,
+				Err: goErrors.New("prize must be a non empty string"),
+			}
+		}
+	}
+
+	err = service.tombolasRepository.ModifyTombola(id, tombola.Version, input)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return errors.CustomError{
+				Key: errors.Conflict,
+				Err: goErrors.New("tombola was modified since it was read"),
+			}
+		}
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	if modified, err := service.tombolasRepository.GetTombolaById(id); err == nil {
+		service.auditService.Record(ctx, audit.Entry{
+			Action:     types.AuditActionTombolaUpdated,
+			TargetType: types.AuditTargetTombola,
+			TargetId:   id,
+			KermesseId: kermesse.Id,
+			Before:     tombola,
+			After:      modified,
+		})
+	}
+
+	return nil
+}
+
+// DeleteTombola soft deletes a tombola nobody bought a ticket of yet.
+func (service *Service) DeleteTombola(ctx context.Context, id int) error {
+	tombola, err := service.tombolasRepository.GetTombolaById(id)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return errors.CustomError{
+				Key: errors.NotFound,
+				Err: err,
+			}
+		}
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	kermesse, err := service.kermessesRepository.GetKermesseById(tombola.KermesseId)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return errors.CustomError{
+				Key: errors.NotFound,
+				Err: err,
+			}
+		}
 		return errors.CustomError{
-			Key: errors.Forbidden,
-			Err: goErrors.New("unauthorized"),
+			Key: errors.InternalServerError,
+			Err: err,
 		}
 	}
 
-	err = service.tombolasRepository.ModifyTombola(id, input)
+	if err := service.policyService.Authorize(ctx, policy.TombolaManage, policy.Resource{OwnerId: kermesse.UserId, KermesseId: kermesse.Id}); err != nil {
+		return err
+	}
+
+	err = service.tombolasRepository.DeleteTombola(id)
 	if err != nil {
+		if goErrors.Is(err, ErrTicketsSold) {
+			return errors.CustomError{
+				Key: errors.BadRequest,
+				Err: err,
+			}
+		}
 		return errors.CustomError{
 			Key: errors.InternalServerError,
 			Err: err,
 		}
 	}
 
+	service.auditService.Record(ctx, audit.Entry{
+		Action:     types.AuditActionTombolaDeleted,
+		TargetType: types.AuditTargetTombola,
+		TargetId:   id,
+		KermesseId: kermesse.Id,
+		Before:     tombola,
+	})
 	return nil
 }
 
@@ -205,26 +354,404 @@ func (service *Service) FinishTombola(ctx context.Context, id int) error {
 		}
 	}
 
-	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
-	if !ok || kermesse.UserId != userId {
+	if err := service.policyService.Authorize(ctx, policy.TombolaManage, policy.Resource{OwnerId: kermesse.UserId, KermesseId: kermesse.Id}); err != nil {
+		return err
+	}
+
+	if tombola.Status != types.TombolaStatusStarted {
+		return errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("tombola is not started"),
+		}
+	}
+	if err := service.drawTombola(tombola, kermesse); err != nil {
+		return err
+	}
+
+	service.auditService.Record(ctx, audit.Entry{
+		Action:     types.AuditActionTombolaFinished,
+		TargetType: types.AuditTargetTombola,
+		TargetId:   id,
+		KermesseId: kermesse.Id,
+		Before:     map[string]interface{}{"status": tombola.Status},
+		After:      map[string]interface{}{"status": types.TombolaStatusFinished},
+	})
+	return nil
+}
+
+// DrawDueTombolas draws every started tombola whose draw_at has passed. It is
+// run by the scheduler, without a user in context.
+func (service *Service) DrawDueTombolas() error {
+	tombolas, err := service.tombolasRepository.GetDueTombolas()
+	if err != nil {
+		return err
+	}
+
+	for _, tombola := range tombolas {
+		kermesse, err := service.kermessesRepository.GetKermesseById(tombola.KermesseId)
+		if err != nil {
+			log.Printf("Scheduled draw of tombola %d skipped: %v", tombola.Id, err)
+			continue
+		}
+		if err := service.drawTombola(tombola, kermesse); err != nil {
+			log.Printf("Scheduled draw of tombola %d failed: %v", tombola.Id, err)
+			continue
+		}
+		log.Printf("Tombola %d drawn on schedule", tombola.Id)
+	}
+	return nil
+}
+
+// drawTombola selects the winners of the tombola and notifies them.
+func (service *Service) drawTombola(tombola types.Tombola, kermesse types.Kermesse) error {
+	prizeNames, err := service.getPrizeNames(tombola.Id)
+	if err != nil {
+		return err
+	}
+
+	var events []notifications.Event
+	winners, err := service.tombolasRepository.SelectWinner(tombola.Id, func(winners []types.Ticket) []notifications.Event {
+		events = drawEvents(tombola, kermesse, prizeNames, winners)
+		return events
+	})
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return errors.CustomError{
+				Key: errors.BadRequest,
+				Err: goErrors.New("tombola is not started"),
+			}
+		}
 		return errors.CustomError{
-			Key: errors.Forbidden,
-			Err: goErrors.New("unauthorized"),
+			Key: errors.InternalServerError,
+			Err: err,
 		}
 	}
+	service.notifyWinners(tombola, kermesse, winners, events)
+	return nil
+}
+
+// RedrawTombola draws the winners of a finished tombola again, the previous
+// winners lose their prize. It is meant for the administrators and does not
+// check the user in context.
+func (service *Service) RedrawTombola(ctx context.Context, id int, reason string) error {
+	tombola, err := service.tombolasRepository.GetTombolaById(id)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return errors.CustomError{
+				Key: errors.NotFound,
+				Err: err,
+			}
+		}
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	kermesse, err := service.kermessesRepository.GetKermesseById(tombola.KermesseId)
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	prizeNames, err := service.getPrizeNames(tombola.Id)
+	if err != nil {
+		return err
+	}
+
+	event := audit.NewEvent(ctx, audit.Entry{
+		Action:     types.AuditActionTombolaRedrawn,
+		TargetType: types.AuditTargetTombola,
+		TargetId:   id,
+		KermesseId: kermesse.Id,
+		Reason:     reason,
+	})
+	var events []notifications.Event
+	winners, err := service.tombolasRepository.Redraw(tombola.Id, event, func(winners []types.Ticket) []notifications.Event {
+		events = drawEvents(tombola, kermesse, prizeNames, winners)
+		return events
+	})
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return errors.CustomError{
+				Key: errors.BadRequest,
+				Err: goErrors.New("tombola is not drawn yet"),
+			}
+		}
+		if goErrors.Is(err, ErrPrizeDelivered) {
+			return errors.CustomError{
+				Key: errors.BadRequest,
+				Err: err,
+			}
+		}
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	service.notifyWinners(tombola, kermesse, winners, events)
+	return nil
+}
+
+// getPrizeNames maps the prizes of the tombola to their name.
+func (service *Service) getPrizeNames(id int) (map[int]string, error) {
+	prizes, err := service.tombolasRepository.GetPrizesByTombolaId(id)
+	if err != nil {
+		return nil, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	prizeNames := make(map[int]string)
+	for _, prize := range prizes {
+		prizeNames[prize.Id] = prize.Name
+	}
+	return prizeNames, nil
+}
+
+// drawEvents returns the prize.won event of each winning ticket, in the same
+// order, followed by the tombola.drawn event.
+func drawEvents(tombola types.Tombola, kermesse types.Kermesse, prizeNames map[int]string, winners []types.Ticket) []notifications.Event {
+	var events []notifications.Event
+	for _, ticket := range winners {
+		prizeName := tombola.Prize
+		if ticket.PrizeId != nil {
+			prizeName = prizeNames[*ticket.PrizeId]
+		}
+		events = append(events, notifications.NewEvent(notifications.EventPrizeWon, kermesse.Id, notifications.PrizeWonPayload{
+			TicketId:       ticket.Id,
+			TicketNumber:   ticket.Number,
+			TombolaId:      tombola.Id,
+			TombolaName:    tombola.Name,
+			PrizeName:      prizeName,
+			ClaimExpiresAt: ticket.ClaimExpiresAt,
+		}))
+	}
+	return append(events, notifications.NewEvent(notifications.EventTombolaDrawn, kermesse.Id, notifications.TombolaDrawnPayload{
+		TombolaId:   tombola.Id,
+		TombolaName: tombola.Name,
+		WinnerCount: len(winners),
+	}))
+}
+
+// notifyWinners tells each winner which prize they won and the organizer how
+// the draw went, with the events built by drawEvents.
+func (service *Service) notifyWinners(tombola types.Tombola, kermesse types.Kermesse, winners []types.Ticket, events []notifications.Event) {
+	for i, ticket := range winners {
+		prizeName := events[i].Payload.(notifications.PrizeWonPayload).PrizeName
+		service.hub.NotifyUser(ticket.UserId, events[i])
+		service.pushService.NotifyUser(ticket.UserId, push.Message{
+			Title: "You won a prize!",
+			Body:  fmt.Sprintf("Your ticket #%d won %s in tombola %s", ticket.Number, prizeName, tombola.Name),
+			Data: map[string]string{
+				"type":      notifications.EventPrizeWon,
+				"ticket_id": strconv.Itoa(ticket.Id),
+			},
+		})
+	}
+
+	drawn := events[len(events)-1]
+	service.hub.Notify([]int{kermesse.UserId}, drawn, notifications.KermesseTopic(kermesse.Id), notifications.TombolaTopic(tombola.Id))
+}
+
+func (service *Service) GetPrizes(id int) ([]types.TombolaPrize, error) {
+	tombola, err := service.GetTombolaById(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if tombola.Prizes == nil {
+		return []types.TombolaPrize{}, nil
+	}
+
+	return tombola.Prizes, nil
+}
+
+func (service *Service) ReplacePrizes(ctx context.Context, id int, input map[string]interface{}) error {
+	tombola, err := service.tombolasRepository.GetTombolaById(id)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return errors.CustomError{
+				Key: errors.NotFound,
+				Err: err,
+			}
+		}
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	kermesse, err := service.kermessesRepository.GetKermesseById(tombola.KermesseId)
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	if err := service.policyService.Authorize(ctx, policy.TombolaManage, policy.Resource{OwnerId: kermesse.UserId, KermesseId: kermesse.Id}); err != nil {
+		return err
+	}
 
 	if tombola.Status != types.TombolaStatusStarted {
 		return errors.CustomError{
 			Key: errors.BadRequest,
-			Err: goErrors.New("tombola is not started"),
+			Err: goErrors.New("cannot change the prizes of a finished tombola"),
+		}
+	}
+
+	if _, exists := input["prizes"]; !exists {
+		return errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("prizes is required"),
+		}
+	}
+	prizes, err := parsePrizes(input)
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.BadRequest,
+			Err: err,
 		}
 	}
-	err = service.tombolasRepository.SelectWinner(id)
+
+	previous, err := service.tombolasRepository.GetPrizesByTombolaId(id)
 	if err != nil {
 		return errors.CustomError{
 			Key: errors.InternalServerError,
 			Err: err,
 		}
 	}
+
+	err = service.tombolasRepository.ReplacePrizes(id, prizes)
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	service.auditService.Record(ctx, audit.Entry{
+		Action:     types.AuditActionPrizesReplaced,
+		TargetType: types.AuditTargetTombola,
+		TargetId:   id,
+		KermesseId: kermesse.Id,
+		Before:     map[string]interface{}{"prizes": prizeSummaries(previous)},
+		After:      map[string]interface{}{"prizes": prizeSummaries(prizes)},
+	})
+	return nil
+}
+
+// prizeSummaries leaves out the ids of the prizes, which change on every
+// replacement, for the audit log to show what the organizer changed.
+func prizeSummaries(prizes []types.TombolaPrize) []map[string]interface{} {
+	summaries := make([]map[string]interface{}, 0, len(prizes))
+	for _, prize := range prizes {
+		summaries = append(summaries, map[string]interface{}{
+			"name":     prize.Name,
+			"rank":     prize.Rank,
+			"quantity": prize.Quantity,
+		})
+	}
+	return summaries
+}
+
+// parsePrizes reads the "prizes" list of the input, falling back to the single
+// "prize" string for clients that do not send a list. Prizes are returned
+// ordered by rank, a missing rank defaults to the position in the list and a
+// missing quantity to one.
+func parsePrizes(input map[string]interface{}) ([]types.TombolaPrize, error) {
+	rawPrizes, exists := input["prizes"]
+	if !exists || rawPrizes == nil {
+		name, ok := input["prize"].(string)
+		if !ok || name == "" {
+			return nil, goErrors.New("prize is required")
+		}
+		return []types.TombolaPrize{{Name: name, Rank: 1, Quantity: 1}}, nil
+	}
+
+	list, ok := rawPrizes.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, goErrors.New("prizes must be a non empty list")
+	}
+
+	var err error
+	prizes := make([]types.TombolaPrize, 0, len(list))
+	ranks := make(map[int]bool)
+	for i, rawPrize := range list {
+		item, ok := rawPrize.(map[string]interface{})
+		if !ok {
+			return nil, goErrors.New("invalid prize")
+		}
+		name, ok := item["name"].(string)
+		if !ok || name == "" {
+			return nil, goErrors.New("prize name is required")
+		}
+
+		rank := i + 1
+		if _, exists := item["rank"]; exists {
+			if rank, err = utils.ConvertToInt(item, "rank"); err != nil {
+				return nil, err
+			}
+		}
+		quantity := 1
+		if _, exists := item["quantity"]; exists {
+			if quantity, err = utils.ConvertToInt(item, "quantity"); err != nil {
+				return nil, err
+			}
+		}
+		if rank < 1 || quantity < 1 {
+			return nil, goErrors.New("prize rank and quantity must be positive")
+		}
+		if ranks[rank] {
+			return nil, goErrors.New("prize ranks must be unique")
+		}
+		ranks[rank] = true
+
+		prizes = append(prizes, types.TombolaPrize{Name: name, Rank: rank, Quantity: quantity})
+	}
+
+	sort.Slice(prizes, func(i, j int) bool {
+		return prizes[i].Rank < prizes[j].Rank
+	})
+	return prizes, nil
+}
+
+// parseTicketLimits validates the optional max_tickets and
+// max_tickets_per_student caps, zero meaning unlimited.
+func parseTicketLimits(input map[string]interface{}) error {
+	for _, key := range []string{"max_tickets", "max_tickets_per_student"} {
+		if value, exists := input[key]; !exists || value == nil {
+			continue
+		}
+		limit, err := utils.ConvertToInt(input, key)
+		if err != nil {
+			return err
+		}
+		if limit < 0 {
+			return fmt.Errorf("%s cannot be negative", key)
+		}
+		input[key] = limit
+	}
+	return nil
+}
+
+// parseDrawAt validates the optional RFC 3339 draw_at date of the input.
+func parseDrawAt(input map[string]interface{}) error {
+	value, exists := input["draw_at"]
+	if !exists || value == nil {
+		return nil
+	}
+	raw, ok := value.(string)
+	if !ok {
+		return goErrors.New("draw_at must be an RFC 3339 date")
+	}
+	drawAt, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return goErrors.New("draw_at must be an RFC 3339 date")
+	}
+	input["draw_at"] = drawAt
 	return nil
 }
@@ -0,0 +1,63 @@
+package types
+
+import (
+	"time"
+
+	sqlxTypes "github.com/jmoiron/sqlx/types"
+)
+
+const (
+	AuditActionUserRoleChanged    string = "user.role_changed"
+	AuditActionBalanceAdjusted    string = "user.balance_adjusted"
+	AuditActionUserDeleted        string = "user.deleted"
+	AuditActionKermesseUpdated    string = "kermesse.updated"
+	AuditActionKermesseCompleted  string = "kermesse.completed"
+	AuditActionKermesseForced     string = "kermesse.force_completed"
+	AuditActionKermesseReopened   string = "kermesse.reopened"
+	AuditActionKermesseUserAdded  string = "kermesse.user_added"
+	AuditActionKermesseStandAdded string = "kermesse.stand_added"
+	AuditActionKermesseDeleted    string = "kermesse.deleted"
+	AuditActionStandUpdated       string = "stand.updated"
+	AuditActionStandDeleted       string = "stand.deleted"
+	AuditActionPointsAwarded      string = "participation.points_awarded"
+	AuditActionTombolaUpdated     string = "tombola.updated"
+	AuditActionTombolaFinished    string = "tombola.finished"
+	AuditActionTombolaRedrawn     string = "tombola.redrawn"
+	AuditActionTombolaDeleted     string = "tombola.deleted"
+	AuditActionPrizesReplaced     string = "tombola.prizes_replaced"
+	AuditActionPrizeDelivered     string = "ticket.prize_delivered"
+	AuditActionWebhookAdded       string = "webhook.added"
+	AuditActionWebhookDeleted     string = "webhook.deleted"
+	AuditTargetUser               string = "USER"
+	AuditTargetKermesse           string = "KERMESSE"
+	AuditTargetStand              string = "STAND"
+	AuditTargetParticipation      string = "PARTICIPATION"
+	AuditTargetTombola            string = "TOMBOLA"
+	AuditTargetTicket             string = "TICKET"
+	AuditTargetWebhookEndpoint    string = "WEBHOOK_ENDPOINT"
+)
+
+// AuditEvent records a sensitive action. ActorId is nil for the actions taken
+// by the server itself. Changes maps each modified field to its before and
+// after values, Details holds the context of the action. KermesseId, when
+// set, lets the organizer of the kermesse read the event.
+type AuditEvent struct {
+	Id         int                `json:"id" db:"id"`
+	ActorId    *int               `json:"actor_id" db:"actor_id"`
+	Action     string             `json:"action" db:"action"`
+	TargetType string             `json:"target_type" db:"target_type"`
+	TargetId   int                `json:"target_id" db:"target_id"`
+	KermesseId *int               `json:"kermesse_id" db:"kermesse_id"`
+	Reason     *string            `json:"reason" db:"reason"`
+	Changes    sqlxTypes.JSONText `json:"changes" db:"changes"`
+	Details    sqlxTypes.JSONText `json:"details" db:"details"`
+	Ip         *string            `json:"ip" db:"ip"`
+	RequestId  *string            `json:"request_id" db:"request_id"`
+	CreatedAt  time.Time          `json:"created_at" db:"created_at"`
+}
+
+// AuditChange is the value of a field before and after an action.
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
@@ -0,0 +1,17 @@
+package types
+
+import "time"
+
+const (
+	DevicePlatformAndroid string = "ANDROID"
+	DevicePlatformIos     string = "IOS"
+	DevicePlatformWeb     string = "WEB"
+)
+
+type DeviceToken struct {
+	Id        int       `json:"id" db:"id"`
+	UserId    int       `json:"user_id" db:"user_id"`
+	Token     string    `json:"token" db:"token"`
+	Platform  string    `json:"platform" db:"platform"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
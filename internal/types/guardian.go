@@ -0,0 +1,42 @@
+package types
+
+import "time"
+
+const (
+	GuardianRolePrimary   string = "PRIMARY"
+	GuardianRoleSecondary string = "SECONDARY"
+)
+
+const (
+	GuardianInvitationStatusPending  string = "PENDING"
+	GuardianInvitationStatusAccepted string = "ACCEPTED"
+	GuardianInvitationStatusDeclined string = "DECLINED"
+	GuardianInvitationStatusExpired  string = "EXPIRED"
+)
+
+// Guardian is a parent funding and supervising a student. The primary
+// guardian manages the other guardians of the student.
+type Guardian struct {
+	StudentId  int       `json:"student_id" db:"student_id"`
+	GuardianId int       `json:"guardian_id" db:"guardian_id"`
+	Name       string    `json:"name" db:"name"`
+	Email      string    `json:"email" db:"email"`
+	Role       string    `json:"role" db:"role"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// GuardianInvitation asks the parent owning the email to become a secondary
+// guardian of the student. It is answered with the token of the emailed link,
+// TokenHash is its SHA-256.
+type GuardianInvitation struct {
+	Id          int        `json:"id" db:"id"`
+	StudentId   int        `json:"student_id" db:"student_id"`
+	StudentName string     `json:"student_name" db:"student_name"`
+	Email       string     `json:"email" db:"email"`
+	InvitedBy   int        `json:"invited_by" db:"invited_by"`
+	Status      string     `json:"status" db:"status"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	ExpiresAt   time.Time  `json:"expires_at" db:"expires_at"`
+	RespondedAt *time.Time `json:"responded_at" db:"responded_at"`
+	TokenHash   *string    `json:"-" db:"token_hash"`
+}
@@ -0,0 +1,22 @@
+package types
+
+import (
+	"time"
+
+	sqlxTypes "github.com/jmoiron/sqlx/types"
+)
+
+// IdempotencyKey is a request sent with an Idempotency-Key header and the
+// response it got, for a retry of the request to get the same response.
+// StatusCode is nil while the request is being handled.
+type IdempotencyKey struct {
+	UserId      int                `json:"user_id" db:"user_id"`
+	Key         string             `json:"key" db:"key"`
+	Method      string             `json:"method" db:"method"`
+	Path        string             `json:"path" db:"path"`
+	RequestHash string             `json:"request_hash" db:"request_hash"`
+	StatusCode  *int               `json:"status_code" db:"status_code"`
+	Headers     sqlxTypes.JSONText `json:"headers" db:"headers"`
+	Body        []byte             `json:"body" db:"body"`
+	CreatedAt   time.Time          `json:"created_at" db:"created_at"`
+}
@@ -1,31 +1,40 @@
 package types
 
+import "time"
+
 const (
 	KermesseStatusStarted  string = "STARTED"
 	KermesseStatusFinished string = "FINISHED"
 )
 
 type Kermesse struct {
-	Id          int    `json:"id" db:"id"`
-	UserId      int    `json:"user_id" db:"user_id"`
-	Name        string `json:"name" db:"name"`
-	Status      string `json:"status" db:"status"`
-	Description string `json:"description" db:"description"`
+	Id          int        `json:"id" db:"id"`
+	UserId      int        `json:"user_id" db:"user_id"`
+	Name        string     `json:"name" db:"name"`
+	Status      string     `json:"status" db:"status"`
+	Description string     `json:"description" db:"description"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	Version     int        `json:"version" db:"version"`
 }
 
 type KermesseWithStatistics struct {
-	Id                   int    `json:"id" db:"id"`
-	Name                 string `json:"name" db:"name"`
-	UserId               int    `json:"user_id" db:"user_id"`
-	Status               string `json:"status" db:"status"`
-	Description          string `json:"description" db:"description"`
-	UserNumber           int    `json:"user_number"`
-	StandNumber          int    `json:"stand_number"`
-	TombolaNumber        int    `json:"tombola_number"`
-	TombolaBenefit       int    `json:"tombola_benefit"`
-	ParticipationNumber  int    `json:"participation_number"`
-	ParticipationBenefit int    `json:"participation_benefit"`
-	Points               int    `json:"points"`
+	Id                   int       `json:"id" db:"id"`
+	Name                 string    `json:"name" db:"name"`
+	UserId               int       `json:"user_id" db:"user_id"`
+	Status               string    `json:"status" db:"status"`
+	Description          string    `json:"description" db:"description"`
+	UserNumber           int       `json:"user_number"`
+	StandNumber          int       `json:"stand_number"`
+	TombolaNumber        int       `json:"tombola_number"`
+	TombolaBenefit       int       `json:"tombola_benefit"`
+	ParticipationNumber  int       `json:"participation_number"`
+	ParticipationBenefit int       `json:"participation_benefit"`
+	Points               int       `json:"points"`
+	CreatedAt            time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt            time.Time `json:"updated_at" db:"updated_at"`
+	Version              int       `json:"version" db:"version"`
 }
 
 type KermesseStatistics struct {
@@ -0,0 +1,48 @@
+package types
+
+import "time"
+
+// SpendingCategoryTombola is the spending category of tombola tickets, next to
+// the FOOD and GAME stand categories.
+const SpendingCategoryTombola string = "TOMBOLA"
+
+// SpendingLimit holds the limits a parent sets for a student, a nil limit is
+// no limit. The kermesse and category limits apply to each kermesse. A
+// purchase above the approval threshold waits for the approval of the parent.
+type SpendingLimit struct {
+	StudentId         int       `json:"student_id" db:"student_id"`
+	DailyLimit        *int      `json:"daily_limit" db:"daily_limit"`
+	KermesseLimit     *int      `json:"kermesse_limit" db:"kermesse_limit"`
+	PurchaseLimit     *int      `json:"purchase_limit" db:"purchase_limit"`
+	FoodLimit         *int      `json:"food_limit" db:"food_limit"`
+	GameLimit         *int      `json:"game_limit" db:"game_limit"`
+	TombolaLimit      *int      `json:"tombola_limit" db:"tombola_limit"`
+	ApprovalThreshold *int      `json:"approval_threshold" db:"approval_threshold"`
+	UpdatedAt         time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Spending is what a student spent today, and in a kermesse by category.
+type Spending struct {
+	Daily    int `db:"daily"`
+	Kermesse int `db:"kermesse"`
+	Food     int `db:"food"`
+	Game     int `db:"game"`
+	Tombola  int `db:"tombola"`
+}
+
+type AllowanceLine struct {
+	Limit     *int `json:"limit"`
+	Spent     int  `json:"spent"`
+	Remaining *int `json:"remaining"`
+}
+
+// Allowance is what a student can still spend, the kermesse and category
+// lines are only filled in when a kermesse is given.
+type Allowance struct {
+	StudentId     int                      `json:"student_id"`
+	KermesseId    *int                     `json:"kermesse_id"`
+	PurchaseLimit *int                     `json:"purchase_limit"`
+	Daily         AllowanceLine            `json:"daily"`
+	Kermesse      *AllowanceLine           `json:"kermesse,omitempty"`
+	Categories    map[string]AllowanceLine `json:"categories,omitempty"`
+}
@@ -0,0 +1,25 @@
+package types
+
+import (
+	"time"
+
+	sqlxTypes "github.com/jmoiron/sqlx/types"
+)
+
+type Notification struct {
+	Id         int                `json:"id" db:"id"`
+	UserId     int                `json:"user_id" db:"user_id"`
+	Version    int                `json:"version" db:"version"`
+	Type       string             `json:"type" db:"type"`
+	KermesseId *int               `json:"kermesse_id" db:"kermesse_id"`
+	Payload    sqlxTypes.JSONText `json:"payload" db:"payload"`
+	IsRead     bool               `json:"is_read" db:"is_read"`
+	CreatedAt  time.Time          `json:"created_at" db:"created_at"`
+}
+
+// StreamTicket opens the event stream once, before ExpiresAt, for a client
+// that cannot send the Authorization header.
+type StreamTicket struct {
+	Ticket    string    `json:"ticket"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
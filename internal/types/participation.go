@@ -1,5 +1,7 @@
 package types
 
+import "time"
+
 const (
 	ParticipationStatusStarted  string = "STARTED"
 	ParticipationStatusFinished string = "FINISHED"
@@ -8,14 +10,18 @@ const (
 )
 
 type Participation struct {
-	Id         int    `json:"id" db:"id"`
-	KermesseId int    `json:"kermesse_id" db:"kermesse_id"`
-	StandId    int    `json:"stand_id" db:"stand_id"`
-	UserId     int    `json:"user_id" db:"user_id"`
-	Category   string `json:"category" db:"category"`
-	Balance    int    `json:"balance" db:"balance"`
-	Point      int    `json:"point" db:"point"`
-	Status     string `json:"status" db:"status"`
+	Id         int        `json:"id" db:"id"`
+	KermesseId int        `json:"kermesse_id" db:"kermesse_id"`
+	StandId    int        `json:"stand_id" db:"stand_id"`
+	UserId     int        `json:"user_id" db:"user_id"`
+	Category   string     `json:"category" db:"category"`
+	Balance    int        `json:"balance" db:"balance"`
+	Point      int        `json:"point" db:"point"`
+	Status     string     `json:"status" db:"status"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at" db:"updated_at"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	Version    int        `json:"version" db:"version"`
 }
 
 type ParticipatedUser struct {
@@ -41,22 +47,27 @@ type ParticipatedStand struct {
 }
 
 type ParticipationCompleteModel struct {
-	Id       int                  `json:"id" db:"id"`
-	Category string               `json:"category" db:"category"`
-	Balance  int                  `json:"balance" db:"balance"`
-	Point    int                  `json:"point" db:"point"`
-	Status   string               `json:"status" db:"status"`
-	User     ParticipatedUser     `json:"user" db:"user"`
-	Kermesse ParticipatedKermesse `json:"kermesse" db:"kermesse"`
-	Stand    ParticipatedStand    `json:"stand" db:"stand"`
+	Id        int                  `json:"id" db:"id"`
+	Category  string               `json:"category" db:"category"`
+	Balance   int                  `json:"balance" db:"balance"`
+	Point     int                  `json:"point" db:"point"`
+	Status    string               `json:"status" db:"status"`
+	User      ParticipatedUser     `json:"user" db:"user"`
+	Kermesse  ParticipatedKermesse `json:"kermesse" db:"kermesse"`
+	Stand     ParticipatedStand    `json:"stand" db:"stand"`
+	CreatedAt time.Time            `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time            `json:"updated_at" db:"updated_at"`
+	Version   int                  `json:"version" db:"version"`
 }
 
 type ParticipationUserStand struct {
-	Id       int               `json:"id" db:"id"`
-	Category string            `json:"category" db:"category"`
-	Balance  int               `json:"balance" db:"balance"`
-	Point    int               `json:"point" db:"point"`
-	Status   string            `json:"status" db:"status"`
-	User     ParticipatedUser  `json:"user" db:"user"`
-	Stand    ParticipatedStand `json:"stand" db:"stand"`
+	Id        int               `json:"id" db:"id"`
+	Category  string            `json:"category" db:"category"`
+	Balance   int               `json:"balance" db:"balance"`
+	Point     int               `json:"point" db:"point"`
+	Status    string            `json:"status" db:"status"`
+	User      ParticipatedUser  `json:"user" db:"user"`
+	Stand     ParticipatedStand `json:"stand" db:"stand"`
+	CreatedAt time.Time         `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at" db:"updated_at"`
 }
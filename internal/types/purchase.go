@@ -0,0 +1,40 @@
+package types
+
+import "time"
+
+const (
+	PurchaseKindParticipation string = "PARTICIPATION"
+	PurchaseKindTicket        string = "TICKET"
+)
+
+const (
+	PurchaseRequestStatusPending  string = "PENDING"
+	PurchaseRequestStatusApproved string = "APPROVED"
+	PurchaseRequestStatusDenied   string = "DENIED"
+	PurchaseRequestStatusExpired  string = "EXPIRED"
+	PurchaseRequestStatusFailed   string = "FAILED"
+)
+
+// PurchaseRequest is a purchase of a student waiting for the approval of one
+// of their guardians. The stock or the tickets are reserved until its purchase
+// is completed, the student is only charged once it is approved. DecidedBy is
+// the guardian who approved or denied it, CompletedAt is set along with the
+// charge and Reason explains a purchase that failed after its approval.
+type PurchaseRequest struct {
+	Id          int        `json:"id" db:"id"`
+	Kind        string     `json:"kind" db:"kind"`
+	StudentId   int        `json:"student_id" db:"student_id"`
+	DecidedBy   *int       `json:"decided_by" db:"decided_by"`
+	KermesseId  int        `json:"kermesse_id" db:"kermesse_id"`
+	StandId     *int       `json:"stand_id" db:"stand_id"`
+	TombolaId   *int       `json:"tombola_id" db:"tombola_id"`
+	ItemName    string     `json:"item_name" db:"item_name"`
+	Quantity    int        `json:"quantity" db:"quantity"`
+	Amount      int        `json:"amount" db:"amount"`
+	Status      string     `json:"status" db:"status"`
+	Reason      *string    `json:"reason" db:"reason"`
+	ExpiresAt   time.Time  `json:"expires_at" db:"expires_at"`
+	DecidedAt   *time.Time `json:"decided_at" db:"decided_at"`
+	CompletedAt *time.Time `json:"completed_at" db:"completed_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+}
@@ -1,11 +1,17 @@
 package types
 
+import "time"
+
 type Stand struct {
-	Id          int    `json:"id" db:"id"`
-	UserId      int    `json:"user_id" db:"user_id"`
-	Name        string `json:"name" db:"name"`
-	Category    string `json:"category" db:"category"`
-	Stock       int    `json:"stock" db:"stock"`
-	Price       int    `json:"price" db:"price"`
-	Description string `json:"description" db:"description"`
+	Id          int        `json:"id" db:"id"`
+	UserId      int        `json:"user_id" db:"user_id"`
+	Name        string     `json:"name" db:"name"`
+	Category    string     `json:"category" db:"category"`
+	Stock       int        `json:"stock" db:"stock"`
+	Price       int        `json:"price" db:"price"`
+	Description string     `json:"description" db:"description"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	Version     int        `json:"version" db:"version"`
 }
@@ -1,10 +1,40 @@
 package types
 
+import "time"
+
+const (
+	PrizeClaimStatusWon       string = "WON"
+	PrizeClaimStatusClaimed   string = "CLAIMED"
+	PrizeClaimStatusDelivered string = "DELIVERED"
+	PrizeClaimStatusExpired   string = "EXPIRED"
+)
+
 type Ticket struct {
-	Id        int  `json:"id" db:"id"`
-	UserId    int  `json:"user_id" db:"user_id"`
-	TombolaId int  `json:"tombola_id" db:"tombola_id"`
-	IsWinner  bool `json:"is_winner" db:"is_winner"`
+	Id             int        `json:"id" db:"id"`
+	UserId         int        `json:"user_id" db:"user_id"`
+	BuyerId        int        `json:"buyer_id" db:"buyer_id"`
+	TombolaId      int        `json:"tombola_id" db:"tombola_id"`
+	IsWinner       bool       `json:"is_winner" db:"is_winner"`
+	PrizeId        *int       `json:"prize_id" db:"prize_id"`
+	Number         int        `json:"number" db:"number"`
+	Price          int        `json:"price" db:"price"`
+	ClaimStatus    *string    `json:"claim_status" db:"claim_status"`
+	PickupCode     *string    `json:"-" db:"pickup_code"`
+	ClaimExpiresAt *time.Time `json:"claim_expires_at" db:"claim_expires_at"`
+	ClaimedAt      *time.Time `json:"claimed_at" db:"claimed_at"`
+	DeliveredAt    *time.Time `json:"delivered_at" db:"delivered_at"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at" db:"updated_at"`
+	DeletedAt      *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+}
+
+type TicketPurchase struct {
+	TombolaId  int   `json:"tombola_id"`
+	StudentId  int   `json:"student_id"`
+	BuyerId    int   `json:"buyer_id"`
+	Quantity   int   `json:"quantity"`
+	TotalPrice int   `json:"total_price"`
+	Numbers    []int `json:"numbers"`
 }
 
 type TicketUser struct {
@@ -30,9 +60,22 @@ type TicketKermesse struct {
 }
 
 type TicketCompleteModel struct {
-	Id       int            `json:"id" db:"id"`
-	IsWinner bool           `json:"is_winner" db:"is_winner"`
-	User     TicketUser     `json:"user" db:"user"`
-	Tombola  TicketTombola  `json:"tombola" db:"tombola"`
-	Kermesse TicketKermesse `json:"kermesse" db:"kermesse"`
+	Id             int            `json:"id" db:"id"`
+	Number         int            `json:"number" db:"number"`
+	Price          int            `json:"price" db:"price"`
+	BuyerId        int            `json:"buyer_id" db:"buyer_id"`
+	IsWinner       bool           `json:"is_winner" db:"is_winner"`
+	PrizeId        *int           `json:"prize_id" db:"prize_id"`
+	PrizeName      *string        `json:"prize_name" db:"prize_name"`
+	PrizeRank      *int           `json:"prize_rank" db:"prize_rank"`
+	ClaimStatus    *string        `json:"claim_status" db:"claim_status"`
+	PickupCode     *string        `json:"pickup_code,omitempty" db:"pickup_code"`
+	ClaimExpiresAt *time.Time     `json:"claim_expires_at" db:"claim_expires_at"`
+	ClaimedAt      *time.Time     `json:"claimed_at" db:"claimed_at"`
+	DeliveredAt    *time.Time     `json:"delivered_at" db:"delivered_at"`
+	User           TicketUser     `json:"user" db:"user"`
+	Tombola        TicketTombola  `json:"tombola" db:"tombola"`
+	Kermesse       TicketKermesse `json:"kermesse" db:"kermesse"`
+	CreatedAt      time.Time      `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at" db:"updated_at"`
 }
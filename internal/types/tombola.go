@@ -1,15 +1,34 @@
 package types
 
+import "time"
+
 const (
 	TombolaStatusStarted  = "STARTED"
 	TombolaStatusFinished = "FINISHED"
 )
 
 type Tombola struct {
-	Id         int    `json:"id" db:"id"`
-	KermesseId int    `json:"kermesse_id" db:"kermesse_id"`
-	Prize      string `json:"prize" db:"prize"`
-	Name       string `json:"name" db:"name"`
-	Price      int    `json:"price" db:"price"`
-	Status     string `json:"status" db:"status"`
+	Id                   int            `json:"id" db:"id"`
+	KermesseId           int            `json:"kermesse_id" db:"kermesse_id"`
+	Prize                string         `json:"prize" db:"prize"`
+	Name                 string         `json:"name" db:"name"`
+	Price                int            `json:"price" db:"price"`
+	Status               string         `json:"status" db:"status"`
+	OneWinPerStudent     bool           `json:"one_win_per_student" db:"one_win_per_student"`
+	MaxTickets           *int           `json:"max_tickets" db:"max_tickets"`
+	MaxTicketsPerStudent *int           `json:"max_tickets_per_student" db:"max_tickets_per_student"`
+	DrawAt               *time.Time     `json:"draw_at" db:"draw_at"`
+	Prizes               []TombolaPrize `json:"prizes,omitempty" db:"-"`
+	CreatedAt            time.Time      `json:"created_at" db:"created_at"`
+	UpdatedAt            time.Time      `json:"updated_at" db:"updated_at"`
+	DeletedAt            *time.Time     `json:"deleted_at,omitempty" db:"deleted_at"`
+	Version              int            `json:"version" db:"version"`
+}
+
+type TombolaPrize struct {
+	Id        int    `json:"id" db:"id"`
+	TombolaId int    `json:"tombola_id" db:"tombola_id"`
+	Name      string `json:"name" db:"name"`
+	Rank      int    `json:"rank" db:"rank"`
+	Quantity  int    `json:"quantity" db:"quantity"`
 }
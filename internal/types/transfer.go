@@ -0,0 +1,28 @@
+package types
+
+import "time"
+
+const (
+	BalanceTransferParentToStudent  string = "PARENT_TO_STUDENT"
+	BalanceTransferStudentToParent  string = "STUDENT_TO_PARENT"
+	BalanceTransferStudentToStudent string = "STUDENT_TO_STUDENT"
+)
+
+// BalanceTransfer is a movement of jetons inside a family, always initiated by
+// the parent.
+type BalanceTransfer struct {
+	Id          int       `json:"id" db:"id"`
+	Kind        string    `json:"kind" db:"kind"`
+	FromUserId  int       `json:"from_user_id" db:"from_user_id"`
+	ToUserId    int       `json:"to_user_id" db:"to_user_id"`
+	InitiatedBy int       `json:"initiated_by" db:"initiated_by"`
+	Amount      int       `json:"amount" db:"amount"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// Family reports the balances of a parent and of their students together.
+type Family struct {
+	Parent       UserBasic   `json:"parent"`
+	Students     []UserBasic `json:"students"`
+	TotalBalance int         `json:"total_balance"`
+}
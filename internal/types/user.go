@@ -1,10 +1,15 @@
 package types
 
+import "time"
+
 type SessionKey string
 
 const (
-	UserIDSessionKey   SessionKey = "session_user_id"
-	UserRoleSessionKey SessionKey = "session_user_role"
+	UserIDSessionKey         SessionKey = "session_user_id"
+	UserRoleSessionKey       SessionKey = "session_user_role"
+	TokenExpiresAtSessionKey SessionKey = "session_token_expires_at"
+	RequestIDSessionKey      SessionKey = "session_request_id"
+	ClientIPSessionKey       SessionKey = "session_client_ip"
 )
 
 const (
@@ -12,16 +17,22 @@ const (
 	UserRoleStudent     string = "STUDENT"
 	UserRoleOrganizer   string = "ORGANIZER"
 	UserRoleStandHolder string = "STAND_HOLDER"
+	UserRoleAdmin       string = "ADMIN"
 )
 
+// User is any account. ParentId is the parent who created the student, the
+// guardians of a student are recorded apart, see Guardian.
 type User struct {
-	Id       int    `json:"id" db:"id"`
-	ParentId *int   `json:"parentId" db:"parent_id"`
-	Name     string `json:"name" db:"name"`
-	Email    string `json:"email" db:"email"`
-	Balance  int    `json:"balance" db:"balance"`
-	Password string `json:"password" db:"password"`
-	Role     string `json:"role" db:"role"`
+	Id        int        `json:"id" db:"id"`
+	ParentId  *int       `json:"parentId" db:"parent_id"`
+	Name      string     `json:"name" db:"name"`
+	Email     string     `json:"email" db:"email"`
+	Balance   int        `json:"balance" db:"balance"`
+	Password  string     `json:"password" db:"password"`
+	Role      string     `json:"role" db:"role"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
 }
 
 type UserBasic struct {
@@ -41,4 +52,6 @@ type UserWithAuthToken struct {
 	Balance   int    `json:"balance" db:"balance"`
 	WithStand bool   `json:"with_stand"`
 	Token     string `json:"token"`
+	// UnreadNotifications is only filled in by /me.
+	UnreadNotifications int `json:"unread_notifications"`
 }
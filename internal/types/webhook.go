@@ -0,0 +1,54 @@
+package types
+
+import (
+	"time"
+
+	sqlxTypes "github.com/jmoiron/sqlx/types"
+	"github.com/lib/pq"
+)
+
+const (
+	WebhookDeliveryStatusPending   string = "PENDING"
+	WebhookDeliveryStatusDelivered string = "DELIVERED"
+	WebhookDeliveryStatusFailed    string = "FAILED"
+)
+
+// WebhookEndpoint is a URL notified of the events of a kermesse. The secret is
+// only returned when the endpoint is created.
+type WebhookEndpoint struct {
+	Id         int            `json:"id" db:"id"`
+	KermesseId int            `json:"kermesse_id" db:"kermesse_id"`
+	Url        string         `json:"url" db:"url"`
+	Secret     string         `json:"secret,omitempty" db:"secret"`
+	EventTypes pq.StringArray `json:"event_types" db:"event_types"`
+	CreatedAt  time.Time      `json:"created_at" db:"created_at"`
+}
+
+type WebhookDelivery struct {
+	Id            int                `json:"id" db:"id"`
+	EndpointId    int                `json:"endpoint_id" db:"endpoint_id"`
+	EventType     string             `json:"event_type" db:"event_type"`
+	Payload       sqlxTypes.JSONText `json:"payload" db:"payload"`
+	Status        string             `json:"status" db:"status"`
+	AttemptCount  int                `json:"attempt_count" db:"attempt_count"`
+	NextAttemptAt time.Time          `json:"next_attempt_at" db:"next_attempt_at"`
+	CreatedAt     time.Time          `json:"created_at" db:"created_at"`
+	DeliveredAt   *time.Time         `json:"delivered_at" db:"delivered_at"`
+	Attempts      []WebhookAttempt   `json:"attempts,omitempty" db:"-"`
+}
+
+type WebhookAttempt struct {
+	Id          int       `json:"id" db:"id"`
+	DeliveryId  int       `json:"delivery_id" db:"delivery_id"`
+	StatusCode  *int      `json:"status_code" db:"status_code"`
+	Error       *string   `json:"error" db:"error"`
+	DurationMs  int       `json:"duration_ms" db:"duration_ms"`
+	AttemptedAt time.Time `json:"attempted_at" db:"attempted_at"`
+}
+
+// WebhookDeliveryJob is a due delivery along with where to send it.
+type WebhookDeliveryJob struct {
+	WebhookDelivery
+	Url    string `db:"url"`
+	Secret string `db:"secret"`
+}
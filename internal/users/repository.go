@@ -1,9 +1,11 @@
 package users
 
 import (
+	goErrors "errors"
 	"fmt"
 	"github.com/jmoiron/sqlx"
 	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/pkg/utils"
 	"strings"
 )
 
@@ -18,8 +20,14 @@ type UsersRepository interface {
 	GetAllStudentByParentId(id int, filters map[string]interface{}) ([]types.UserBasic, error)
 	GetTotalPoints(userId int) (int, error)
 	ModifyBalanceFromStripe(id int, balance int) error
+	TransferBalance(input map[string]interface{}) (types.BalanceTransfer, error)
+	GetTransfers(filters map[string]interface{}) ([]types.BalanceTransfer, error)
+	IsGuardian(studentId int, guardianId int) (bool, error)
+	GetGuardianIds(studentId int) ([]int, error)
 }
 
+var ErrInsufficientBalance = goErrors.New("insufficient balance")
+
 type Repository struct {
 	db *sqlx.DB
 }
@@ -31,7 +39,15 @@ func NewUsersRepository(db *sqlx.DB) *Repository {
 }
 
 func (repository *Repository) Create(newUser map[string]interface{}) error {
-	query := "INSERT INTO users (parent_id, name, email, password, role) VALUES ($1, $2, $3, $4, $5)"
+	// the parent creating a student becomes its primary guardian
+	query := `
+		WITH new_user AS (
+			INSERT INTO users (parent_id, name, email, password, role) VALUES ($1, $2, $3, $4, $5)
+			RETURNING id, parent_id
+		)
+		INSERT INTO student_guardians (student_id, guardian_id, role)
+		SELECT id, parent_id, 'PRIMARY' FROM new_user WHERE parent_id IS NOT NULL
+	`
 	_, err := repository.db.Exec(query, newUser["parent_id"], newUser["name"], newUser["email"], newUser["password"], newUser["role"])
 	return err
 }
@@ -67,7 +83,7 @@ func (repository *Repository) GetAllUsers(filters map[string]interface{}) ([]typ
 			u.role AS role
 		FROM users u
 		FULL OUTER JOIN kermesses_users ku ON ku.user_id = u.id
-		WHERE 1=1
+		WHERE u.deleted_at IS NULL
 	`
 
 	var conditions []string
@@ -94,7 +110,8 @@ func (repository *Repository) GetAllStudentByParentId(id int, filters map[string
 			u.role AS role
 		FROM users u
 		FULL OUTER JOIN kermesses_users ku ON ku.user_id = u.id
-		WHERE u.role = 'STUDENT' AND u.parent_id = $1
+		JOIN student_guardians sg ON sg.student_id = u.id
+		WHERE u.role = 'STUDENT' AND sg.guardian_id = $1 AND u.deleted_at IS NULL
 	`
 
 	if kermesseId, ok := filters["kermesse_id"]; ok {
@@ -108,14 +125,14 @@ func (repository *Repository) GetAllStudentByParentId(id int, filters map[string
 
 func (repository *Repository) GetUserById(userId int) (types.User, error) {
 	var user types.User
-	query := "SELECT * FROM users WHERE id=$1"
+	query := "SELECT * FROM users WHERE id=$1 AND deleted_at IS NULL"
 	err := repository.db.Get(&user, query, userId)
 	return user, err
 }
 
 func (repository *Repository) GetUserByEmail(email string) (types.User, error) {
 	var user types.User
-	query := "SELECT * FROM users WHERE email=$1"
+	query := "SELECT * FROM users WHERE email=$1 AND deleted_at IS NULL"
 	err := repository.db.Get(&user, query, email)
 	return user, err
 }
@@ -137,8 +154,79 @@ func (repository *Repository) AnyStandWithUserId(id int) (bool, error) {
 	query := `
 		SELECT COUNT(*) 
 		FROM stands 
-		WHERE user_id = $1 OR user_id IS NULL
+		WHERE (user_id = $1 OR user_id IS NULL) AND deleted_at IS NULL
 	`
 	err := repository.db.Get(&count, query, id)
 	return count >= 1, err
 }
+
+// TransferBalance moves jetons from one user to another and records the
+// movement, it fails with ErrInsufficientBalance when the sender cannot afford
+// it.
+func (repository *Repository) TransferBalance(input map[string]interface{}) (transfer types.BalanceTransfer, err error) {
+	tx, err := repository.db.Beginx()
+	if err != nil {
+		return transfer, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	err = utils.ExecSingleRow(tx, ErrInsufficientBalance, "UPDATE users SET balance = balance - $1 WHERE id = $2 AND balance >= $1", input["amount"], input["from_user_id"])
+	if err != nil {
+		return transfer, err
+	}
+
+	_, err = tx.Exec("UPDATE users SET balance = balance + $1 WHERE id = $2", input["amount"], input["to_user_id"])
+	if err != nil {
+		return transfer, err
+	}
+
+	query := `
+		INSERT INTO balance_transfers (kind, from_user_id, to_user_id, initiated_by, amount)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING *
+	`
+	err = tx.Get(&transfer, query, input["kind"], input["from_user_id"], input["to_user_id"], input["initiated_by"], input["amount"])
+	return transfer, err
+}
+
+// GetTransfers returns the most recent transfers involving the user, or any
+// student of the guardian.
+func (repository *Repository) GetTransfers(filters map[string]interface{}) ([]types.BalanceTransfer, error) {
+	var transfers []types.BalanceTransfer
+	query := "SELECT * FROM balance_transfers WHERE (from_user_id = $1 OR to_user_id = $1)"
+	if _, ok := filters["family"]; ok {
+		query = `
+			SELECT bt.* FROM balance_transfers bt
+			WHERE bt.initiated_by = $1
+			OR bt.from_user_id IN (SELECT student_id FROM student_guardians WHERE guardian_id = $1)
+			OR bt.to_user_id IN (SELECT student_id FROM student_guardians WHERE guardian_id = $1)
+		`
+	}
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT %v", filters["limit"])
+
+	err := repository.db.Select(&transfers, query, filters["user_id"])
+	return transfers, err
+}
+
+// IsGuardian reports whether the user is one of the guardians of the student,
+// whatever their role.
+func (repository *Repository) IsGuardian(studentId int, guardianId int) (bool, error) {
+	var isGuardian bool
+	query := "SELECT EXISTS (SELECT 1 FROM student_guardians WHERE student_id = $1 AND guardian_id = $2)"
+	err := repository.db.Get(&isGuardian, query, studentId, guardianId)
+	return isGuardian, err
+}
+
+// GetGuardianIds returns the guardians of the student, the primary one first.
+func (repository *Repository) GetGuardianIds(studentId int) ([]int, error) {
+	var guardianIds []int
+	query := "SELECT guardian_id FROM student_guardians WHERE student_id = $1 ORDER BY role, created_at"
+	err := repository.db.Select(&guardianIds, query, studentId)
+	return guardianIds, err
+}
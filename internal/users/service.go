@@ -4,7 +4,11 @@ import (
 	"context"
 	"database/sql"
 	goErrors "errors"
+	"fmt"
 	goJwt "github.com/golang-jwt/jwt/v5"
+	"github.com/kermesse-backend/internal/notifications"
+	"github.com/kermesse-backend/internal/policy"
+	"github.com/kermesse-backend/internal/push"
 	"github.com/kermesse-backend/internal/types"
 	"github.com/kermesse-backend/pkg/errors"
 	"github.com/kermesse-backend/pkg/hasher"
@@ -25,15 +29,32 @@ type UsersService interface {
 	GetAllStudentByParentId(ctx context.Context, params map[string]interface{}) ([]types.UserBasic, error)
 	GetAllUsers(params map[string]interface{}) ([]types.UserBasic, error)
 	ModifyBalanceFromStripe(userId int, balance int) error
+	ReclaimBalance(ctx context.Context, input map[string]interface{}) (types.BalanceTransfer, error)
+	TransferBetweenStudents(ctx context.Context, input map[string]interface{}) (types.BalanceTransfer, error)
+	GetFamily(ctx context.Context) (types.Family, error)
+	GetTransfers(ctx context.Context, params map[string]interface{}) ([]types.BalanceTransfer, error)
 }
 
+const (
+	defaultTransfersLimit = 50
+	maxTransfersLimit     = 100
+)
+
 type Service struct {
-	usersRepository UsersRepository
+	usersRepository         UsersRepository
+	notificationsRepository notifications.NotificationsRepository
+	hub                     *notifications.Hub
+	pushService             *push.Service
+	policyService           policy.PolicyService
 }
 
-func NewUsersService(usersRepository UsersRepository) *Service {
+func NewUsersService(usersRepository UsersRepository, notificationsRepository notifications.NotificationsRepository, hub *notifications.Hub, pushService *push.Service, policyService policy.PolicyService) *Service {
 	return &Service{
-		usersRepository: usersRepository,
+		usersRepository:         usersRepository,
+		notificationsRepository: notificationsRepository,
+		hub:                     hub,
+		pushService:             pushService,
+		policyService:           policyService,
 	}
 }
 
@@ -66,6 +87,11 @@ func (service *Service) ModifyBalanceFromStripe(userId int, balance int) error {
 		}
 	}
 
+	service.hub.NotifyUser(userId, notifications.NewEvent(notifications.EventBalanceCredited, 0, notifications.BalanceCreditedPayload{
+		Amount: balance,
+		Source: notifications.BalanceSourceStripe,
+	}))
+
 	return nil
 }
 
@@ -172,6 +198,12 @@ func (service *Service) Register(input map[string]interface{}) error {
 			Err: goErrors.New("role cannot be student"),
 		}
 	}
+	if input["role"] == types.UserRoleAdmin {
+		return errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("role cannot be admin"),
+		}
+	}
 
 	err = service.usersRepository.Create(input)
 	if err != nil {
@@ -278,13 +310,22 @@ func (service *Service) GetLoggedInUser(ctx context.Context) (types.UserWithAuth
 		}
 	}
 
+	unreadNotifications, err := service.notificationsRepository.CountUnread(user.Id)
+	if err != nil {
+		return types.UserWithAuthToken{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
 	return types.UserWithAuthToken{
-		Id:        user.Id,
-		Name:      user.Name,
-		Email:     user.Email,
-		Balance:   user.Balance,
-		Role:      user.Role,
-		WithStand: withStand,
+		Id:                  user.Id,
+		Name:                user.Name,
+		Email:               user.Email,
+		Balance:             user.Balance,
+		Role:                user.Role,
+		WithStand:           withStand,
+		UnreadNotifications: unreadNotifications,
 	}, nil
 }
 
@@ -370,11 +411,8 @@ func (service *Service) MakePayment(ctx context.Context, input map[string]interf
 		}
 	}
 
-	if student.ParentId == nil || *student.ParentId != parent.Id {
-		return errors.CustomError{
-			Key: errors.Forbidden,
-			Err: goErrors.New("not allowed"),
-		}
+	if _, err := service.getOwnStudent(ctx, student.Id); err != nil {
+		return err
 	}
 
 	newBalance, err := utils.ConvertToInt(input, "balance")
@@ -384,30 +422,296 @@ func (service *Service) MakePayment(ctx context.Context, input map[string]interf
 			Err: err,
 		}
 	}
-	if parent.Balance < newBalance {
+	if newBalance <= 0 {
 		return errors.CustomError{
 			Key: errors.BadRequest,
-			Err: goErrors.New("insufficient balance"),
+			Err: goErrors.New("balance must be positive"),
 		}
 	}
 
-	err = service.usersRepository.AlterBalance(studentId, newBalance)
+	_, err = service.transfer(types.BalanceTransferParentToStudent, parentId, studentId, parentId, newBalance)
 	if err != nil {
-		return errors.CustomError{
+		return err
+	}
+
+	service.hub.NotifyUser(studentId, notifications.NewEvent(notifications.EventBalanceCredited, 0, notifications.BalanceCreditedPayload{
+		Amount:     newBalance,
+		Source:     notifications.BalanceSourceParent,
+		FromUserId: &parentId,
+	}))
+	service.pushService.NotifyUser(studentId, push.Message{
+		Title: "Tokens received",
+		Body:  fmt.Sprintf("%s sent you %d tokens", parent.Name, newBalance),
+		Data: map[string]string{
+			"type": notifications.EventBalanceCredited,
+		},
+	})
+
+	return nil
+}
+
+// ReclaimBalance moves jetons from a student back to their parent, the whole
+// balance of the student unless a balance is given.
+func (service *Service) ReclaimBalance(ctx context.Context, input map[string]interface{}) (types.BalanceTransfer, error) {
+	parentId, ok := ctx.Value(types.UserIDSessionKey).(int)
+	if !ok {
+		return types.BalanceTransfer{}, errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("parent id not found"),
+		}
+	}
+	studentId, err := utils.ConvertToInt(input, "student_id")
+	if err != nil {
+		return types.BalanceTransfer{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: err,
+		}
+	}
+	student, err := service.getOwnStudent(ctx, studentId)
+	if err != nil {
+		return types.BalanceTransfer{}, err
+	}
+
+	amount := student.Balance
+	if _, exists := input["balance"]; exists {
+		amount, err = utils.ConvertToInt(input, "balance")
+		if err != nil {
+			return types.BalanceTransfer{}, errors.CustomError{
+				Key: errors.BadRequest,
+				Err: err,
+			}
+		}
+	}
+	if amount <= 0 {
+		return types.BalanceTransfer{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("nothing to reclaim"),
+		}
+	}
+
+	transfer, err := service.transfer(types.BalanceTransferStudentToParent, studentId, parentId, parentId, amount)
+	if err != nil {
+		return types.BalanceTransfer{}, err
+	}
+
+	service.hub.NotifyUser(studentId, notifications.NewEvent(notifications.EventBalanceDebited, 0, notifications.BalanceDebitedPayload{
+		Amount:   amount,
+		ToUserId: parentId,
+	}))
+
+	return transfer, nil
+}
+
+// TransferBetweenStudents moves jetons between two students of the parent.
+func (service *Service) TransferBetweenStudents(ctx context.Context, input map[string]interface{}) (types.BalanceTransfer, error) {
+	parentId, ok := ctx.Value(types.UserIDSessionKey).(int)
+	if !ok {
+		return types.BalanceTransfer{}, errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("parent id not found"),
+		}
+	}
+	fromId, err := utils.ConvertToInt(input, "from_student_id")
+	if err != nil {
+		return types.BalanceTransfer{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: err,
+		}
+	}
+	toId, err := utils.ConvertToInt(input, "to_student_id")
+	if err != nil {
+		return types.BalanceTransfer{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: err,
+		}
+	}
+	amount, err := utils.ConvertToInt(input, "balance")
+	if err != nil {
+		return types.BalanceTransfer{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: err,
+		}
+	}
+	if fromId == toId {
+		return types.BalanceTransfer{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("students must be different"),
+		}
+	}
+	if amount <= 0 {
+		return types.BalanceTransfer{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("balance must be positive"),
+		}
+	}
+
+	if _, err := service.getOwnStudent(ctx, fromId); err != nil {
+		return types.BalanceTransfer{}, err
+	}
+	if _, err := service.getOwnStudent(ctx, toId); err != nil {
+		return types.BalanceTransfer{}, err
+	}
+
+	transfer, err := service.transfer(types.BalanceTransferStudentToStudent, fromId, toId, parentId, amount)
+	if err != nil {
+		return types.BalanceTransfer{}, err
+	}
+
+	service.hub.NotifyUser(fromId, notifications.NewEvent(notifications.EventBalanceDebited, 0, notifications.BalanceDebitedPayload{
+		Amount:   amount,
+		ToUserId: toId,
+	}))
+	service.hub.NotifyUser(toId, notifications.NewEvent(notifications.EventBalanceCredited, 0, notifications.BalanceCreditedPayload{
+		Amount:     amount,
+		Source:     notifications.BalanceSourceSibling,
+		FromUserId: &fromId,
+	}))
+
+	return transfer, nil
+}
+
+// GetFamily reports the balance of the parent in context next to the balances
+// of their students.
+func (service *Service) GetFamily(ctx context.Context) (types.Family, error) {
+	parentId, ok := ctx.Value(types.UserIDSessionKey).(int)
+	if !ok {
+		return types.Family{}, errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("parent id not found"),
+		}
+	}
+
+	parent, err := service.GetUserById(parentId)
+	if err != nil {
+		return types.Family{}, err
+	}
+	students, err := service.usersRepository.GetAllStudentByParentId(parentId, map[string]interface{}{})
+	if err != nil {
+		return types.Family{}, errors.CustomError{
 			Key: errors.InternalServerError,
 			Err: err,
 		}
 	}
 
-	err = service.usersRepository.AlterBalance(parentId, -newBalance)
+	family := types.Family{
+		Parent:       parent,
+		Students:     []types.UserBasic{},
+		TotalBalance: parent.Balance,
+	}
+	for _, student := range students {
+		student.TotalPoint, err = service.usersRepository.GetTotalPoints(student.Id)
+		if err != nil {
+			return types.Family{}, errors.CustomError{
+				Key: errors.InternalServerError,
+				Err: err,
+			}
+		}
+		family.Students = append(family.Students, student)
+		family.TotalBalance += student.Balance
+	}
+	return family, nil
+}
+
+// GetTransfers returns the transfers of the family to a parent, and the
+// transfers from or to the student to a student.
+func (service *Service) GetTransfers(ctx context.Context, params map[string]interface{}) ([]types.BalanceTransfer, error) {
+	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
+	if !ok {
+		return nil, errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("user ID not found"),
+		}
+	}
+	userRole, ok := ctx.Value(types.UserRoleSessionKey).(string)
+	if !ok {
+		return nil, errors.CustomError{
+			Key: errors.Unauthorized,
+			Err: goErrors.New("user role not found"),
+		}
+	}
+
+	filters := map[string]interface{}{
+		"user_id": userId,
+		"limit":   defaultTransfersLimit,
+	}
+	if userRole == types.UserRoleParent {
+		filters["family"] = true
+	}
+	if limit, exists := params["limit"]; exists {
+		value, err := strconv.Atoi(limit.(string))
+		if err != nil || value <= 0 || value > maxTransfersLimit {
+			return nil, errors.CustomError{
+				Key: errors.BadRequest,
+				Err: goErrors.New("limit must be between 1 and 100"),
+			}
+		}
+		filters["limit"] = value
+	}
+
+	transfers, err := service.usersRepository.GetTransfers(filters)
 	if err != nil {
-		return errors.CustomError{
+		return nil, errors.CustomError{
 			Key: errors.InternalServerError,
 			Err: err,
 		}
 	}
 
-	return nil
+	if transfers == nil {
+		return []types.BalanceTransfer{}, nil
+	}
+
+	return transfers, nil
+}
+
+// getOwnStudent returns the student if the user in context is one of their
+// guardians.
+func (service *Service) getOwnStudent(ctx context.Context, studentId int) (types.User, error) {
+	student, err := service.usersRepository.GetUserById(studentId)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return types.User{}, errors.CustomError{
+				Key: errors.NotFound,
+				Err: goErrors.New("student not found"),
+			}
+		}
+		return types.User{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	if student.Role != types.UserRoleStudent {
+		return types.User{}, errors.CustomError{
+			Key: errors.Forbidden,
+			Err: goErrors.New("not allowed"),
+		}
+	}
+	if err := service.policyService.Authorize(ctx, policy.StudentManage, policy.Resource{OwnerId: student.Id}); err != nil {
+		return types.User{}, err
+	}
+	return student, nil
+}
+
+func (service *Service) transfer(kind string, fromId int, toId int, initiatedBy int, amount int) (types.BalanceTransfer, error) {
+	transfer, err := service.usersRepository.TransferBalance(map[string]interface{}{
+		"kind":         kind,
+		"from_user_id": fromId,
+		"to_user_id":   toId,
+		"initiated_by": initiatedBy,
+		"amount":       amount,
+	})
+	if err != nil {
+		if goErrors.Is(err, ErrInsufficientBalance) {
+			return types.BalanceTransfer{}, errors.CustomError{
+				Key: errors.BadRequest,
+				Err: err,
+			}
+		}
+		return types.BalanceTransfer{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return transfer, nil
 }
 
 func (service *Service) UpdatePassword(ctx context.Context, id int, input map[string]interface{}) error {
@@ -426,19 +730,8 @@ func (service *Service) UpdatePassword(ctx context.Context, id int, input map[st
 		}
 	}
 
-	userId, ok := ctx.Value(types.UserIDSessionKey).(int)
-	if !ok {
-		return errors.CustomError{
-			Key: errors.Unauthorized,
-			Err: goErrors.New("user ID not found"),
-		}
-	}
-
-	if user.Id != userId {
-		return errors.CustomError{
-			Key: errors.Forbidden,
-			Err: goErrors.New("forbidden"),
-		}
+	if err := service.policyService.Authorize(ctx, policy.UserUpdate, policy.Resource{OwnerId: user.Id}); err != nil {
+		return err
 	}
 
 	if !hasher.Compare(user.Password, input["password"].(string)) {
@@ -0,0 +1,61 @@
+package webhooks
+
+import (
+	"context"
+	goErrors "errors"
+	"fmt"
+	"net"
+	"net/url"
+	"syscall"
+)
+
+// ErrPrivateAddress is returned for the endpoints reaching the API host, its
+// network or the metadata service of the cloud provider.
+var ErrPrivateAddress = goErrors.New("webhook endpoints must resolve to public addresses")
+
+// sharedAddressSpace is the carrier-grade NAT range, private in practice
+// though net.IP.IsPrivate leaves it out.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// checkEndpointUrl accepts the https URLs whose host only resolves to public
+// addresses.
+func checkEndpointUrl(ctx context.Context, rawUrl string) error {
+	parsedUrl, err := url.ParseRequestURI(rawUrl)
+	if err != nil || parsedUrl.Scheme != "https" || parsedUrl.Hostname() == "" {
+		return goErrors.New("url must be a valid https URL")
+	}
+
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, parsedUrl.Hostname())
+	if err != nil {
+		return fmt.Errorf("unable to resolve %s", parsedUrl.Hostname())
+	}
+	for _, address := range addresses {
+		if !isPublicIP(address.IP) {
+			return ErrPrivateAddress
+		}
+	}
+	return nil
+}
+
+// isPublicIP rejects the loopback, private, link-local, shared, unspecified
+// and multicast addresses. The metadata services, such as 169.254.169.254,
+// are link-local.
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsMulticast() && !ip.IsUnspecified() && !sharedAddressSpace.Contains(ip)
+}
+
+// dialPublicOnly refuses to connect to an address that is not public. It runs
+// once the host is resolved, so a name resolving to another address after the
+// endpoint was registered is caught as well.
+func dialPublicOnly(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package webhooks
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/jmoiron/sqlx"
+	"github.com/kermesse-backend/internal/notifications"
+	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/pkg/utils"
+	"github.com/lib/pq"
+	"time"
+)
+
+type WebhooksRepository interface {
+	GetEndpointsByKermesseId(kermesseId int) ([]types.WebhookEndpoint, error)
+	GetEndpointById(id int) (types.WebhookEndpoint, error)
+	AddEndpoint(input map[string]interface{}) (types.WebhookEndpoint, error)
+	DeleteEndpoint(id int) error
+	GetDeliveriesByEndpointId(endpointId int) ([]types.WebhookDelivery, error)
+	GetDeliveryById(id int) (types.WebhookDelivery, error)
+	GetAttemptsByDeliveryIds(deliveryIds []int) ([]types.WebhookAttempt, error)
+	LeaseDueDeliveries(limit int, lease time.Duration) ([]types.WebhookDeliveryJob, error)
+	RecordAttempt(attempt types.WebhookAttempt) error
+	MarkDelivered(id int) error
+	ScheduleRetry(id int, nextAttemptAt time.Time, failed bool) error
+	Redeliver(id int) error
+}
+
+// maxListedDeliveries bounds the deliveries returned for an endpoint.
+const maxListedDeliveries = 100
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewWebhooksRepository(db *sqlx.DB) *Repository {
+	return &Repository{
+		db: db,
+	}
+}
+
+func (repository *Repository) GetEndpointsByKermesseId(kermesseId int) ([]types.WebhookEndpoint, error) {
+	var endpoints []types.WebhookEndpoint
+	query := "SELECT * FROM webhook_endpoints WHERE kermesse_id=$1 ORDER BY id"
+	err := repository.db.Select(&endpoints, query, kermesseId)
+	return endpoints, err
+}
+
+func (repository *Repository) GetEndpointById(id int) (types.WebhookEndpoint, error) {
+	var endpoint types.WebhookEndpoint
+	query := "SELECT * FROM webhook_endpoints WHERE id=$1"
+	err := repository.db.Get(&endpoint, query, id)
+	return endpoint, err
+}
+
+func (repository *Repository) AddEndpoint(input map[string]interface{}) (types.WebhookEndpoint, error) {
+	var endpoint types.WebhookEndpoint
+	query := "INSERT INTO webhook_endpoints (kermesse_id, url, secret, event_types) VALUES ($1, $2, $3, $4) RETURNING *"
+	err := repository.db.Get(&endpoint, query, input["kermesse_id"], input["url"], input["secret"], pq.Array(input["event_types"]))
+	return endpoint, err
+}
+
+func (repository *Repository) DeleteEndpoint(id int) error {
+	query := "DELETE FROM webhook_endpoints WHERE id=$1"
+	_, err := repository.db.Exec(query, id)
+	return err
+}
+
+func (repository *Repository) GetDeliveriesByEndpointId(endpointId int) ([]types.WebhookDelivery, error) {
+	var deliveries []types.WebhookDelivery
+	query := "SELECT * FROM webhook_deliveries WHERE endpoint_id=$1 ORDER BY id DESC LIMIT $2"
+	err := repository.db.Select(&deliveries, query, endpointId, maxListedDeliveries)
+	return deliveries, err
+}
+
+func (repository *Repository) GetDeliveryById(id int) (types.WebhookDelivery, error) {
+	var delivery types.WebhookDelivery
+	query := "SELECT * FROM webhook_deliveries WHERE id=$1"
+	err := repository.db.Get(&delivery, query, id)
+	return delivery, err
+}
+
+func (repository *Repository) GetAttemptsByDeliveryIds(deliveryIds []int) ([]types.WebhookAttempt, error) {
+	var attempts []types.WebhookAttempt
+	query := "SELECT * FROM webhook_attempts WHERE delivery_id = ANY($1) ORDER BY id"
+	err := repository.db.Select(&attempts, query, pq.Array(deliveryIds))
+	return attempts, err
+}
+
+// LeaseDueDeliveries returns the pending deliveries whose next attempt is due
+// and postpones them by the lease, so that the workers of the other instances
+// leave them alone while they are being sent.
+func (repository *Repository) LeaseDueDeliveries(limit int, lease time.Duration) ([]types.WebhookDeliveryJob, error) {
+	var jobs []types.WebhookDeliveryJob
+	query := `
+		WITH due AS (
+			SELECT id FROM webhook_deliveries
+			WHERE status = 'PENDING' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE webhook_deliveries d
+		SET next_attempt_at = NOW() + $2 * INTERVAL '1 second'
+		FROM due, webhook_endpoints e
+		WHERE d.id = due.id AND e.id = d.endpoint_id
+		RETURNING d.*, e.url, e.secret
+	`
+	err := repository.db.Select(&jobs, query, limit, lease.Seconds())
+	return jobs, err
+}
+
+func (repository *Repository) RecordAttempt(attempt types.WebhookAttempt) error {
+	query := `
+		INSERT INTO webhook_attempts (delivery_id, status_code, error, duration_ms)
+		VALUES ($1, $2, $3, $4)
+	`
+	_, err := repository.db.Exec(query, attempt.DeliveryId, attempt.StatusCode, attempt.Error, attempt.DurationMs)
+	if err != nil {
+		return err
+	}
+	_, err = repository.db.Exec("UPDATE webhook_deliveries SET attempt_count = attempt_count + 1 WHERE id=$1", attempt.DeliveryId)
+	return err
+}
+
+func (repository *Repository) MarkDelivered(id int) error {
+	query := "UPDATE webhook_deliveries SET status='DELIVERED', delivered_at=NOW() WHERE id=$1"
+	_, err := repository.db.Exec(query, id)
+	return err
+}
+
+func (repository *Repository) ScheduleRetry(id int, nextAttemptAt time.Time, failed bool) error {
+	status := "PENDING"
+	if failed {
+		status = "FAILED"
+	}
+	query := "UPDATE webhook_deliveries SET status=$1, next_attempt_at=$2 WHERE id=$3"
+	_, err := repository.db.Exec(query, status, nextAttemptAt, id)
+	return err
+}
+
+// Redeliver queues the delivery again for an immediate attempt, with a fresh
+// retry schedule.
+func (repository *Repository) Redeliver(id int) error {
+	query := "UPDATE webhook_deliveries SET status='PENDING', attempt_count=0, next_attempt_at=NOW() WHERE id=$1"
+	return utils.ExecSingleRow(repository.db, sql.ErrNoRows, query, id)
+}
+
+// QueueEvent queues a delivery of the event for every endpoint of its
+// kermesse subscribed to it. The callers run it in the transaction of the
+// change the event reports, so that the deliveries are committed along with
+// the change, or not at all.
+func QueueEvent(execer sqlx.Execer, event notifications.Event) error {
+	if event.KermesseId == 0 || !subscribableEvents[event.Type] {
+		return nil
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	query := `
+		INSERT INTO webhook_deliveries (endpoint_id, event_type, payload)
+		SELECT id, $2, $3 FROM webhook_endpoints
+		WHERE kermesse_id = $1 AND $2 = ANY(event_types)
+	`
+	_, err = execer.Exec(query, event.KermesseId, event.Type, string(payload))
+	return err
+}
+
+// QueueEvents queues the deliveries of each event, see QueueEvent.
+func QueueEvents(execer sqlx.Execer, events []notifications.Event) error {
+	for _, event := range events {
+		if err := QueueEvent(execer, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	goErrors "errors"
+	"github.com/kermesse-backend/internal/audit"
+	"github.com/kermesse-backend/internal/kermesses"
+	"github.com/kermesse-backend/internal/notifications"
+	"github.com/kermesse-backend/internal/policy"
+	"github.com/kermesse-backend/internal/types"
+	"github.com/kermesse-backend/pkg/errors"
+	"github.com/kermesse-backend/pkg/generator"
+)
+
+type WebhooksService interface {
+	GetEndpoints(ctx context.Context, kermesseId int) ([]types.WebhookEndpoint, error)
+	AddEndpoint(ctx context.Context, kermesseId int, input map[string]interface{}) (types.WebhookEndpoint, error)
+	DeleteEndpoint(ctx context.Context, id int) error
+	GetDeliveries(ctx context.Context, endpointId int) ([]types.WebhookDelivery, error)
+	Redeliver(ctx context.Context, deliveryId int) error
+}
+
+// subscribableEvents are the event types an endpoint can subscribe to, those
+// that happen inside a kermesse. They are queued with QueueEvent.
+var subscribableEvents = map[string]bool{
+	notifications.EventTicketSold:           true,
+	notifications.EventParticipationCreated: true,
+	notifications.EventGameScored:           true,
+	notifications.EventStockLow:             true,
+	notifications.EventTombolaDrawn:         true,
+	notifications.EventPrizeWon:             true,
+	notifications.EventPrizeDelivered:       true,
+}
+
+type Service struct {
+	webhooksRepository  WebhooksRepository
+	kermessesRepository kermesses.KermessesRepository
+	policyService       policy.PolicyService
+	auditService        *audit.Service
+}
+
+func NewWebhooksService(webhooksRepository WebhooksRepository, kermessesRepository kermesses.KermessesRepository, policyService policy.PolicyService, auditService *audit.Service) *Service {
+	return &Service{
+		webhooksRepository:  webhooksRepository,
+		kermessesRepository: kermessesRepository,
+		policyService:       policyService,
+		auditService:        auditService,
+	}
+}
+
+func (service *Service) GetEndpoints(ctx context.Context, kermesseId int) ([]types.WebhookEndpoint, error) {
+	if err := service.checkOrganizer(ctx, kermesseId); err != nil {
+		return nil, err
+	}
+
+	endpoints, err := service.webhooksRepository.GetEndpointsByKermesseId(kermesseId)
+	if err != nil {
+		return nil, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	if endpoints == nil {
+		return []types.WebhookEndpoint{}, nil
+	}
+
+	for i := range endpoints {
+		endpoints[i].Secret = ""
+	}
+	return endpoints, nil
+}
+
+// AddEndpoint registers the endpoint and returns it along with its signing
+// secret, which is not shown again. The endpoint must be an https URL of a
+// public host, the worker must not be usable to reach the internal network.
+func (service *Service) AddEndpoint(ctx context.Context, kermesseId int, input map[string]interface{}) (types.WebhookEndpoint, error) {
+	if err := service.checkOrganizer(ctx, kermesseId); err != nil {
+		return types.WebhookEndpoint{}, err
+	}
+
+	endpointUrl, _ := input["url"].(string)
+	if err := checkEndpointUrl(ctx, endpointUrl); err != nil {
+		return types.WebhookEndpoint{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: err,
+		}
+	}
+
+	rawEventTypes, ok := input["event_types"].([]interface{})
+	if !ok || len(rawEventTypes) == 0 {
+		return types.WebhookEndpoint{}, errors.CustomError{
+			Key: errors.BadRequest,
+			Err: goErrors.New("event_types is required"),
+		}
+	}
+	var eventTypes []string
+	for _, rawEventType := range rawEventTypes {
+		eventType, ok := rawEventType.(string)
+		if !ok || !subscribableEvents[eventType] {
+			return types.WebhookEndpoint{}, errors.CustomError{
+				Key: errors.BadRequest,
+				Err: goErrors.New("unknown event type"),
+			}
+		}
+		eventTypes = append(eventTypes, eventType)
+	}
+
+	secret, err := generator.RandomPassword(32)
+	if err != nil {
+		return types.WebhookEndpoint{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	endpoint, err := service.webhooksRepository.AddEndpoint(map[string]interface{}{
+		"kermesse_id": kermesseId,
+		"url":         endpointUrl,
+		"secret":      "whsec_" + secret,
+		"event_types": eventTypes,
+	})
+	if err != nil {
+		return types.WebhookEndpoint{}, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	// the secret stays out of the audit log
+	service.auditService.Record(ctx, audit.Entry{
+		Action:     types.AuditActionWebhookAdded,
+		TargetType: types.AuditTargetWebhookEndpoint,
+		TargetId:   endpoint.Id,
+		KermesseId: kermesseId,
+		Details:    map[string]interface{}{"url": endpointUrl, "event_types": eventTypes},
+	})
+	return endpoint, nil
+}
+
+func (service *Service) DeleteEndpoint(ctx context.Context, id int) error {
+	endpoint, err := service.getOwnEndpoint(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	err = service.webhooksRepository.DeleteEndpoint(id)
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	service.auditService.Record(ctx, audit.Entry{
+		Action:     types.AuditActionWebhookDeleted,
+		TargetType: types.AuditTargetWebhookEndpoint,
+		TargetId:   id,
+		KermesseId: endpoint.KermesseId,
+		Details:    map[string]interface{}{"url": endpoint.Url, "event_types": endpoint.EventTypes},
+	})
+	return nil
+}
+
+// GetDeliveries returns the latest deliveries of the endpoint, each with the
+// log of its attempts.
+func (service *Service) GetDeliveries(ctx context.Context, endpointId int) ([]types.WebhookDelivery, error) {
+	if _, err := service.getOwnEndpoint(ctx, endpointId); err != nil {
+		return nil, err
+	}
+
+	deliveries, err := service.webhooksRepository.GetDeliveriesByEndpointId(endpointId)
+	if err != nil {
+		return nil, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	if deliveries == nil {
+		return []types.WebhookDelivery{}, nil
+	}
+
+	deliveryIds := make([]int, len(deliveries))
+	indexes := make(map[int]int)
+	for i, delivery := range deliveries {
+		deliveryIds[i] = delivery.Id
+		indexes[delivery.Id] = i
+	}
+	attempts, err := service.webhooksRepository.GetAttemptsByDeliveryIds(deliveryIds)
+	if err != nil {
+		return nil, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	for _, attempt := range attempts {
+		i := indexes[attempt.DeliveryId]
+		deliveries[i].Attempts = append(deliveries[i].Attempts, attempt)
+	}
+
+	return deliveries, nil
+}
+
+func (service *Service) Redeliver(ctx context.Context, deliveryId int) error {
+	delivery, err := service.webhooksRepository.GetDeliveryById(deliveryId)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return errors.CustomError{
+				Key: errors.NotFound,
+				Err: err,
+			}
+		}
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	if _, err := service.getOwnEndpoint(ctx, delivery.EndpointId); err != nil {
+		return err
+	}
+
+	err = service.webhooksRepository.Redeliver(deliveryId)
+	if err != nil {
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	return nil
+}
+
+func (service *Service) checkOrganizer(ctx context.Context, kermesseId int) error {
+	kermesse, err := service.kermessesRepository.GetKermesseById(kermesseId)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return errors.CustomError{
+				Key: errors.NotFound,
+				Err: err,
+			}
+		}
+		return errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+
+	if err := service.policyService.Authorize(ctx, policy.WebhookManage, policy.Resource{OwnerId: kermesse.UserId, KermesseId: kermesse.Id}); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (service *Service) getOwnEndpoint(ctx context.Context, id int) (types.WebhookEndpoint, error) {
+	endpoint, err := service.webhooksRepository.GetEndpointById(id)
+	if err != nil {
+		if goErrors.Is(err, sql.ErrNoRows) {
+			return endpoint, errors.CustomError{
+				Key: errors.NotFound,
+				Err: err,
+			}
+		}
+		return endpoint, errors.CustomError{
+			Key: errors.InternalServerError,
+			Err: err,
+		}
+	}
+	if err := service.checkOrganizer(ctx, endpoint.KermesseId); err != nil {
+		return endpoint, err
+	}
+	return endpoint, nil
+}
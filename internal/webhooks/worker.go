@@ -0,0 +1,182 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	goErrors "errors"
+	"fmt"
+	"github.com/kermesse-backend/internal/types"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// maxAttempts is the number of attempts after which a delivery is given
+	// up, it can still be redelivered by hand.
+	maxAttempts = 8
+	// baseRetryDelay is the delay before the first retry, doubled after each
+	// failed attempt up to maxRetryDelay.
+	baseRetryDelay = 30 * time.Second
+	maxRetryDelay  = 6 * time.Hour
+	// deliveryLease keeps the other workers away from a delivery being sent.
+	deliveryLease = 5 * time.Minute
+	batchSize     = 20
+	// maxLoggedResponse bounds the response body kept in the attempt log.
+	maxLoggedResponse = 1024
+)
+
+// Worker sends the pending webhook deliveries of the outbox. Every API instance
+// runs one, deliveries are leased so that each is sent by a single worker.
+type Worker struct {
+	webhooksRepository WebhooksRepository
+	client             *http.Client
+	interval           time.Duration
+}
+
+func NewWorker(webhooksRepository WebhooksRepository, interval time.Duration) *Worker {
+	return &Worker{
+		webhooksRepository: webhooksRepository,
+		client:             newClient(),
+		interval:           interval,
+	}
+}
+
+// newClient connects to public addresses only, without proxy, and does not
+// follow redirects, which count as failures.
+func newClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: dialPublicOnly,
+	}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// Start blocks and sends the due deliveries on every tick until the context is
+// done.
+func (worker *Worker) Start(ctx context.Context) {
+	ticker := time.NewTicker(worker.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			worker.sendDueDeliveries(ctx)
+		}
+	}
+}
+
+func (worker *Worker) sendDueDeliveries(ctx context.Context) {
+	jobs, err := worker.webhooksRepository.LeaseDueDeliveries(batchSize, deliveryLease)
+	if err != nil {
+		log.Printf("Error leasing webhook deliveries: %v", err)
+		return
+	}
+	for _, job := range jobs {
+		worker.send(ctx, job)
+	}
+}
+
+func (worker *Worker) send(ctx context.Context, job types.WebhookDeliveryJob) {
+	attempt := types.WebhookAttempt{DeliveryId: job.Id}
+	start := time.Now()
+	statusCode, err := worker.post(ctx, job)
+	attempt.DurationMs = int(time.Since(start).Milliseconds())
+	if statusCode != 0 {
+		attempt.StatusCode = &statusCode
+	}
+	if err != nil {
+		message := err.Error()
+		attempt.Error = &message
+	}
+
+	if err := worker.webhooksRepository.RecordAttempt(attempt); err != nil {
+		log.Printf("Unable to log attempt of webhook delivery %d: %v", job.Id, err)
+	}
+
+	if err == nil {
+		if err := worker.webhooksRepository.MarkDelivered(job.Id); err != nil {
+			log.Printf("Unable to mark webhook delivery %d as delivered: %v", job.Id, err)
+		}
+		return
+	}
+
+	attemptCount := job.AttemptCount + 1
+	failed := attemptCount >= maxAttempts
+	if err := worker.webhooksRepository.ScheduleRetry(job.Id, time.Now().Add(retryDelay(attemptCount)), failed); err != nil {
+		log.Printf("Unable to schedule retry of webhook delivery %d: %v", job.Id, err)
+	}
+}
+
+// post sends the delivery and returns the response status code, if any. Any
+// status other than 2xx is an error.
+func (worker *Worker) post(ctx context.Context, job types.WebhookDeliveryJob) (int, error) {
+	// endpoints registered before https was required are not sent to
+	if parsedUrl, err := url.Parse(job.Url); err != nil || parsedUrl.Scheme != "https" {
+		return 0, goErrors.New("url must be a valid https URL")
+	}
+
+	body := []byte(job.Payload)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, job.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Kermesse-Event", job.EventType)
+	request.Header.Set("X-Kermesse-Delivery", strconv.Itoa(job.Id))
+	request.Header.Set("X-Kermesse-Timestamp", timestamp)
+	request.Header.Set("X-Kermesse-Signature", "sha256="+Sign(job.Secret, timestamp, body))
+
+	response, err := worker.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		responseBody, _ := io.ReadAll(io.LimitReader(response.Body, maxLoggedResponse))
+		return response.StatusCode, fmt.Errorf("endpoint responded with status %d: %s", response.StatusCode, responseBody)
+	}
+	return response.StatusCode, nil
+}
+
+// Sign computes the hex encoded HMAC-SHA256 of "<timestamp>.<body>" with the
+// endpoint secret, which receivers compare to the X-Kermesse-Signature header.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func retryDelay(attemptCount int) time.Duration {
+	delay := baseRetryDelay
+	for i := 1; i < attemptCount; i++ {
+		delay *= 2
+		if delay >= maxRetryDelay {
+			return maxRetryDelay
+		}
+	}
+	return delay
+}
@@ -0,0 +1,5 @@
+ALTER TABLE "tickets" DROP COLUMN IF EXISTS "prize_id";
+
+DROP TABLE IF EXISTS "tombola_prizes";
+
+ALTER TABLE "tombolas" DROP COLUMN IF EXISTS "one_win_per_student";
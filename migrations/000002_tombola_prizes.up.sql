@@ -0,0 +1,20 @@
+ALTER TABLE "tombolas" ADD COLUMN "one_win_per_student" BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE "tombola_prizes" (
+                                  "id" SERIAL PRIMARY KEY,
+                                  "tombola_id" INTEGER NOT NULL REFERENCES "tombolas"("id"),
+                                  "name" VARCHAR(255) NOT NULL,
+                                  "rank" INTEGER NOT NULL DEFAULT 1,
+                                  "quantity" INTEGER NOT NULL DEFAULT 1 CHECK ("quantity" > 0),
+                                  UNIQUE ("tombola_id", "rank")
+);
+
+ALTER TABLE "tickets" ADD COLUMN "prize_id" INTEGER REFERENCES "tombola_prizes"("id") DEFAULT NULL;
+
+INSERT INTO "tombola_prizes" ("tombola_id", "name", "rank", "quantity")
+SELECT "id", "prize", 1, 1 FROM "tombolas";
+
+UPDATE "tickets" t
+SET "prize_id" = p."id"
+FROM "tombola_prizes" p
+WHERE p."tombola_id" = t."tombola_id" AND t."is_winner" = TRUE;
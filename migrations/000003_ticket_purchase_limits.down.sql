@@ -0,0 +1,6 @@
+ALTER TABLE "tickets" DROP CONSTRAINT IF EXISTS "tickets_tombola_id_number_key";
+ALTER TABLE "tickets" DROP COLUMN IF EXISTS "price";
+ALTER TABLE "tickets" DROP COLUMN IF EXISTS "number";
+
+ALTER TABLE "tombolas" DROP COLUMN IF EXISTS "max_tickets_per_student";
+ALTER TABLE "tombolas" DROP COLUMN IF EXISTS "max_tickets";
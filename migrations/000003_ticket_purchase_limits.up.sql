@@ -0,0 +1,16 @@
+ALTER TABLE "tombolas" ADD COLUMN "max_tickets" INTEGER DEFAULT NULL;
+ALTER TABLE "tombolas" ADD COLUMN "max_tickets_per_student" INTEGER DEFAULT NULL;
+
+ALTER TABLE "tickets" ADD COLUMN "number" INTEGER;
+ALTER TABLE "tickets" ADD COLUMN "price" INTEGER NOT NULL DEFAULT 0;
+
+UPDATE "tickets" t
+SET "number" = numbered."number", "price" = tb."price"
+FROM (
+    SELECT "id", ROW_NUMBER() OVER (PARTITION BY "tombola_id" ORDER BY "id") AS "number"
+    FROM "tickets"
+) numbered, "tombolas" tb
+WHERE numbered."id" = t."id" AND tb."id" = t."tombola_id";
+
+ALTER TABLE "tickets" ALTER COLUMN "number" SET NOT NULL;
+ALTER TABLE "tickets" ADD CONSTRAINT "tickets_tombola_id_number_key" UNIQUE ("tombola_id", "number");
@@ -0,0 +1,5 @@
+ALTER TABLE "tickets" ADD COLUMN "buyer_id" INTEGER REFERENCES "users"("id");
+
+UPDATE "tickets" SET "buyer_id" = "user_id";
+
+ALTER TABLE "tickets" ALTER COLUMN "buyer_id" SET NOT NULL;
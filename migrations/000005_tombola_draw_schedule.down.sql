@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS "tombolas_status_draw_at_idx";
+
+ALTER TABLE "tombolas" DROP COLUMN IF EXISTS "draw_at";
@@ -0,0 +1,3 @@
+ALTER TABLE "tombolas" ADD COLUMN "draw_at" TIMESTAMPTZ DEFAULT NULL;
+
+CREATE INDEX "tombolas_status_draw_at_idx" ON "tombolas" ("status", "draw_at") WHERE "draw_at" IS NOT NULL;
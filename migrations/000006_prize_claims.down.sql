@@ -0,0 +1,7 @@
+ALTER TABLE "tickets" DROP COLUMN IF EXISTS "delivered_at";
+ALTER TABLE "tickets" DROP COLUMN IF EXISTS "claimed_at";
+ALTER TABLE "tickets" DROP COLUMN IF EXISTS "claim_expires_at";
+ALTER TABLE "tickets" DROP COLUMN IF EXISTS "pickup_code";
+ALTER TABLE "tickets" DROP COLUMN IF EXISTS "claim_status";
+
+DROP TYPE IF EXISTS prize_claim_status_enum;
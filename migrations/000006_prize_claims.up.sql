@@ -0,0 +1,13 @@
+CREATE TYPE prize_claim_status_enum AS ENUM ('WON', 'CLAIMED', 'DELIVERED', 'EXPIRED');
+
+ALTER TABLE "tickets" ADD COLUMN "claim_status" prize_claim_status_enum DEFAULT NULL;
+ALTER TABLE "tickets" ADD COLUMN "pickup_code" VARCHAR(16) UNIQUE DEFAULT NULL;
+ALTER TABLE "tickets" ADD COLUMN "claim_expires_at" TIMESTAMPTZ DEFAULT NULL;
+ALTER TABLE "tickets" ADD COLUMN "claimed_at" TIMESTAMPTZ DEFAULT NULL;
+ALTER TABLE "tickets" ADD COLUMN "delivered_at" TIMESTAMPTZ DEFAULT NULL;
+
+UPDATE "tickets"
+SET "claim_status" = 'WON',
+    "pickup_code" = UPPER(SUBSTR(MD5(RANDOM()::TEXT || "id"::TEXT), 1, 8)),
+    "claim_expires_at" = NOW() + INTERVAL '30 days'
+WHERE "is_winner" = TRUE;
@@ -0,0 +1,13 @@
+CREATE TABLE "notifications" (
+                                 "id" SERIAL PRIMARY KEY,
+                                 "user_id" INTEGER NOT NULL REFERENCES "users"("id"),
+                                 "version" INTEGER NOT NULL,
+                                 "type" VARCHAR(64) NOT NULL,
+                                 "kermesse_id" INTEGER REFERENCES "kermesses"("id") DEFAULT NULL,
+                                 "payload" JSONB NOT NULL DEFAULT '{}',
+                                 "is_read" BOOLEAN NOT NULL DEFAULT FALSE,
+                                 "created_at" TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX "notifications_user_id_id_idx" ON "notifications" ("user_id", "id");
+CREATE INDEX "notifications_unread_idx" ON "notifications" ("user_id") WHERE "is_read" = FALSE;
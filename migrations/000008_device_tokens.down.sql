@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS "device_tokens";
+
+DROP TYPE IF EXISTS device_platform_enum;
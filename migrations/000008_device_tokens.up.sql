@@ -0,0 +1,11 @@
+CREATE TYPE device_platform_enum AS ENUM ('ANDROID', 'IOS', 'WEB');
+
+CREATE TABLE "device_tokens" (
+                                 "id" SERIAL PRIMARY KEY,
+                                 "user_id" INTEGER NOT NULL REFERENCES "users"("id"),
+                                 "token" VARCHAR(4096) UNIQUE NOT NULL,
+                                 "platform" device_platform_enum NOT NULL,
+                                 "created_at" TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX "device_tokens_user_id_idx" ON "device_tokens" ("user_id");
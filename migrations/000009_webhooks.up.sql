@@ -0,0 +1,36 @@
+CREATE TYPE webhook_delivery_status_enum AS ENUM ('PENDING', 'DELIVERED', 'FAILED');
+
+CREATE TABLE "webhook_endpoints" (
+                                     "id" SERIAL PRIMARY KEY,
+                                     "kermesse_id" INTEGER NOT NULL REFERENCES "kermesses"("id"),
+                                     "url" VARCHAR(2048) NOT NULL,
+                                     "secret" VARCHAR(255) NOT NULL,
+                                     "event_types" TEXT[] NOT NULL,
+                                     "created_at" TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE "webhook_deliveries" (
+                                      "id" SERIAL PRIMARY KEY,
+                                      "endpoint_id" INTEGER NOT NULL REFERENCES "webhook_endpoints"("id") ON DELETE CASCADE,
+                                      "event_type" VARCHAR(64) NOT NULL,
+                                      "payload" JSONB NOT NULL,
+                                      "status" webhook_delivery_status_enum NOT NULL DEFAULT 'PENDING',
+                                      "attempt_count" INTEGER NOT NULL DEFAULT 0,
+                                      "next_attempt_at" TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                                      "created_at" TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                                      "delivered_at" TIMESTAMPTZ DEFAULT NULL
+);
+
+CREATE INDEX "webhook_deliveries_pending_idx" ON "webhook_deliveries" ("next_attempt_at") WHERE "status" = 'PENDING';
+CREATE INDEX "webhook_deliveries_endpoint_id_idx" ON "webhook_deliveries" ("endpoint_id", "id");
+
+CREATE TABLE "webhook_attempts" (
+                                    "id" SERIAL PRIMARY KEY,
+                                    "delivery_id" INTEGER NOT NULL REFERENCES "webhook_deliveries"("id") ON DELETE CASCADE,
+                                    "status_code" INTEGER DEFAULT NULL,
+                                    "error" TEXT DEFAULT NULL,
+                                    "duration_ms" INTEGER NOT NULL,
+                                    "attempted_at" TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX "webhook_attempts_delivery_id_idx" ON "webhook_attempts" ("delivery_id");
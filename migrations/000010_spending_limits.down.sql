@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS "spending_limits";
+
+DROP INDEX IF EXISTS "tickets_buyer_id_created_at_idx";
+DROP INDEX IF EXISTS "participations_user_id_created_at_idx";
+
+ALTER TABLE "tickets" DROP COLUMN IF EXISTS "created_at";
+ALTER TABLE "participations" DROP COLUMN IF EXISTS "created_at";
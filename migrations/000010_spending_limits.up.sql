@@ -0,0 +1,16 @@
+ALTER TABLE "participations" ADD COLUMN "created_at" TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE "tickets" ADD COLUMN "created_at" TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+CREATE INDEX "participations_user_id_created_at_idx" ON "participations" ("user_id", "created_at");
+CREATE INDEX "tickets_buyer_id_created_at_idx" ON "tickets" ("buyer_id", "created_at");
+
+CREATE TABLE "spending_limits" (
+                                   "student_id" INTEGER PRIMARY KEY REFERENCES "users"("id"),
+                                   "daily_limit" INTEGER DEFAULT NULL CHECK ("daily_limit" >= 0),
+                                   "kermesse_limit" INTEGER DEFAULT NULL CHECK ("kermesse_limit" >= 0),
+                                   "purchase_limit" INTEGER DEFAULT NULL CHECK ("purchase_limit" >= 0),
+                                   "food_limit" INTEGER DEFAULT NULL CHECK ("food_limit" >= 0),
+                                   "game_limit" INTEGER DEFAULT NULL CHECK ("game_limit" >= 0),
+                                   "tombola_limit" INTEGER DEFAULT NULL CHECK ("tombola_limit" >= 0),
+                                   "updated_at" TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
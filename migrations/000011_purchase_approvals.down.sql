@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS "purchase_requests";
+
+ALTER TABLE "spending_limits" DROP COLUMN IF EXISTS "approval_threshold";
+
+DROP TYPE IF EXISTS purchase_request_status_enum;
+DROP TYPE IF EXISTS purchase_kind_enum;
@@ -0,0 +1,26 @@
+CREATE TYPE purchase_kind_enum AS ENUM ('PARTICIPATION', 'TICKET');
+CREATE TYPE purchase_request_status_enum AS ENUM ('PENDING', 'APPROVED', 'DENIED', 'EXPIRED', 'FAILED');
+
+ALTER TABLE "spending_limits" ADD COLUMN "approval_threshold" INTEGER DEFAULT NULL CHECK ("approval_threshold" >= 0);
+
+CREATE TABLE "purchase_requests" (
+                                     "id" SERIAL PRIMARY KEY,
+                                     "kind" purchase_kind_enum NOT NULL,
+                                     "student_id" INTEGER NOT NULL REFERENCES "users"("id"),
+                                     "parent_id" INTEGER NOT NULL REFERENCES "users"("id"),
+                                     "kermesse_id" INTEGER NOT NULL REFERENCES "kermesses"("id"),
+                                     "stand_id" INTEGER REFERENCES "stands"("id") DEFAULT NULL,
+                                     "tombola_id" INTEGER REFERENCES "tombolas"("id") DEFAULT NULL,
+                                     "item_name" VARCHAR(255) NOT NULL,
+                                     "quantity" INTEGER NOT NULL CHECK ("quantity" > 0),
+                                     "amount" INTEGER NOT NULL,
+                                     "status" purchase_request_status_enum NOT NULL DEFAULT 'PENDING',
+                                     "reason" TEXT DEFAULT NULL,
+                                     "expires_at" TIMESTAMPTZ NOT NULL,
+                                     "decided_at" TIMESTAMPTZ DEFAULT NULL,
+                                     "created_at" TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX "purchase_requests_status_expires_at_idx" ON "purchase_requests" ("status", "expires_at");
+CREATE INDEX "purchase_requests_parent_id_idx" ON "purchase_requests" ("parent_id");
+CREATE INDEX "purchase_requests_student_id_idx" ON "purchase_requests" ("student_id");
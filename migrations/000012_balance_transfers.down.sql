@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS "balance_transfers";
+
+DROP TYPE IF EXISTS balance_transfer_kind_enum;
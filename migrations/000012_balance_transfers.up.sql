@@ -0,0 +1,14 @@
+CREATE TYPE balance_transfer_kind_enum AS ENUM ('PARENT_TO_STUDENT', 'STUDENT_TO_PARENT', 'STUDENT_TO_STUDENT');
+
+CREATE TABLE "balance_transfers" (
+                                     "id" SERIAL PRIMARY KEY,
+                                     "kind" balance_transfer_kind_enum NOT NULL,
+                                     "from_user_id" INTEGER NOT NULL REFERENCES "users"("id"),
+                                     "to_user_id" INTEGER NOT NULL REFERENCES "users"("id"),
+                                     "initiated_by" INTEGER NOT NULL REFERENCES "users"("id"),
+                                     "amount" INTEGER NOT NULL CHECK ("amount" > 0),
+                                     "created_at" TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX "balance_transfers_from_user_id_idx" ON "balance_transfers" ("from_user_id");
+CREATE INDEX "balance_transfers_to_user_id_idx" ON "balance_transfers" ("to_user_id");
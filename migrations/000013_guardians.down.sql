@@ -0,0 +1,11 @@
+UPDATE "purchase_requests" pr SET "decided_by" = u."parent_id" FROM "users" u WHERE u."id" = pr."student_id" AND pr."decided_by" IS NULL;
+DELETE FROM "purchase_requests" WHERE "decided_by" IS NULL;
+ALTER TABLE "purchase_requests" ALTER COLUMN "decided_by" SET NOT NULL;
+ALTER TABLE "purchase_requests" RENAME COLUMN "decided_by" TO "parent_id";
+CREATE INDEX "purchase_requests_parent_id_idx" ON "purchase_requests" ("parent_id");
+
+DROP TABLE IF EXISTS "guardian_invitations";
+DROP TABLE IF EXISTS "student_guardians";
+
+DROP TYPE IF EXISTS guardian_invitation_status_enum;
+DROP TYPE IF EXISTS guardian_role_enum;
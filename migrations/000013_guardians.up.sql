@@ -0,0 +1,34 @@
+CREATE TYPE guardian_role_enum AS ENUM ('PRIMARY', 'SECONDARY');
+CREATE TYPE guardian_invitation_status_enum AS ENUM ('PENDING', 'ACCEPTED', 'DECLINED');
+
+CREATE TABLE "student_guardians" (
+                                     "student_id" INTEGER NOT NULL REFERENCES "users"("id"),
+                                     "guardian_id" INTEGER NOT NULL REFERENCES "users"("id"),
+                                     "role" guardian_role_enum NOT NULL,
+                                     "created_at" TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                                     PRIMARY KEY ("student_id", "guardian_id")
+);
+
+CREATE UNIQUE INDEX "student_guardians_primary_idx" ON "student_guardians" ("student_id") WHERE "role" = 'PRIMARY';
+CREATE INDEX "student_guardians_guardian_id_idx" ON "student_guardians" ("guardian_id");
+
+INSERT INTO "student_guardians" ("student_id", "guardian_id", "role")
+SELECT "id", "parent_id", 'PRIMARY' FROM "users" WHERE "parent_id" IS NOT NULL;
+
+CREATE TABLE "guardian_invitations" (
+                                        "id" SERIAL PRIMARY KEY,
+                                        "student_id" INTEGER NOT NULL REFERENCES "users"("id"),
+                                        "email" VARCHAR(255) NOT NULL,
+                                        "invited_by" INTEGER NOT NULL REFERENCES "users"("id"),
+                                        "status" guardian_invitation_status_enum NOT NULL DEFAULT 'PENDING',
+                                        "created_at" TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                                        "responded_at" TIMESTAMPTZ DEFAULT NULL
+);
+
+CREATE UNIQUE INDEX "guardian_invitations_pending_idx" ON "guardian_invitations" ("student_id", LOWER("email")) WHERE "status" = 'PENDING';
+
+-- any guardian of the student may decide, the one who did is kept
+ALTER TABLE "purchase_requests" RENAME COLUMN "parent_id" TO "decided_by";
+ALTER TABLE "purchase_requests" ALTER COLUMN "decided_by" DROP NOT NULL;
+UPDATE "purchase_requests" SET "decided_by" = NULL WHERE "status" IN ('PENDING', 'EXPIRED');
+DROP INDEX IF EXISTS "purchase_requests_parent_id_idx";
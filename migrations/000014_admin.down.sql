@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS "audit_events";
+
+-- enum values cannot be dropped, the type is rebuilt and the administrators go
+-- back to organizers
+UPDATE "users" SET "role" = 'ORGANIZER' WHERE "role" = 'ADMIN';
+ALTER TYPE users_role_enum RENAME TO users_role_enum_old;
+CREATE TYPE users_role_enum AS ENUM ('PARENT', 'STUDENT', 'ORGANIZER', 'STAND_HOLDER');
+ALTER TABLE "users" ALTER COLUMN "role" TYPE users_role_enum USING "role"::TEXT::users_role_enum;
+DROP TYPE users_role_enum_old;
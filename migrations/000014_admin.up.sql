@@ -0,0 +1,16 @@
+ALTER TYPE users_role_enum ADD VALUE IF NOT EXISTS 'ADMIN';
+
+CREATE TABLE "audit_events" (
+                                "id" SERIAL PRIMARY KEY,
+                                "actor_id" INTEGER REFERENCES "users"("id"),
+                                "action" VARCHAR(100) NOT NULL,
+                                "target_type" VARCHAR(50) NOT NULL,
+                                "target_id" INTEGER NOT NULL,
+                                "reason" TEXT DEFAULT NULL,
+                                "details" JSONB NOT NULL DEFAULT '{}',
+                                "created_at" TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX "audit_events_target_idx" ON "audit_events" ("target_type", "target_id");
+CREATE INDEX "audit_events_actor_id_idx" ON "audit_events" ("actor_id");
+CREATE INDEX "audit_events_created_at_idx" ON "audit_events" ("created_at");
@@ -0,0 +1,15 @@
+DROP INDEX IF EXISTS "audit_events_kermesse_id_idx";
+
+-- only the administrator events had their changes in details
+UPDATE "audit_events"
+SET "details" = "details" || jsonb_build_object(
+        'from', (SELECT "value"->'before' FROM jsonb_each("changes") LIMIT 1),
+        'to', (SELECT "value"->'after' FROM jsonb_each("changes") LIMIT 1)
+    )
+WHERE "action" IN ('user.role_changed', 'user.balance_adjusted', 'kermesse.force_completed', 'kermesse.reopened')
+  AND "changes" <> '{}';
+
+ALTER TABLE "audit_events" DROP COLUMN IF EXISTS "request_id";
+ALTER TABLE "audit_events" DROP COLUMN IF EXISTS "ip";
+ALTER TABLE "audit_events" DROP COLUMN IF EXISTS "changes";
+ALTER TABLE "audit_events" DROP COLUMN IF EXISTS "kermesse_id";
@@ -0,0 +1,22 @@
+ALTER TABLE "audit_events" ADD COLUMN "kermesse_id" INTEGER REFERENCES "kermesses"("id");
+ALTER TABLE "audit_events" ADD COLUMN "changes" JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE "audit_events" ADD COLUMN "ip" VARCHAR(45) DEFAULT NULL;
+ALTER TABLE "audit_events" ADD COLUMN "request_id" VARCHAR(64) DEFAULT NULL;
+
+-- the administrator events stored what changed in details, as from and to
+UPDATE "audit_events"
+SET "changes" = jsonb_build_object(
+        CASE "action"
+            WHEN 'user.role_changed' THEN 'role'
+            WHEN 'user.balance_adjusted' THEN 'balance'
+            ELSE 'status'
+        END,
+        jsonb_build_object('before', "details"->'from', 'after', "details"->'to')
+    ),
+    "details" = "details" - 'from' - 'to'
+WHERE "details" ? 'from';
+
+UPDATE "audit_events" SET "kermesse_id" = "target_id" WHERE "target_type" = 'KERMESSE';
+UPDATE "audit_events" SET "kermesse_id" = (SELECT "kermesse_id" FROM "tombolas" WHERE "tombolas"."id" = "target_id") WHERE "target_type" = 'TOMBOLA';
+
+CREATE INDEX "audit_events_kermesse_id_idx" ON "audit_events" ("kermesse_id", "created_at");
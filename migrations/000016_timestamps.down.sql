@@ -0,0 +1,37 @@
+-- the deleted rows are removed first, they would break the unique constraints
+-- restored below
+DROP INDEX IF EXISTS "stands_user_id_key";
+DROP INDEX IF EXISTS "users_email_key";
+ALTER TABLE "stands" ADD CONSTRAINT "stands_user_id_key" UNIQUE ("user_id");
+ALTER TABLE "users" ADD CONSTRAINT "users_email_key" UNIQUE ("email");
+
+DROP TRIGGER IF EXISTS "tickets_set_updated_at" ON "tickets";
+DROP TRIGGER IF EXISTS "participations_set_updated_at" ON "participations";
+DROP TRIGGER IF EXISTS "tombolas_set_updated_at" ON "tombolas";
+DROP TRIGGER IF EXISTS "kermesses_set_updated_at" ON "kermesses";
+DROP TRIGGER IF EXISTS "stands_set_updated_at" ON "stands";
+DROP TRIGGER IF EXISTS "users_set_updated_at" ON "users";
+
+ALTER TABLE "tickets" DROP COLUMN IF EXISTS "deleted_at";
+ALTER TABLE "tickets" DROP COLUMN IF EXISTS "updated_at";
+
+ALTER TABLE "participations" DROP COLUMN IF EXISTS "deleted_at";
+ALTER TABLE "participations" DROP COLUMN IF EXISTS "updated_at";
+
+ALTER TABLE "tombolas" DROP COLUMN IF EXISTS "deleted_at";
+ALTER TABLE "tombolas" DROP COLUMN IF EXISTS "updated_at";
+ALTER TABLE "tombolas" DROP COLUMN IF EXISTS "created_at";
+
+ALTER TABLE "kermesses" DROP COLUMN IF EXISTS "deleted_at";
+ALTER TABLE "kermesses" DROP COLUMN IF EXISTS "updated_at";
+ALTER TABLE "kermesses" DROP COLUMN IF EXISTS "created_at";
+
+ALTER TABLE "stands" DROP COLUMN IF EXISTS "deleted_at";
+ALTER TABLE "stands" DROP COLUMN IF EXISTS "updated_at";
+ALTER TABLE "stands" DROP COLUMN IF EXISTS "created_at";
+
+ALTER TABLE "users" DROP COLUMN IF EXISTS "deleted_at";
+ALTER TABLE "users" DROP COLUMN IF EXISTS "updated_at";
+ALTER TABLE "users" DROP COLUMN IF EXISTS "created_at";
+
+DROP FUNCTION IF EXISTS set_updated_at();
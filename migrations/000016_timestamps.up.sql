@@ -0,0 +1,46 @@
+CREATE OR REPLACE FUNCTION set_updated_at() RETURNS TRIGGER AS $$
+BEGIN
+    NEW."updated_at" = NOW();
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE "users" ADD COLUMN "created_at" TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE "users" ADD COLUMN "updated_at" TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE "users" ADD COLUMN "deleted_at" TIMESTAMPTZ DEFAULT NULL;
+
+ALTER TABLE "stands" ADD COLUMN "created_at" TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE "stands" ADD COLUMN "updated_at" TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE "stands" ADD COLUMN "deleted_at" TIMESTAMPTZ DEFAULT NULL;
+
+ALTER TABLE "kermesses" ADD COLUMN "created_at" TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE "kermesses" ADD COLUMN "updated_at" TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE "kermesses" ADD COLUMN "deleted_at" TIMESTAMPTZ DEFAULT NULL;
+
+ALTER TABLE "tombolas" ADD COLUMN "created_at" TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE "tombolas" ADD COLUMN "updated_at" TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE "tombolas" ADD COLUMN "deleted_at" TIMESTAMPTZ DEFAULT NULL;
+
+-- participations and tickets have a created_at since 000010_spending_limits
+ALTER TABLE "participations" ADD COLUMN "updated_at" TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE "participations" ADD COLUMN "deleted_at" TIMESTAMPTZ DEFAULT NULL;
+
+ALTER TABLE "tickets" ADD COLUMN "updated_at" TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE "tickets" ADD COLUMN "deleted_at" TIMESTAMPTZ DEFAULT NULL;
+
+UPDATE "participations" SET "updated_at" = "created_at";
+UPDATE "tickets" SET "updated_at" = COALESCE("delivered_at", "claimed_at", "created_at");
+
+CREATE TRIGGER "users_set_updated_at" BEFORE UPDATE ON "users" FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+CREATE TRIGGER "stands_set_updated_at" BEFORE UPDATE ON "stands" FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+CREATE TRIGGER "kermesses_set_updated_at" BEFORE UPDATE ON "kermesses" FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+CREATE TRIGGER "tombolas_set_updated_at" BEFORE UPDATE ON "tombolas" FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+CREATE TRIGGER "participations_set_updated_at" BEFORE UPDATE ON "participations" FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+CREATE TRIGGER "tickets_set_updated_at" BEFORE UPDATE ON "tickets" FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+
+-- a deleted account frees its email, and a deleted stand lets its holder open
+-- another one
+ALTER TABLE "users" DROP CONSTRAINT "users_email_key";
+CREATE UNIQUE INDEX "users_email_key" ON "users" ("email") WHERE "deleted_at" IS NULL;
+ALTER TABLE "stands" DROP CONSTRAINT "stands_user_id_key";
+CREATE UNIQUE INDEX "stands_user_id_key" ON "stands" ("user_id") WHERE "deleted_at" IS NULL;
@@ -0,0 +1,11 @@
+DROP TRIGGER IF EXISTS "participations_increment_version" ON "participations";
+DROP TRIGGER IF EXISTS "tombolas_increment_version" ON "tombolas";
+DROP TRIGGER IF EXISTS "stands_increment_version" ON "stands";
+DROP TRIGGER IF EXISTS "kermesses_increment_version" ON "kermesses";
+
+ALTER TABLE "participations" DROP COLUMN IF EXISTS "version";
+ALTER TABLE "tombolas" DROP COLUMN IF EXISTS "version";
+ALTER TABLE "stands" DROP COLUMN IF EXISTS "version";
+ALTER TABLE "kermesses" DROP COLUMN IF EXISTS "version";
+
+DROP FUNCTION IF EXISTS increment_version();
@@ -0,0 +1,18 @@
+-- every update bumps the version, it is the ETag of the row and lets the
+-- update endpoints refuse writes based on a stale read
+CREATE OR REPLACE FUNCTION increment_version() RETURNS TRIGGER AS $$
+BEGIN
+    NEW."version" = OLD."version" + 1;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE "kermesses" ADD COLUMN "version" INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE "stands" ADD COLUMN "version" INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE "tombolas" ADD COLUMN "version" INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE "participations" ADD COLUMN "version" INTEGER NOT NULL DEFAULT 1;
+
+CREATE TRIGGER "kermesses_increment_version" BEFORE UPDATE ON "kermesses" FOR EACH ROW EXECUTE FUNCTION increment_version();
+CREATE TRIGGER "stands_increment_version" BEFORE UPDATE ON "stands" FOR EACH ROW EXECUTE FUNCTION increment_version();
+CREATE TRIGGER "tombolas_increment_version" BEFORE UPDATE ON "tombolas" FOR EACH ROW EXECUTE FUNCTION increment_version();
+CREATE TRIGGER "participations_increment_version" BEFORE UPDATE ON "participations" FOR EACH ROW EXECUTE FUNCTION increment_version();
@@ -0,0 +1,14 @@
+CREATE TABLE "idempotency_keys" (
+                                    "user_id" INTEGER NOT NULL REFERENCES "users"("id"),
+                                    "key" VARCHAR(255) NOT NULL,
+                                    "method" VARCHAR(10) NOT NULL,
+                                    "path" TEXT NOT NULL,
+                                    "request_hash" CHAR(64) NOT NULL,
+                                    "status_code" INTEGER DEFAULT NULL,
+                                    "headers" JSONB NOT NULL DEFAULT '{}',
+                                    "body" BYTEA DEFAULT NULL,
+                                    "created_at" TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                                    PRIMARY KEY ("user_id", "key")
+);
+
+CREATE INDEX "idempotency_keys_created_at_idx" ON "idempotency_keys" ("created_at");
@@ -0,0 +1,11 @@
+-- only used when RATE_LIMIT_STORE is postgres, the buckets are kept in memory
+-- otherwise
+CREATE UNLOGGED TABLE "rate_limit_buckets" (
+                                               "key" TEXT PRIMARY KEY,
+                                               "tokens" DOUBLE PRECISION NOT NULL,
+                                               "allowed" BOOLEAN NOT NULL,
+                                               "updated_at" TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+                                               "full_at" TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX "rate_limit_buckets_full_at_idx" ON "rate_limit_buckets" ("full_at");
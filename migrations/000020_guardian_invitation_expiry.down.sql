@@ -0,0 +1,13 @@
+ALTER TABLE "guardian_invitations" DROP COLUMN IF EXISTS "expires_at";
+
+-- enum values cannot be dropped, the type is rebuilt and the expired
+-- invitations are kept as declined
+UPDATE "guardian_invitations" SET "status" = 'DECLINED' WHERE "status" = 'EXPIRED';
+DROP INDEX IF EXISTS "guardian_invitations_pending_idx";
+ALTER TABLE "guardian_invitations" ALTER COLUMN "status" DROP DEFAULT;
+ALTER TYPE guardian_invitation_status_enum RENAME TO guardian_invitation_status_enum_old;
+CREATE TYPE guardian_invitation_status_enum AS ENUM ('PENDING', 'ACCEPTED', 'DECLINED');
+ALTER TABLE "guardian_invitations" ALTER COLUMN "status" TYPE guardian_invitation_status_enum USING "status"::TEXT::guardian_invitation_status_enum;
+ALTER TABLE "guardian_invitations" ALTER COLUMN "status" SET DEFAULT 'PENDING';
+DROP TYPE guardian_invitation_status_enum_old;
+CREATE UNIQUE INDEX "guardian_invitations_pending_idx" ON "guardian_invitations" ("student_id", LOWER("email")) WHERE "status" = 'PENDING';
@@ -0,0 +1,5 @@
+ALTER TYPE guardian_invitation_status_enum ADD VALUE IF NOT EXISTS 'EXPIRED';
+
+ALTER TABLE "guardian_invitations" ADD COLUMN "expires_at" TIMESTAMPTZ;
+UPDATE "guardian_invitations" SET "expires_at" = "created_at" + INTERVAL '7 days';
+ALTER TABLE "guardian_invitations" ALTER COLUMN "expires_at" SET NOT NULL;
@@ -0,0 +1,12 @@
+-- single-use tickets opening the Server-Sent Events stream, for the browsers
+-- whose EventSource cannot send the Authorization header. Only the SHA-256 of
+-- the ticket is kept.
+CREATE TABLE "stream_tickets" (
+                                  "ticket_hash" TEXT PRIMARY KEY,
+                                  "user_id" INTEGER NOT NULL REFERENCES "users"("id") ON DELETE CASCADE,
+                                  "session_expires_at" TIMESTAMPTZ NOT NULL,
+                                  "expires_at" TIMESTAMPTZ NOT NULL,
+                                  "created_at" TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX "stream_tickets_expires_at_idx" ON "stream_tickets" ("expires_at");
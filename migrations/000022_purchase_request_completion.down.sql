@@ -0,0 +1 @@
+ALTER TABLE "purchase_requests" DROP COLUMN IF EXISTS "completed_at";
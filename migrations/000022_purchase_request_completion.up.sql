@@ -0,0 +1,3 @@
+-- an approved request keeps its reservation until its purchase is completed
+ALTER TABLE "purchase_requests" ADD COLUMN "completed_at" TIMESTAMPTZ DEFAULT NULL;
+UPDATE "purchase_requests" SET "completed_at" = "decided_at" WHERE "status" = 'APPROVED';
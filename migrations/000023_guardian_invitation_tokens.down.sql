@@ -0,0 +1 @@
+ALTER TABLE "guardian_invitations" DROP COLUMN IF EXISTS "token_hash";
@@ -0,0 +1,5 @@
+-- the invitations are answered with the token of the emailed link, only its
+-- SHA-256 is kept. The pending invitations sent without one can no longer be
+-- answered, they expire and the student can be invited again.
+ALTER TABLE "guardian_invitations" ADD COLUMN "token_hash" TEXT DEFAULT NULL;
+UPDATE "guardian_invitations" SET "status" = 'EXPIRED' WHERE "status" = 'PENDING';
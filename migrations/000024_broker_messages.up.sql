@@ -0,0 +1,10 @@
+-- messages fanned out to the instances: NOTIFY only carries the id, since its
+-- payload is limited to 8000 bytes, and every instance loads the message from
+-- here. They are purged once every instance had the time to read them.
+CREATE TABLE "broker_messages" (
+                                   "id" BIGSERIAL PRIMARY KEY,
+                                   "payload" TEXT NOT NULL,
+                                   "created_at" TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX "broker_messages_created_at_idx" ON "broker_messages" ("created_at");
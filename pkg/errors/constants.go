@@ -19,4 +19,11 @@ const (
 	InvalidCredentials = "INVALID_CREDENTIALS"
 	InvalidCode        = "INVALID_CODE"
 	ExpiredCode        = "EXPIRED_CODE"
+
+	DailyLimitExceeded    = "DAILY_LIMIT_EXCEEDED"
+	KermesseLimitExceeded = "KERMESSE_LIMIT_EXCEEDED"
+	CategoryLimitExceeded = "CATEGORY_LIMIT_EXCEEDED"
+	PurchaseLimitExceeded = "PURCHASE_LIMIT_EXCEEDED"
+
+	IdempotencyKeyReused = "IDEMPOTENCY_KEY_REUSED"
 )
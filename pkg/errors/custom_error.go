@@ -15,20 +15,20 @@ func (ce CustomError) StatusCode() int {
 	switch ce.Key {
 	case BadRequest:
 		return http.StatusBadRequest
-	case Unauthorized:
-	case InvalidCredentials:
-	case InvalidCode:
-	case ExpiredCode:
+	case Unauthorized, InvalidCredentials, InvalidCode, ExpiredCode:
 		return http.StatusUnauthorized
 	case Forbidden:
 		return http.StatusForbidden
+	case DailyLimitExceeded, KermesseLimitExceeded, CategoryLimitExceeded, PurchaseLimitExceeded:
+		return http.StatusForbidden
 	case NotFound:
 		return http.StatusNotFound
 	case MethodNotAllowed:
 		return http.StatusMethodNotAllowed
-	case Conflict:
-	case EmailAlreadyExists:
+	case Conflict, EmailAlreadyExists:
 		return http.StatusConflict
+	case IdempotencyKeyReused:
+		return http.StatusUnprocessableEntity
 	case UnsupportedMediaType:
 		return http.StatusUnsupportedMediaType
 	case TooManyRequests:
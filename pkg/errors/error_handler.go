@@ -9,6 +9,7 @@ type ErrorHandler func(w http.ResponseWriter, r *http.Request) error
 func (f ErrorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if err := f(w, r); err != nil {
 		if e, ok := err.(CustomError); ok {
+			w.Header().Set("X-Error-Code", e.Key)
 			http.Error(w, e.Err.Error(), e.StatusCode())
 			return
 		}
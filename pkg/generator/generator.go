@@ -25,3 +25,21 @@ func RandomPassword(length int) (string, error) {
 	// Trim to desired length
 	return password[:length], nil
 }
+
+// codeAlphabet leaves out characters that are easily mistaken for one another
+// when read aloud or typed, such as 0/O and 1/I.
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+func RandomCode(length int) (string, error) {
+	randomBytes := make([]byte, length)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	code := make([]byte, length)
+	for i, b := range randomBytes {
+		code[i] = codeAlphabet[int(b)%len(codeAlphabet)]
+	}
+	return string(code), nil
+}
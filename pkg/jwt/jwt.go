@@ -52,3 +52,23 @@ func GetTokenUserId(tokenString, secret string) (int, error) {
 
 	return userId, nil
 }
+
+// GetTokenExpiration returns the expiration date written in the token by Create.
+func GetTokenExpiration(tokenString, secret string) (time.Time, error) {
+	token, err := Validate(tokenString, secret)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if !token.Valid {
+		return time.Time{}, fmt.Errorf("invalid token")
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	expiresAt, ok := claims["expiresAt"].(float64)
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid token expiration")
+	}
+
+	return time.Unix(int64(expiresAt), 0), nil
+}
@@ -2,7 +2,10 @@ package utils
 
 import (
 	"fmt"
+	"github.com/jmoiron/sqlx"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 func ConvertToInt(input map[string]interface{}, key string) (int, error) {
@@ -29,3 +32,40 @@ func GetParams(r *http.Request) map[string]interface{} {
 	}
 	return params
 }
+
+// SetETag sets the version of the resource as its ETag, for the client to send
+// it back in the If-Match header of its updates.
+func SetETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(version)))
+}
+
+// GetIfMatch returns the version the If-Match header expects, 0 when the
+// header is missing or matches any version.
+func GetIfMatch(r *http.Request) (int, error) {
+	value := strings.TrimSpace(r.Header.Get("If-Match"))
+	if value == "" || value == "*" {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(strings.Trim(value, `"`))
+	if err != nil || version <= 0 {
+		return 0, fmt.Errorf("invalid If-Match header %s", value)
+	}
+	return version, nil
+}
+
+// ExecSingleRow runs a guarded update that must change a row, it returns
+// notFound when the query matched none.
+func ExecSingleRow(execer sqlx.Execer, notFound error, query string, args ...interface{}) error {
+	result, err := execer.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return notFound
+	}
+	return nil
+}
@@ -2,9 +2,11 @@ package database
 
 import (
 	"fmt"
+	"log"
+	"time"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type PostgresConfig struct {
@@ -15,14 +17,27 @@ type PostgresConfig struct {
 	Name     string
 }
 
-func NewPostgres(config PostgresConfig) (*sqlx.DB, error) {
-	dsn := fmt.Sprintf(
+func (config PostgresConfig) dsn() string {
+	return fmt.Sprintf(
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=require",
 		config.Host, config.Port, config.User, config.Password, config.Name,
 	)
-	db, err := sqlx.Connect("postgres", dsn)
+}
+
+func NewPostgres(config PostgresConfig) (*sqlx.DB, error) {
+	db, err := sqlx.Connect("postgres", config.dsn())
 	if err != nil {
 		return nil, err
 	}
 	return db, nil
 }
+
+// NewListener opens a dedicated connection for LISTEN, which is reopened
+// automatically when it is lost.
+func NewListener(config PostgresConfig) *pq.Listener {
+	return pq.NewListener(config.dsn(), 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Listener connection error: %v", err)
+		}
+	})
+}